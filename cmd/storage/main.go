@@ -5,12 +5,21 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/veloxpack/storage/pkg/backend"
 	"github.com/veloxpack/storage/pkg/backend/server"
+	"github.com/veloxpack/storage/pkg/backend/server/cache"
+	"github.com/veloxpack/storage/pkg/backend/server/events"
+	"github.com/veloxpack/storage/pkg/backend/server/policy"
+	"github.com/veloxpack/storage/pkg/backend/server/ratelimit"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/s3"
+	"github.com/veloxpack/storage/pkg/backend/server/tracing"
 	"github.com/veloxpack/storage/pkg/storage"
 	"go.uber.org/zap"
 )
@@ -20,17 +29,133 @@ func main() {
 	defer logger.Sync()
 	zap.ReplaceGlobals(logger)
 
+	if exporter := os.Getenv("STORAGE_TRACING_EXPORTER"); exporter != "" {
+		shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+			ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+			Exporter:    tracing.Exporter(exporter),
+			Endpoint:    os.Getenv("STORAGE_TRACING_ENDPOINT"),
+			FilePath:    os.Getenv("STORAGE_TRACING_FILE"),
+			SampleRatio: envFloat("STORAGE_TRACING_SAMPLE_RATIO", 1),
+		})
+		if err != nil {
+			logger.Fatal("failed to set up tracing", zap.Error(err))
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				logger.Error("failed to flush traces", zap.Error(err))
+			}
+		}()
+	}
+
 	be := backend.NewStorageBackend(
 		storage.WithDriver(os.Getenv("STORAGE_DRIVER")),
 		storage.WithOutputLocation(os.Getenv("STORAGE_OUTPUT_LOCATION")),
 	)
 
-	storageServer, err := be.Server(
+	serverOpts := []server.ServerOption{
 		server.WithLogger(logger),
 		server.WithHTTPAddr(os.Getenv("STORAGE_ADDR")),
-		server.WithDeletePoolSize(5),
-		server.WithUploadPoolSize(5),
-	)
+		server.WithDeletePoolSize(envInt("STORAGE_DELETE_POOL_SIZE", 5)),
+		server.WithUploadPoolSize(envInt("STORAGE_UPLOAD_POOL_SIZE", 5)),
+		server.WithPoolQueueSize(envInt("STORAGE_POOL_QUEUE_SIZE", 64)),
+		server.WithPoolMaxWait(envDuration("STORAGE_POOL_MAX_WAIT", 0)),
+		server.WithTracing(os.Getenv("STORAGE_TRACING_EXPORTER") != ""),
+		server.WithMinFreeDisk(uint64(envInt("STORAGE_MIN_FREE_DISK_BYTES", 0))),
+		server.WithSyncWrites(os.Getenv("STORAGE_SYNC_WRITES") == "true"),
+		server.WithSyncTimeout(envDuration("STORAGE_SYNC_TIMEOUT", 0)),
+		server.WithLiveWriteTimeout(envDuration("STORAGE_LIVE_WRITE_TIMEOUT", 0)),
+		server.WithLiveBuffer(int64(envInt("STORAGE_LIVE_WINDOW", 0)), int64(envInt("STORAGE_LIVE_MEMORY", 0))),
+		server.WithMaxChunkedUploadSize(int64(envInt("STORAGE_MAX_CHUNKED_UPLOAD_SIZE", 0))),
+		server.WithBlockingReloadTimeout(envDuration("STORAGE_HLS_BLOCKING_TIMEOUT", 0)),
+		server.WithQueueDir(os.Getenv("STORAGE_QUEUE_DIR")),
+		server.WithDeadLetterDir(os.Getenv("STORAGE_DEADLETTER_DIR")),
+		server.WithAdminToken(os.Getenv("STORAGE_ADMIN_TOKEN")),
+		server.WithTusDir(os.Getenv("STORAGE_TUS_DIR")),
+		server.WithTusMaxSize(int64(envInt("STORAGE_TUS_MAX_SIZE", 0))),
+		server.WithTusExpiration(envDuration("STORAGE_TUS_EXPIRATION", 0)),
+		server.WithDavPrefix(os.Getenv("STORAGE_DAV_PREFIX")),
+		server.WithMaxPartSize(int64(envInt("STORAGE_MAX_PART_SIZE", 0))),
+		server.WithExtractLimits(envInt("STORAGE_EXTRACT_MAX_ENTRIES", 0), int64(envInt("STORAGE_EXTRACT_MAX_SIZE", 0))),
+		server.WithBatchConcurrency(envInt("STORAGE_BATCH_CONCURRENCY", 0)),
+	}
+
+	if prefixes := os.Getenv("STORAGE_LIVE_PREFIXES"); prefixes != "" {
+		serverOpts = append(serverOpts, server.WithLivePrefixes(strings.Split(prefixes, ",")))
+	}
+
+	retryPolicy := retry.DefaultPolicy()
+	retryPolicy.MaxAttempts = envInt("STORAGE_RETRY_MAX_ATTEMPTS", retryPolicy.MaxAttempts)
+	retryPolicy.InitialBackoff = envDuration("STORAGE_RETRY_INITIAL_BACKOFF", retryPolicy.InitialBackoff)
+	retryPolicy.MaxBackoff = envDuration("STORAGE_RETRY_MAX_BACKOFF", retryPolicy.MaxBackoff)
+	serverOpts = append(serverOpts, server.WithRetryPolicy(retryPolicy))
+
+	if policyFile := os.Getenv("STORAGE_POLICY_FILE"); policyFile != "" {
+		engine, err := policy.Load(policyFile)
+		if err != nil {
+			logger.Fatal("failed to load access policy", zap.Error(err))
+		}
+		serverOpts = append(serverOpts,
+			server.WithPolicy(engine),
+			server.WithPolicyDryRun(os.Getenv("STORAGE_POLICY_DRY_RUN") == "true"),
+		)
+	}
+
+	if cacheFile := os.Getenv("STORAGE_CACHE_RULES_FILE"); cacheFile != "" {
+		rules, err := cache.Load(cacheFile)
+		if err != nil {
+			logger.Fatal("failed to load cache rules", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithCachePolicy(rules))
+	}
+
+	if webhooksFile := os.Getenv("STORAGE_WEBHOOKS_FILE"); webhooksFile != "" {
+		webhooks, err := events.LoadWebhooks(webhooksFile)
+		if err != nil {
+			logger.Fatal("failed to load webhooks", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, server.WithWebhooks(webhooks))
+	}
+
+	if addr := os.Getenv("STORAGE_S3_ADDR"); addr != "" {
+		s3Cfg := s3.Config{
+			Addr:         addr,
+			Region:       os.Getenv("STORAGE_S3_REGION"),
+			Domain:       os.Getenv("STORAGE_S3_DOMAIN"),
+			MultipartDir: os.Getenv("STORAGE_S3_MULTIPART_DIR"),
+		}
+		if credentialsFile := os.Getenv("STORAGE_S3_CREDENTIALS_FILE"); credentialsFile != "" {
+			credentials, err := s3.LoadCredentials(credentialsFile)
+			if err != nil {
+				logger.Fatal("failed to load S3 credentials", zap.Error(err))
+			}
+			s3Cfg.Credentials = credentials
+		}
+		if accessKey := os.Getenv("STORAGE_S3_ACCESS_KEY"); accessKey != "" {
+			if os.Getenv("STORAGE_S3_SECRET_KEY") == "" {
+				logger.Fatal("STORAGE_S3_SECRET_KEY is required with STORAGE_S3_ACCESS_KEY")
+			}
+			s3Cfg.Credentials = append(s3Cfg.Credentials, s3.Credential{
+				AccessKey: accessKey,
+				SecretKey: os.Getenv("STORAGE_S3_SECRET_KEY"),
+			})
+		}
+		serverOpts = append(serverOpts, server.WithS3(s3Cfg))
+	}
+
+	limits := ratelimit.Config{
+		KeyBy:             ratelimit.KeyBy(os.Getenv("STORAGE_RATE_LIMIT_KEY")),
+		RequestsPerSecond: envFloat("STORAGE_RATE_LIMIT_RPS", 0),
+		Burst:             envInt("STORAGE_RATE_LIMIT_BURST", 0),
+		BytesPerSecond:    int64(envInt("STORAGE_RATE_LIMIT_BYTES_PER_SECOND", 0)),
+		MaxConcurrent:     envInt("STORAGE_RATE_LIMIT_CONCURRENCY", 0),
+	}
+	if limits.RequestsPerSecond > 0 || limits.BytesPerSecond > 0 || limits.MaxConcurrent > 0 {
+		serverOpts = append(serverOpts, server.WithRateLimit(limits))
+	}
+
+	storageServer, err := be.Server(serverOpts...)
 	if err != nil {
 		logger.Fatal("failed to storage backend server", zap.Error(err))
 	}
@@ -65,3 +190,33 @@ func main() {
 		logger.Error("server shutdown failed", zap.Error(err))
 	}
 }
+
+// envInt returns the integer value of the environment variable name, or def
+// when it is unset or malformed.
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envFloat returns the float value of the environment variable name, or def
+// when it is unset or malformed.
+func envFloat(name string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envDuration returns the duration value of the environment variable name,
+// or def when it is unset or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
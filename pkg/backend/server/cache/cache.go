@@ -0,0 +1,227 @@
+// Package cache decides the HTTP caching headers of downloads from rules
+// keyed on path prefix, extension and content type, so manifests and media
+// segments can be cached differently by players and CDNs.
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule sets the caching headers of the objects it matches. A rule matches
+// objects below Prefix whose extension is one of Extensions and whose
+// content type is one of ContentTypes; empty conditions match everything.
+type Rule struct {
+	Prefix     string   `json:"prefix,omitempty"`
+	Extensions []string `json:"extensions,omitempty"`
+	// ContentTypes are media types such as "video/mp2t", or "video/*"
+	ContentTypes []string `json:"content_types,omitempty"`
+
+	// CacheControl is the Cache-Control header; Expires is derived from it.
+	// A rule without one exempts the objects it matches from later rules.
+	CacheControl     string   `json:"cache_control,omitempty"`
+	SurrogateControl string   `json:"surrogate_control,omitempty"`
+	Vary             []string `json:"vary,omitempty"`
+}
+
+// Document is the JSON representation of caching rules.
+type Document struct {
+	Rules []Rule `json:"rules"`
+}
+
+// DefaultRules keep manifests fresh for a second and let players and CDNs
+// keep live streaming segments for good. Other media, such as progressive
+// .mp4 files or captions, may be replaced in place and get no default.
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Extensions:   []string{".m3u8", ".mpd"},
+			CacheControl: "public, max-age=1",
+		},
+		{
+			Extensions:   []string{".ts", ".m4s", ".cmfv", ".cmfa"},
+			CacheControl: "public, max-age=31536000, immutable",
+		},
+	}
+}
+
+// Policy applies the first rule matching an object.
+type Policy struct {
+	rules []*compiledRule
+}
+
+// Default returns the policy of the default rules.
+func Default() *Policy {
+	p, _ := New(DefaultRules())
+	return p
+}
+
+// Load reads caching rules from a JSON file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache rules: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse compiles a JSON caching rules document.
+func Parse(data []byte) (*Policy, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse cache rules: %w", err)
+	}
+	return New(doc.Rules)
+}
+
+// New compiles caching rules.
+func New(rules []Rule) (*Policy, error) {
+	p := &Policy{}
+	for i, rule := range rules {
+		cr, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		p.rules = append(p.rules, cr)
+	}
+	return p, nil
+}
+
+type compiledRule struct {
+	Rule
+	extensions map[string]bool
+	// maxAge is the max-age of CacheControl, negative if the response must
+	// not be reused and nil if it does not say
+	maxAge *time.Duration
+}
+
+func compileRule(rule Rule) (*compiledRule, error) {
+	cr := &compiledRule{Rule: rule}
+	cr.Prefix = strings.TrimPrefix(rule.Prefix, "/")
+
+	if len(rule.Extensions) > 0 {
+		cr.extensions = make(map[string]bool, len(rule.Extensions))
+		for _, ext := range rule.Extensions {
+			if ext == "" {
+				return nil, fmt.Errorf("empty extension")
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			cr.extensions[strings.ToLower(ext)] = true
+		}
+	}
+
+	for _, ct := range rule.ContentTypes {
+		if _, _, err := mime.ParseMediaType(ct); err != nil && !strings.HasSuffix(ct, "/*") {
+			return nil, fmt.Errorf("invalid content type %q: %w", ct, err)
+		}
+	}
+
+	maxAge, err := parseMaxAge(rule.CacheControl)
+	if err != nil {
+		return nil, err
+	}
+	cr.maxAge = maxAge
+	return cr, nil
+}
+
+// parseMaxAge returns how long a response with the Cache-Control header
+// value may be reused.
+func parseMaxAge(value string) (*time.Duration, error) {
+	var maxAge *time.Duration
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			expired := time.Duration(-1)
+			return &expired, nil
+		case "max-age":
+			seconds, err := strconv.ParseInt(strings.Trim(arg, `"`), 10, 64)
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("invalid max-age %q", arg)
+			}
+			d := time.Duration(seconds) * time.Second
+			maxAge = &d
+		}
+	}
+	return maxAge, nil
+}
+
+func (cr *compiledRule) matches(name, contentType string) bool {
+	if cr.Prefix != "" && !strings.HasPrefix(name, cr.Prefix) {
+		return false
+	}
+	if cr.extensions != nil && !cr.extensions[strings.ToLower(path.Ext(name))] {
+		return false
+	}
+	if len(cr.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, ct := range cr.ContentTypes {
+		if prefix, ok := strings.CutSuffix(ct, "*"); ok && strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+		if strings.EqualFold(ct, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply sets the caching headers of the object name, served as contentType,
+// in h. A Cache-Control value the uploader supplied as override replaces the
+// rule's, along with the Surrogate-Control meant to go with it. A nil policy
+// sets nothing but the override.
+func (p *Policy) Apply(h http.Header, name, contentType, override string) {
+	var rule *compiledRule
+	if p != nil {
+		for _, cr := range p.rules {
+			if cr.matches(strings.TrimPrefix(name, "/"), contentType) {
+				rule = cr
+				break
+			}
+		}
+	}
+
+	cacheControl, surrogateControl := "", ""
+	var maxAge *time.Duration
+	if rule != nil {
+		cacheControl, surrogateControl, maxAge = rule.CacheControl, rule.SurrogateControl, rule.maxAge
+		for _, v := range rule.Vary {
+			h.Add("Vary", v)
+		}
+	}
+	if override != "" {
+		// An override that cannot be parsed is passed on without Expires
+		maxAge, _ = parseMaxAge(override)
+		cacheControl, surrogateControl = override, ""
+	}
+
+	if cacheControl == "" {
+		return
+	}
+	h.Set("Cache-Control", cacheControl)
+	if surrogateControl != "" {
+		h.Set("Surrogate-Control", surrogateControl)
+	}
+	if maxAge != nil {
+		h.Set("Expires", expires(*maxAge))
+	}
+}
+
+func expires(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return time.Unix(0, 0).UTC().Format(http.TimeFormat)
+	}
+	return time.Now().Add(maxAge).UTC().Format(http.TimeFormat)
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Run("should apply the first matching rule", func(t *testing.T) {
+		p, err := Parse([]byte(`{"rules": [
+			{"prefix": "vod/", "extensions": ["m3u8"], "cache_control": "public, max-age=3600"},
+			{"prefix": "live/private/"},
+			{"extensions": [".m3u8"], "cache_control": "no-store", "vary": ["Origin"]},
+			{"content_types": ["video/*"], "cache_control": "public, max-age=86400, immutable", "surrogate_control": "max-age=604800"}
+		]}`))
+		require.NoError(t, err)
+
+		h := http.Header{}
+		p.Apply(h, "vod/film/index.m3u8", "application/vnd.apple.mpegurl", "")
+		assert.Equal(t, "public, max-age=3600", h.Get("Cache-Control"))
+		expires, err := http.ParseTime(h.Get("Expires"))
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expires, 2*time.Second)
+
+		h = http.Header{}
+		p.Apply(h, "/live/abc/index.m3u8", "application/vnd.apple.mpegurl", "")
+		assert.Equal(t, "no-store", h.Get("Cache-Control"))
+		assert.Equal(t, "Thu, 01 Jan 1970 00:00:00 GMT", h.Get("Expires"))
+		assert.Equal(t, "Origin", h.Get("Vary"))
+
+		h = http.Header{}
+		p.Apply(h, "live/abc/seg1.ts", "video/mp2t", "")
+		assert.Equal(t, "public, max-age=86400, immutable", h.Get("Cache-Control"))
+		assert.Equal(t, "max-age=604800", h.Get("Surrogate-Control"))
+
+		// Exempted by the rule without Cache-Control
+		h = http.Header{}
+		p.Apply(h, "live/private/seg1.ts", "video/mp2t", "")
+		assert.Empty(t, h)
+	})
+
+	t.Run("should let the uploader's Cache-Control override the rules", func(t *testing.T) {
+		p, err := New([]Rule{{CacheControl: "public, max-age=86400", SurrogateControl: "max-age=604800"}})
+		require.NoError(t, err)
+
+		h := http.Header{}
+		p.Apply(h, "live/abc/seg1.ts", "video/mp2t", "private, max-age=0")
+		assert.Equal(t, "private, max-age=0", h.Get("Cache-Control"))
+		assert.Empty(t, h.Get("Surrogate-Control"))
+		assert.Equal(t, "Thu, 01 Jan 1970 00:00:00 GMT", h.Get("Expires"))
+
+		h = http.Header{}
+		(*Policy)(nil).Apply(h, "a.txt", "text/plain", "")
+		assert.Empty(t, h)
+	})
+
+	t.Run("should reject invalid rules", func(t *testing.T) {
+		_, err := New([]Rule{{CacheControl: "max-age=soon"}})
+		assert.Error(t, err)
+		_, err = New([]Rule{{ContentTypes: []string{"video/"}}})
+		assert.Error(t, err)
+		_, err = Parse([]byte(`{"rules": {}}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("should cache segments for good and manifests briefly by default", func(t *testing.T) {
+		h := http.Header{}
+		Default().Apply(h, "live/abc/seg1.m4s", mime.TypeByExtension(".m4s"), "")
+		assert.Equal(t, "public, max-age=31536000, immutable", h.Get("Cache-Control"))
+
+		h = http.Header{}
+		Default().Apply(h, "live/abc/index.mpd", mime.TypeByExtension(".mpd"), "")
+		assert.Equal(t, "public, max-age=1", h.Get("Cache-Control"))
+
+		for _, name := range []string{"vod/movie.mp4", "vod/subs/en.vtt", "radio/track.aac"} {
+			h = http.Header{}
+			Default().Apply(h, name, mime.TypeByExtension(path.Ext(name)), "")
+			assert.Empty(t, h.Get("Cache-Control"), name)
+		}
+	})
+}
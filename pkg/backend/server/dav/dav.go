@@ -0,0 +1,205 @@
+// Package dav serves the storage over WebDAV so it can be mounted by
+// Finder, Windows Explorer or davfs2.
+//
+// Reads go to the storage directly; writes and deletes go through the same
+// save and remove paths as the main handler, so they are ordered per path
+// and publish events. Since objects can only be replaced as a whole, a move
+// copies and then removes every object.
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// DefaultPrefix is where the WebDAV tree is served unless configured.
+const DefaultPrefix = "/_dav/"
+
+type contextKey string
+
+const (
+	bodyContextKey      contextKey = "davBody"
+	authorizeContextKey contextKey = "davAuthorize"
+)
+
+// SaveFunc writes content to path.
+type SaveFunc func(ctx context.Context, path string, content io.Reader) error
+
+// RemoveFunc deletes path.
+type RemoveFunc func(ctx context.Context, path string) error
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithAuthorizer checks every request against the access policy, along with
+// the destination of copies and moves and every object below the collections
+// they and deletes affect. Listings leave out what the caller may not read.
+func WithAuthorizer(authorize middleware.Authorizer) Option {
+	return func(h *Handler) {
+		h.authorize = authorize
+	}
+}
+
+// Handler serves the storage over WebDAV below a prefix.
+type Handler struct {
+	prefix    string
+	dav       *webdav.Handler
+	fsys      *fileSystem
+	authorize middleware.Authorizer
+	logger    *zap.Logger
+}
+
+// New creates a handler serving storage below prefix. Locks are held in
+// memory.
+func New(prefix string, storage provider.Storage, save SaveFunc, remove RemoveFunc, opts ...Option) *Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+
+	h := &Handler{
+		prefix:    prefix,
+		authorize: middleware.AllowAll,
+		logger:    zap.L().Named("dav"),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.fsys = newFileSystem(storage, save, remove)
+	h.dav = &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: h.fsys,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				h.logger.Debug("WebDAV request failed",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.Error(err),
+				)
+			}
+		},
+	}
+	return h
+}
+
+// Prefix returns the path the WebDAV tree is served below, without a
+// trailing slash.
+func (h *Handler) Prefix() string {
+	return h.prefix
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.storagePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	for _, check := range h.checks(r, name) {
+		if h.authorize(r, check.method, check.path) {
+			continue
+		}
+		// Let clients prompt for credentials they did not send
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="storage"`)
+			utils.WriteError(w, "Authentication required", http.StatusUnauthorized, nil)
+			return
+		}
+		utils.WriteError(w, "Access denied", http.StatusForbidden, nil)
+		return
+	}
+
+	req := r
+	allowed := func(method, p string) bool { return h.authorize(req, method, p) }
+	r = r.WithContext(context.WithValue(r.Context(), authorizeContextKey, allowed))
+	if r.Method == http.MethodPut {
+		body := &bodyReader{ReadCloser: r.Body}
+		r.Body = body
+		r = r.WithContext(context.WithValue(r.Context(), bodyContextKey, body))
+	}
+	h.dav.ServeHTTP(w, r)
+}
+
+type check struct {
+	method string
+	path   string
+}
+
+// checks maps a WebDAV request to the storage operations the policy is
+// evaluated against.
+func (h *Handler) checks(r *http.Request, name string) []check {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return []check{{http.MethodGet, name}}
+	case http.MethodDelete:
+		checks := []check{{http.MethodDelete, name}}
+		for _, child := range h.objectsBelow(r.Context(), name) {
+			checks = append(checks, check{http.MethodDelete, child})
+		}
+		return checks
+	case "COPY", "MOVE":
+		checks := []check{{http.MethodGet, name}}
+		if r.Method == "MOVE" {
+			checks = append(checks, check{http.MethodDelete, name})
+		}
+		// An invalid destination is rejected by the WebDAV handler
+		dst, hasDst := "", false
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			if dst, hasDst = h.storagePath(u.Path); hasDst {
+				checks = append(checks, check{http.MethodPut, dst})
+			}
+		}
+		for _, child := range h.objectsBelow(r.Context(), name) {
+			checks = append(checks, check{http.MethodGet, child})
+			if r.Method == "MOVE" {
+				checks = append(checks, check{http.MethodDelete, child})
+			}
+			if hasDst {
+				checks = append(checks, check{http.MethodPut, path.Join(dst, strings.TrimPrefix(child, name+"/"))})
+			}
+		}
+		return checks
+	default:
+		// PUT, MKCOL, PROPPATCH, LOCK and UNLOCK modify the tree
+		return []check{{http.MethodPut, name}}
+	}
+}
+
+// objectsBelow returns the objects below name if it is a collection.
+func (h *Handler) objectsBelow(ctx context.Context, name string) []string {
+	if name == "" {
+		return nil
+	}
+	if fi, err := h.fsys.stat(ctx, name); err != nil || !fi.IsDir() {
+		return nil
+	}
+
+	var names []string
+	err := provider.Walk(ctx, h.fsys.storage, name, func(object string, st *provider.Stat) error {
+		names = append(names, object)
+		return nil
+	})
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		h.logger.Debug("Failed to list collection", zap.String("path", name), zap.Error(err))
+	}
+	return names
+}
+
+// storagePath returns the storage path of a request path below the prefix.
+func (h *Handler) storagePath(p string) (string, bool) {
+	rest, ok := strings.CutPrefix(p, h.prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	return storagePath(rest), true
+}
@@ -0,0 +1,188 @@
+package dav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T, opts ...Option) (*Handler, *fs.Storage) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		// Saves complete only once the content was read without error
+		save := func(ctx context.Context, path string, content io.Reader) error {
+			data, err := io.ReadAll(content)
+			if err != nil {
+				return err
+			}
+			return backend.Save(ctx, bytes.NewReader(data), path)
+		}
+		return New(DefaultPrefix, backend, save, backend.Delete, opts...), backend
+	}
+
+	do := func(h http.Handler, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	read := func(t *testing.T, backend *fs.Storage, path string) string {
+		r, err := backend.Open(ctx, path)
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("should upload, list and download files", func(t *testing.T) {
+		h, backend := newHandler(t)
+
+		rec := do(h, http.MethodPut, "/_dav/acme/vod/movie.mp4", "movie", nil)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, "movie", read(t, backend, "acme/vod/movie.mp4"))
+
+		rec = do(h, "PROPFIND", "/_dav/acme/", "", map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<D:href>/_dav/acme/vod/</D:href>")
+
+		rec = do(h, "PROPFIND", "/_dav/acme/vod/", "", map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<D:href>/_dav/acme/vod/movie.mp4</D:href>")
+		assert.Contains(t, rec.Body.String(), "<D:getcontentlength>5</D:getcontentlength>")
+		assert.Contains(t, rec.Body.String(), "<D:getcontenttype>video/mp4</D:getcontenttype>")
+
+		rec = do(h, http.MethodGet, "/_dav/acme/vod/movie.mp4", "", map[string]string{"Range": "bytes=2-"})
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "vie", rec.Body.String())
+	})
+
+	t.Run("should keep created collections until they are filled", func(t *testing.T) {
+		h, _ := newHandler(t)
+
+		assert.Equal(t, http.StatusConflict, do(h, "MKCOL", "/_dav/acme/a/b", "", nil).Code)
+		assert.Equal(t, http.StatusCreated, do(h, "MKCOL", "/_dav/acme", "", nil).Code)
+		assert.Equal(t, http.StatusCreated, do(h, "MKCOL", "/_dav/acme/a", "", nil).Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, do(h, "MKCOL", "/_dav/acme/a", "", nil).Code)
+
+		rec := do(h, "PROPFIND", "/_dav/acme/", "", map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<D:href>/_dav/acme/a/</D:href>")
+
+		assert.Equal(t, http.StatusCreated, do(h, http.MethodPut, "/_dav/acme/a/file.txt", "x", nil).Code)
+		assert.Equal(t, http.StatusNoContent, do(h, http.MethodDelete, "/_dav/acme/a", "", nil).Code)
+		assert.Equal(t, http.StatusNotFound, do(h, "PROPFIND", "/_dav/acme/a", "", map[string]string{"Depth": "0"}).Code)
+	})
+
+	t.Run("should copy and move directories", func(t *testing.T) {
+		h, backend := newHandler(t)
+		require.NoError(t, backend.Save(ctx, strings.NewReader("1"), "acme/show/ep1.mp4"))
+		require.NoError(t, backend.Save(ctx, strings.NewReader("2"), "acme/show/extras/ep2.mp4"))
+
+		rec := do(h, "COPY", "/_dav/acme/show", "", map[string]string{"Destination": "http://example.com/_dav/acme/copy"})
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, "2", read(t, backend, "acme/copy/extras/ep2.mp4"))
+
+		rec = do(h, "MOVE", "/_dav/acme/show", "", map[string]string{"Destination": "http://example.com/_dav/acme/moved"})
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, "1", read(t, backend, "acme/moved/ep1.mp4"))
+		assert.Equal(t, "2", read(t, backend, "acme/moved/extras/ep2.mp4"))
+		_, err := backend.Stat(ctx, "acme/show")
+		assert.Error(t, err)
+	})
+
+	t.Run("should not save an interrupted upload", func(t *testing.T) {
+		h, backend := newHandler(t)
+
+		req := httptest.NewRequest(http.MethodPut, "/_dav/acme/partial.mp4", io.MultiReader(strings.NewReader("part"), errReader{}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.NotEqual(t, http.StatusCreated, rec.Code)
+		_, err := backend.Stat(ctx, "acme/partial.mp4")
+		assert.Error(t, err)
+	})
+
+	t.Run("should keep the previous object when an upload breaks off", func(t *testing.T) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		h := New(DefaultPrefix, backend, func(ctx context.Context, path string, content io.Reader) error {
+			return backend.Save(ctx, content, path)
+		}, backend.Delete)
+		require.NoError(t, backend.Save(ctx, strings.NewReader("previous"), "acme/movie.mp4"))
+
+		req := httptest.NewRequest(http.MethodPut, "/_dav/acme/movie.mp4", io.MultiReader(strings.NewReader("part"), errReader{}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.NotEqual(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "previous", read(t, backend, "acme/movie.mp4"))
+	})
+
+	t.Run("should authorize every object of a collection", func(t *testing.T) {
+		h, backend := newHandler(t, WithAuthorizer(func(r *http.Request, method, path string) bool {
+			return !strings.Contains(path, "private")
+		}))
+		require.NoError(t, backend.Save(ctx, strings.NewReader("1"), "acme/show/ep1.mp4"))
+		require.NoError(t, backend.Save(ctx, strings.NewReader("2"), "acme/show/private/ep2.mp4"))
+
+		rec := do(h, "PROPFIND", "/_dav/acme/show/", "", map[string]string{"Depth": "1"})
+		require.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<D:href>/_dav/acme/show/ep1.mp4</D:href>")
+		assert.NotContains(t, rec.Body.String(), "private")
+
+		auth := map[string]string{"Authorization": "Basic dTpw"}
+		assert.Equal(t, http.StatusForbidden, do(h, http.MethodDelete, "/_dav/acme/show", "", auth).Code)
+		auth["Destination"] = "/_dav/acme/copy"
+		assert.Equal(t, http.StatusForbidden, do(h, "COPY", "/_dav/acme/show", "", auth).Code)
+		assert.Equal(t, http.StatusForbidden, do(h, "MOVE", "/_dav/acme/show", "", auth).Code)
+
+		assert.Equal(t, "2", read(t, backend, "acme/show/private/ep2.mp4"))
+		_, err := backend.Stat(ctx, "acme/copy")
+		assert.Error(t, err)
+
+		require.NoError(t, backend.Delete(ctx, "acme/show/private/ep2.mp4"))
+		require.NoError(t, backend.Save(ctx, strings.NewReader("2"), "acme/show/extras/ep2.mp4"))
+		auth["Destination"] = "/_dav/acme/private"
+		assert.Equal(t, http.StatusForbidden, do(h, "COPY", "/_dav/acme/show", "", auth).Code)
+		auth["Destination"] = "/_dav/acme/copy"
+		assert.Equal(t, http.StatusCreated, do(h, "COPY", "/_dav/acme/show", "", auth).Code)
+	})
+
+	t.Run("should authorize sources and destinations", func(t *testing.T) {
+		h, backend := newHandler(t, WithAuthorizer(func(r *http.Request, method, path string) bool {
+			return method == http.MethodGet || strings.HasPrefix(path, "acme/uploads")
+		}))
+		require.NoError(t, backend.Save(ctx, strings.NewReader("1"), "acme/vod/ep1.mp4"))
+
+		rec := do(h, http.MethodPut, "/_dav/acme/vod/ep2.mp4", "2", nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, `Basic realm="storage"`, rec.Header().Get("WWW-Authenticate"))
+
+		rec = do(h, http.MethodPut, "/_dav/acme/vod/ep2.mp4", "2", map[string]string{"Authorization": "Basic dTpw"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = do(h, "COPY", "/_dav/acme/vod/ep1.mp4", "", map[string]string{"Destination": "/_dav/acme/uploads/ep1.mp4"})
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		rec = do(h, "MOVE", "/_dav/acme/vod/ep1.mp4", "", map[string]string{"Destination": "/_dav/acme/uploads/ep1.mp4"})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, http.StatusNotFound, do(h, "PROPFIND", "/_davfoo", "", nil).Code)
+	})
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
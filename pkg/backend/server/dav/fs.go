@@ -0,0 +1,567 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"golang.org/x/net/webdav"
+)
+
+// fileSystem adapts a provider.Storage to webdav.FileSystem.
+//
+// Storages have no directories of their own: a directory exists while an
+// object exists below it. Directories created with MKCOL are remembered in
+// memory until something is written into them.
+type fileSystem struct {
+	storage provider.Storage
+	save    SaveFunc
+	remove  RemoveFunc
+
+	mu   sync.Mutex
+	dirs map[string]struct{}
+}
+
+func newFileSystem(storage provider.Storage, save SaveFunc, remove RemoveFunc) *fileSystem {
+	return &fileSystem{
+		storage: storage,
+		save:    save,
+		remove:  remove,
+		dirs:    make(map[string]struct{}),
+	}
+}
+
+// storagePath maps a WebDAV name to a storage path; the root is "".
+func storagePath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (fsys *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := storagePath(name)
+	if p == "" {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if _, err := fsys.stat(ctx, p); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if parent := path.Dir(p); parent != "." {
+		fi, err := fsys.stat(ctx, parent)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+		}
+	}
+
+	fsys.mu.Lock()
+	fsys.dirs[p] = struct{}{}
+	fsys.mu.Unlock()
+	return nil
+}
+
+func (fsys *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := storagePath(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		// Objects can only be replaced as a whole
+		if p == "" || flag&os.O_APPEND != 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		fi, err := fsys.stat(ctx, p)
+		if err == nil && fi.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		} else if err != nil && flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		return fsys.create(ctx, p), nil
+	}
+
+	fi, err := fsys.stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dir{fsys: fsys, ctx: ctx, path: p, info: fi}, nil
+	}
+	return &file{storage: fsys.storage, ctx: ctx, path: p, info: fi}, nil
+}
+
+func (fsys *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	p := storagePath(name)
+	if p == "" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+
+	fi, err := fsys.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fsys.remove(ctx, p)
+	}
+
+	if err := fsys.removeDir(ctx, p); err != nil {
+		return err
+	}
+	fsys.forget(p)
+	return nil
+}
+
+// removeDir removes every object below p, then the directories themselves
+// for storages that have them.
+func (fsys *fileSystem) removeDir(ctx context.Context, p string) error {
+	children, err := fsys.readDir(ctx, p)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		name := path.Join(p, child.Name())
+		if child.IsDir() {
+			err = fsys.removeDir(ctx, name)
+		} else {
+			err = fsys.remove(ctx, name)
+		}
+		if err != nil && !errors.Is(err, provider.ErrNotExist) {
+			return err
+		}
+	}
+
+	if st, err := fsys.storage.Stat(ctx, p); err == nil && st.IsDir {
+		fsys.storage.Delete(ctx, p)
+	}
+	return nil
+}
+
+// Rename copies every object to its new path before removing the old one, so
+// an interrupted move leaves both copies rather than none.
+func (fsys *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, newPath := storagePath(oldName), storagePath(newName)
+	if oldPath == "" || newPath == "" || newPath == oldPath || strings.HasPrefix(newPath, oldPath+"/") {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrInvalid}
+	}
+
+	fi, err := fsys.stat(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fsys.move(ctx, oldPath, newPath)
+	}
+
+	var names []string
+	err = provider.Walk(ctx, fsys.storage, oldPath, func(name string, st *provider.Stat) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		return err
+	}
+	for _, name := range names {
+		if err := fsys.move(ctx, name, path.Join(newPath, strings.TrimPrefix(name, oldPath+"/"))); err != nil {
+			return err
+		}
+	}
+	if err := fsys.removeDir(ctx, oldPath); err != nil {
+		return err
+	}
+
+	// Carry over the empty directories
+	fsys.mu.Lock()
+	for d := range fsys.dirs {
+		if d == oldPath || strings.HasPrefix(d, oldPath+"/") {
+			delete(fsys.dirs, d)
+			fsys.dirs[newPath+strings.TrimPrefix(d, oldPath)] = struct{}{}
+		}
+	}
+	fsys.dirs[newPath] = struct{}{}
+	fsys.mu.Unlock()
+	return nil
+}
+
+func (fsys *fileSystem) move(ctx context.Context, oldPath, newPath string) error {
+	r, err := fsys.storage.Open(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	err = fsys.save(ctx, newPath, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	fsys.forget(newPath)
+	return fsys.remove(ctx, oldPath)
+}
+
+func (fsys *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fsys.stat(ctx, storagePath(name))
+}
+
+func (fsys *fileSystem) stat(ctx context.Context, p string) (*fileInfo, error) {
+	if p == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	st, err := fsys.storage.Stat(ctx, p)
+	if err == nil {
+		return newFileInfo(path.Base(p), st), nil
+	}
+
+	fsys.mu.Lock()
+	_, ok := fsys.dirs[p]
+	fsys.mu.Unlock()
+	if ok {
+		return &fileInfo{name: path.Base(p), dir: true}, nil
+	}
+
+	// Object storages only know the directory from the objects below it, and
+	// some fail to stat directories at all
+	if children, listErr := fsys.storage.List(ctx, p); listErr == nil && len(children) > 0 {
+		return &fileInfo{name: path.Base(p), dir: true}, nil
+	}
+	if errors.Is(err, provider.ErrNotExist) {
+		return nil, notExist("stat", p)
+	}
+	return nil, err
+}
+
+// readDir returns the entries directly below p, merging the directories
+// created in memory.
+func (fsys *fileSystem) readDir(ctx context.Context, p string) ([]os.FileInfo, error) {
+	stats, err := fsys.storage.List(ctx, p)
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		return nil, err
+	}
+
+	entries := make(map[string]*fileInfo)
+	for _, st := range stats {
+		name := st.Path
+		if name == "" {
+			name = path.Join(p, st.Name)
+		}
+		rel := strings.TrimPrefix(name, p+"/")
+		if p == "" {
+			rel = strings.TrimPrefix(name, "/")
+		}
+
+		// Recursive listings name objects deeper down
+		if first, _, nested := strings.Cut(rel, "/"); nested {
+			entries[first] = &fileInfo{name: first, dir: true}
+			continue
+		}
+		entries[rel] = newFileInfo(rel, st)
+	}
+
+	// Entries the caller may not read are left out
+	if allowed, ok := ctx.Value(authorizeContextKey).(func(method, p string) bool); ok {
+		for name := range entries {
+			if !allowed(http.MethodGet, path.Join(p, name)) {
+				delete(entries, name)
+			}
+		}
+	}
+
+	fsys.mu.Lock()
+	for d := range fsys.dirs {
+		if path.Dir(d) == p || (p == "" && path.Dir(d) == ".") {
+			name := path.Base(d)
+			if _, ok := entries[name]; !ok {
+				entries[name] = &fileInfo{name: name, dir: true}
+			}
+		}
+	}
+	fsys.mu.Unlock()
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, fi := range entries {
+		infos = append(infos, fi)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// forget drops the in-memory directories at and above p, which now exist
+// through an object or were removed.
+func (fsys *fileSystem) forget(p string) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	for d := range fsys.dirs {
+		if d == p || strings.HasPrefix(d, p+"/") || strings.HasPrefix(p, d+"/") {
+			delete(fsys.dirs, d)
+		}
+	}
+}
+
+// create returns a file streaming its writes to the storage; the object is
+// saved when the file is closed.
+func (fsys *fileSystem) create(ctx context.Context, p string) *writeFile {
+	pr, pw := io.Pipe()
+	body, _ := ctx.Value(bodyContextKey).(*bodyReader)
+	f := &writeFile{pw: pw, path: p, body: body, done: make(chan error, 1)}
+	go func() {
+		err := fsys.save(ctx, p, pr)
+		pr.CloseWithError(err)
+		if err == nil {
+			fsys.forget(p)
+		}
+		f.done <- err
+	}()
+	return f
+}
+
+// fileInfo describes an object or a directory.
+type fileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	contentType string
+	dir         bool
+}
+
+func newFileInfo(name string, st *provider.Stat) *fileInfo {
+	return &fileInfo{
+		name:        name,
+		size:        st.Size,
+		modTime:     st.ModifiedTime,
+		contentType: st.ContentType,
+		dir:         st.IsDir,
+	}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType spares the WebDAV handler from reading every listed object to
+// sniff its type.
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.contentType != "" {
+		return fi.contentType, nil
+	}
+	if ct := mime.TypeByExtension(path.Ext(fi.name)); ct != "" {
+		return ct, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// file reads an object, opening it on the first read so that requests only
+// interested in its properties do not fetch it.
+type file struct {
+	storage provider.Storage
+	ctx     context.Context
+	path    string
+	info    *fileInfo
+
+	rc     io.ReadCloser
+	offset int64
+	// pos is the offset rc is at
+	pos int64
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.rc != nil && f.pos != f.offset {
+		if seeker, ok := f.rc.(io.Seeker); ok {
+			if _, err := seeker.Seek(f.offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+			f.pos = f.offset
+		} else if f.pos > f.offset {
+			f.rc.Close()
+			f.rc = nil
+		}
+	}
+	if f.rc == nil {
+		rc, err := f.storage.Open(f.ctx, f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.rc, f.pos = rc, 0
+	}
+	if f.pos < f.offset {
+		n, err := io.CopyN(io.Discard, f.rc, f.offset-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+func (f *file) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.path, Err: fs.ErrInvalid}
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.path, Err: fs.ErrPermission}
+}
+
+// dir lists a directory.
+type dir struct {
+	fsys *fileSystem
+	ctx  context.Context
+	path string
+	info *fileInfo
+
+	entries []fs.FileInfo
+	listed  bool
+}
+
+func (d *dir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.listed {
+		entries, err := d.fsys.readDir(d.ctx, d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	return 0, &fs.PathError{Op: "seek", Path: d.path, Err: fs.ErrInvalid}
+}
+
+func (d *dir) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: d.path, Err: fs.ErrInvalid}
+}
+
+// writeFile streams the body of a PUT to the storage.
+type writeFile struct {
+	pw      *io.PipeWriter
+	path    string
+	body    *bodyReader
+	written int64
+	done    chan error
+	closed  bool
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.pw.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Close waits for the object to be saved and reports whether it was. When
+// the request body broke off, the save reads that error instead of the end
+// of the content and fails, leaving the previous object in place.
+func (f *writeFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.body != nil && f.body.err != nil {
+		f.pw.CloseWithError(f.body.err)
+	} else {
+		f.pw.Close()
+	}
+	return <-f.done
+}
+
+func (f *writeFile) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: path.Base(f.path), size: f.written, modTime: time.Now()}, nil
+}
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.path, Err: fs.ErrPermission}
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrPermission}
+}
+
+func (f *writeFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.path, Err: fs.ErrInvalid}
+}
+
+// bodyReader records how reading a request body failed, which the WebDAV
+// handler does not tell the file it copies the body into.
+type bodyReader struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+package deadletter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+)
+
+// Store keeps operations that failed permanently or ran out of retries, so
+// they can be inspected and replayed.
+//
+// Without a directory entries are kept in memory and lost on restart. With a
+// directory they are persisted using the same layout as the durable queue.
+type Store struct {
+	mu       sync.Mutex
+	entries  map[string]*queue.Entry
+	payloads map[string][]byte
+	disk     *queue.Queue
+}
+
+// New returns an in-memory store.
+func New() *Store {
+	return &Store{
+		entries:  make(map[string]*queue.Entry),
+		payloads: make(map[string][]byte),
+	}
+}
+
+// Open returns a store persisted in dir, loading the entries already there.
+func Open(dir string) (*Store, error) {
+	q, err := queue.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := q.Pending()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter entries: %w", err)
+	}
+
+	s := New()
+	s.disk = q
+	for _, e := range pending {
+		s.entries[e.ID] = e
+	}
+	return s, nil
+}
+
+// Add records a failed operation with its payload.
+func (s *Store) Add(e *queue.Entry, payload []byte) error {
+	if s.disk != nil {
+		if err := s.disk.Put(e, payload); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[e.ID] = e
+	if s.disk == nil {
+		s.payloads[e.ID] = payload
+	}
+	return nil
+}
+
+// List returns all entries, oldest first.
+func (s *Store) List() []*queue.Entry {
+	s.mu.Lock()
+	entries := make([]*queue.Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// Get returns the entry with id.
+func (s *Store) Get(id string) (*queue.Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	return e, ok
+}
+
+// Payload returns the payload stored with an entry.
+func (s *Store) Payload(id string) ([]byte, error) {
+	if s.disk != nil {
+		return s.disk.Payload(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.payloads[id], nil
+}
+
+// Remove deletes an entry.
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	delete(s.entries, id)
+	delete(s.payloads, id)
+	s.mu.Unlock()
+
+	if s.disk != nil {
+		return s.disk.Ack(id)
+	}
+	return nil
+}
+
+// Len returns the number of entries.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,68 @@
+package deadletter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+)
+
+func TestStore(t *testing.T) {
+	entry := func(id string, age time.Duration) *queue.Entry {
+		return &queue.Entry{
+			ID:        id,
+			Op:        "upload",
+			Path:      "vod/" + id + ".ts",
+			CreatedAt: time.Now().Add(-age).UTC(),
+			Attempts:  5,
+			Error:     "backend unavailable",
+		}
+	}
+
+	t.Run("should list entries oldest first", func(t *testing.T) {
+		s := New()
+		require.NoError(t, s.Add(entry("b", time.Minute), []byte("b")))
+		require.NoError(t, s.Add(entry("a", time.Hour), []byte("a")))
+		require.NoError(t, s.Add(entry("c", time.Second), nil))
+
+		var ids []string
+		for _, e := range s.List() {
+			ids = append(ids, e.ID)
+		}
+		assert.Equal(t, []string{"a", "b", "c"}, ids)
+		assert.Equal(t, 3, s.Len())
+
+		payload, err := s.Payload("a")
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(payload))
+
+		require.NoError(t, s.Remove("a"))
+		_, ok := s.Get("a")
+		assert.False(t, ok)
+		assert.Equal(t, 2, s.Len())
+	})
+
+	t.Run("should keep entries across restarts", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := Open(dir)
+		require.NoError(t, err)
+		require.NoError(t, s.Add(entry("a", time.Minute), []byte("payload")))
+		require.NoError(t, s.Add(entry("b", time.Second), nil))
+		require.NoError(t, s.Remove("b"))
+
+		s, err = Open(dir)
+		require.NoError(t, err)
+		require.Equal(t, 1, s.Len())
+		e, ok := s.Get("a")
+		require.True(t, ok)
+		assert.Equal(t, "vod/a.ts", e.Path)
+		assert.Equal(t, 5, e.Attempts)
+		assert.Equal(t, "backend unavailable", e.Error)
+
+		payload, err := s.Payload("a")
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(payload))
+	})
+}
@@ -0,0 +1,186 @@
+package events
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is the kind of event.
+type Type string
+
+const (
+	ObjectCreated Type = "object.created"
+	ObjectUpdated Type = "object.updated"
+	ObjectDeleted Type = "object.deleted"
+	UploadFailed  Type = "upload.failed"
+	DeleteFailed  Type = "delete.failed"
+)
+
+// historySize is how many recent events a bus keeps for resuming streams.
+const historySize = 1024
+
+// Event describes a change to, or a failed change of, a stored object.
+type Event struct {
+	ID string `json:"id"`
+	// Seq orders the events of a bus; it restarts with the process.
+	Seq      uint64    `json:"seq"`
+	Type     Type      `json:"type"`
+	Time     time.Time `json:"time"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+	Tenant   string    `json:"tenant,omitempty"`
+	JobID    string    `json:"job_id,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Checksum returns the checksum events carry for content: the hex encoded
+// SHA-256 digest prefixed with the algorithm.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return FormatChecksum(sum[:])
+}
+
+// FormatChecksum formats a SHA-256 digest the way Checksum does.
+func FormatChecksum(sha256Sum []byte) string {
+	return "sha256:" + hex.EncodeToString(sha256Sum)
+}
+
+// Bus fans events out to subscribers and keeps a bounded log of recent
+// events. A nil *Bus discards events.
+type Bus struct {
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	seq     uint64
+	history []Event
+	// next is where the next event goes in history once it is full
+	next int
+}
+
+// NewBus creates a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Active reports whether anyone is subscribed to the bus.
+func (b *Bus) Active() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+// Publish stamps e with an id, sequence number and time and delivers it to
+// every subscriber. It never blocks: subscribers that fall behind lose events.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	if len(b.history) < historySize {
+		b.history = append(b.history, e)
+	} else {
+		b.history[b.next] = e
+		b.next = (b.next + 1) % historySize
+	}
+
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe registers a subscriber buffering up to buffer events.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	s := &Subscription{c: make(chan Event, buffer), bus: b}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+// SubscribeAfter registers a subscriber like Subscribe and also returns the
+// logged events published after seq, so a reconnecting client misses
+// nothing in between. complete is false if some of those events are no
+// longer in the log, or if seq comes from before a restart, in which case
+// the whole log is returned.
+func (b *Bus) SubscribeAfter(seq uint64, buffer int) (s *Subscription, missed []Event, complete bool) {
+	s = &Subscription{c: make(chan Event, buffer), bus: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs[s] = struct{}{}
+
+	restarted := seq > b.seq
+	if restarted {
+		seq = 0
+	}
+
+	// history is a ring starting at next once it is full
+	ordered := append(slices.Clone(b.history[b.next:]), b.history[:b.next]...)
+	complete = !restarted && (seq == b.seq || ordered[0].Seq <= seq+1)
+	for _, e := range ordered {
+		if e.Seq > seq {
+			missed = append(missed, e)
+		}
+	}
+	return s, missed, complete
+}
+
+// Subscription receives the events published on a bus.
+type Subscription struct {
+	c       chan Event
+	bus     *Bus
+	once    sync.Once
+	dropped atomic.Int64
+}
+
+// C returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) C() <-chan Event {
+	return s.c
+}
+
+// Dropped returns how many events were lost because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes the event channel.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		close(s.c)
+		s.bus.mu.Unlock()
+	})
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	t.Run("should return the events a resuming subscriber missed", func(t *testing.T) {
+		bus := NewBus()
+		for range 3 {
+			bus.Publish(Event{Type: ObjectCreated, Path: "live/a.ts"})
+		}
+
+		sub, missed, complete := bus.SubscribeAfter(1, 1)
+		defer sub.Close()
+
+		assert.True(t, complete)
+		if assert.Len(t, missed, 2) {
+			assert.Equal(t, uint64(2), missed[0].Seq)
+			assert.Equal(t, uint64(3), missed[1].Seq)
+		}
+	})
+
+	t.Run("should report events that fell out of the log", func(t *testing.T) {
+		bus := NewBus()
+		for range historySize + 10 {
+			bus.Publish(Event{Type: ObjectCreated, Path: "live/a.ts"})
+		}
+
+		sub, missed, complete := bus.SubscribeAfter(5, 1)
+		defer sub.Close()
+
+		assert.False(t, complete)
+		assert.Len(t, missed, historySize)
+		assert.Equal(t, uint64(11), missed[0].Seq)
+	})
+
+	t.Run("should drop events for subscribers that fall behind", func(t *testing.T) {
+		bus := NewBus()
+		sub := bus.Subscribe(1)
+		defer sub.Close()
+
+		bus.Publish(Event{Type: ObjectCreated})
+		bus.Publish(Event{Type: ObjectCreated})
+
+		assert.Equal(t, int64(1), sub.Dropped())
+	})
+}
@@ -0,0 +1,249 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// StreamPath is where the live event stream is served.
+const StreamPath = "/_events"
+
+const (
+	// streamBuffer is how many events may wait for a slow stream client
+	// before it is disconnected.
+	streamBuffer = 256
+	// keepAliveInterval is how often an idle stream sends a keep-alive.
+	keepAliveInterval = 15 * time.Second
+)
+
+// resetEvent tells a resuming client that events were lost and it should
+// resynchronise, e.g. by listing the prefix again.
+const resetEvent = "stream.reset"
+
+// StreamHandler streams bus events under a path prefix as Server-Sent Events
+// or, for WebSocket upgrade requests, as JSON messages.
+//
+//	GET /_events?prefix=live/abc/&types=object.created,object.deleted
+//
+// SSE clients resume with the Last-Event-ID header; WebSocket clients pass
+// the last seen sequence number as last_event_id.
+type StreamHandler struct {
+	bus    *Bus
+	logger *zap.Logger
+}
+
+// NewStreamHandler creates a stream handler for bus.
+func NewStreamHandler(bus *Bus) *StreamHandler {
+	return &StreamHandler{bus: bus, logger: zap.L().Named("events")}
+}
+
+// StreamPrefix returns the directory the prefix parameter of a stream
+// request selects, without slashes around it. Prefixes end on a path
+// segment, so "acme" selects acme/ but not acmeevil/; "" selects everything.
+func StreamPrefix(prefix string) string {
+	return strings.Trim(path.Clean("/"+prefix), "/")
+}
+
+// streamFilter selects the events a client subscribed to.
+type streamFilter struct {
+	// prefix is the directory of the events, as returned by StreamPrefix
+	prefix string
+	types  []Type
+}
+
+func (f streamFilter) matches(e Event) bool {
+	if f.prefix != "" && e.Path != f.prefix && !strings.HasPrefix(e.Path, f.prefix+"/") {
+		return false
+	}
+	return len(f.types) == 0 || slices.Contains(f.types, e.Type)
+}
+
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := streamFilter{prefix: StreamPrefix(query.Get("prefix"))}
+	if types := query.Get("types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			filter.types = append(filter.types, Type(strings.TrimSpace(t)))
+		}
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+	var after uint64
+	resume := lastID != ""
+	if resume {
+		var err error
+		after, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			utils.WriteError(w, "Invalid last event id", http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			h.serveWebSocket(ws, filter, resume, after)
+		}}
+		server.ServeHTTP(hijacker{w}, r)
+		return
+	}
+
+	h.serveSSE(w, r, filter, resume, after)
+}
+
+// subscribe registers a subscription and returns the logged events a
+// resuming client missed. When resuming is impossible, a reset event is
+// returned first.
+func (h *StreamHandler) subscribe(filter streamFilter, resume bool, after uint64) (*Subscription, []Event, bool) {
+	if !resume {
+		return h.bus.Subscribe(streamBuffer), nil, true
+	}
+
+	sub, missed, complete := h.bus.SubscribeAfter(after, streamBuffer)
+	missed = slices.DeleteFunc(missed, func(e Event) bool { return !filter.matches(e) })
+	return sub, missed, complete
+}
+
+func (h *StreamHandler) serveSSE(w http.ResponseWriter, r *http.Request, filter streamFilter, resume bool, after uint64) {
+	rc := http.NewResponseController(w)
+
+	sub, missed, complete := h.subscribe(filter, resume, after)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", resetEvent)
+	}
+	for _, e := range missed {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("Event stream requires flushing", zap.Error(err))
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			if !filter.matches(e) {
+				continue
+			}
+			if sub.Dropped() > 0 {
+				// The client is too slow; it will reconnect and resume
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+	return err
+}
+
+// wsMessage is a WebSocket frame. Reset frames carry no event.
+type wsMessage struct {
+	Type  string `json:"type"`
+	Event *Event `json:"event,omitempty"`
+}
+
+func (h *StreamHandler) serveWebSocket(ws *websocket.Conn, filter streamFilter, resume bool, after uint64) {
+	defer ws.Close()
+
+	sub, missed, complete := h.subscribe(filter, resume, after)
+	defer sub.Close()
+
+	// The client does not send anything; reading detects it going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	if !complete {
+		if websocket.JSON.Send(ws, wsMessage{Type: resetEvent}) != nil {
+			return
+		}
+	}
+	for _, e := range missed {
+		if websocket.JSON.Send(ws, wsMessage{Type: string(e.Type), Event: &e}) != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-sub.C():
+			if !ok || sub.Dropped() > 0 {
+				return
+			}
+			if !filter.matches(e) {
+				continue
+			}
+			if websocket.JSON.Send(ws, wsMessage{Type: string(e.Type), Event: &e}) != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// hijacker exposes connection hijacking of a wrapped ResponseWriter, which
+// the websocket package requires on the writer itself.
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamHandler(t *testing.T) {
+	// open starts a stream and returns the event types and paths it sends
+	open := func(t *testing.T, ctx context.Context, url string) <-chan string {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		lines := make(chan string)
+		go func() {
+			defer resp.Body.Close()
+			defer close(lines)
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+					lines <- data
+				}
+			}
+		}()
+		return lines
+	}
+
+	t.Run("should only send events below the prefix", func(t *testing.T) {
+		bus := NewBus()
+		srv := httptest.NewServer(NewStreamHandler(bus))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		lines := open(t, ctx, srv.URL+"?prefix=acme&types=object.created")
+		require.Eventually(t, bus.Active, time.Second, 10*time.Millisecond)
+
+		bus.Publish(Event{Type: ObjectCreated, Path: "acmeevil/a.ts"})
+		bus.Publish(Event{Type: ObjectDeleted, Path: "acme/a.ts"})
+		bus.Publish(Event{Type: ObjectCreated, Path: "acme/live/b.ts"})
+
+		select {
+		case data := <-lines:
+			assert.Contains(t, data, `"path":"acme/live/b.ts"`)
+		case <-time.After(time.Second):
+			t.Fatal("no event received")
+		}
+	})
+
+	t.Run("should unsubscribe clients that disconnect", func(t *testing.T) {
+		bus := NewBus()
+		srv := httptest.NewServer(NewStreamHandler(bus))
+		defer srv.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		open(t, ctx, srv.URL+"?prefix=acme/")
+		require.Eventually(t, bus.Active, time.Second, 10*time.Millisecond)
+
+		cancel()
+		assert.Eventually(t, func() bool { return !bus.Active() }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestStreamPrefix(t *testing.T) {
+	assert.Equal(t, "", StreamPrefix(""))
+	assert.Equal(t, "", StreamPrefix("/"))
+	assert.Equal(t, "acme", StreamPrefix("acme/"))
+	assert.Equal(t, "acme/live", StreamPrefix("/acme/live"))
+	assert.Equal(t, "acme", StreamPrefix("../acme"))
+}
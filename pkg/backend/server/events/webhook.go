@@ -0,0 +1,326 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// webhookBuffer is how many events may wait for delivery per webhook.
+	webhookBuffer = 1024
+	// recentDeliveries is how many delivery outcomes are kept per webhook.
+	recentDeliveries = 50
+
+	deliveryTimeout = 10 * time.Second
+)
+
+// WebhookConfig describes a webhook endpoint and the events sent to it.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Secret signs deliveries with HMAC-SHA256. Deliveries are unsigned
+	// without one.
+	Secret string `json:"secret,omitempty"`
+	// Events restricts deliveries to these types; empty means all.
+	Events []Type `json:"events,omitempty"`
+	// Prefixes and Suffixes restrict deliveries to matching paths; empty
+	// means all.
+	Prefixes []string `json:"prefixes,omitempty"`
+	Suffixes []string `json:"suffixes,omitempty"`
+}
+
+// Matches reports whether e should be delivered to the webhook.
+func (c *WebhookConfig) Matches(e Event) bool {
+	if len(c.Events) > 0 && !slices.Contains(c.Events, e.Type) {
+		return false
+	}
+	if len(c.Prefixes) > 0 && !slices.ContainsFunc(c.Prefixes, func(p string) bool { return strings.HasPrefix(e.Path, p) }) {
+		return false
+	}
+	if len(c.Suffixes) > 0 && !slices.ContainsFunc(c.Suffixes, func(s string) bool { return strings.HasSuffix(e.Path, s) }) {
+		return false
+	}
+	return true
+}
+
+// LoadWebhooks reads webhook configurations from a JSON document of the
+// form {"webhooks": [...]}.
+func LoadWebhooks(path string) ([]WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+
+	var doc struct {
+		Webhooks []WebhookConfig `json:"webhooks"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks: %w", err)
+	}
+	for i, wh := range doc.Webhooks {
+		if wh.URL == "" {
+			return nil, fmt.Errorf("webhook %d: missing url", i)
+		}
+	}
+	return doc.Webhooks, nil
+}
+
+// Sign returns the signature header value for a delivery body sent at
+// timestamp: "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delivery is the outcome of delivering one event to a webhook.
+type Delivery struct {
+	EventID  string    `json:"event_id"`
+	Type     Type      `json:"type"`
+	Path     string    `json:"path"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// WebhookStatus reports the delivery state of a webhook.
+type WebhookStatus struct {
+	URL           string     `json:"url"`
+	Events        []Type     `json:"events,omitempty"`
+	Prefixes      []string   `json:"prefixes,omitempty"`
+	Suffixes      []string   `json:"suffixes,omitempty"`
+	Delivered     int64      `json:"delivered"`
+	Failed        int64      `json:"failed"`
+	Dropped       int64      `json:"dropped"`
+	Pending       int        `json:"pending"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	Recent        []Delivery `json:"recent"`
+}
+
+// webhook delivers the events of one subscription to one endpoint, in order.
+type webhook struct {
+	cfg    WebhookConfig
+	sub    *Subscription
+	mu     sync.Mutex
+	status WebhookStatus
+}
+
+// Dispatcher delivers bus events to webhooks, retrying failed deliveries.
+type Dispatcher struct {
+	webhooks []*webhook
+	policy   retry.Policy
+	client   *http.Client
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	logger   *zap.Logger
+}
+
+// NewDispatcher subscribes every webhook to bus and starts delivering.
+// Failed deliveries are retried according to policy; events arriving while a
+// webhook is backed up by more than its buffer are dropped and counted.
+func NewDispatcher(bus *Bus, configs []WebhookConfig, policy retry.Policy) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		policy: policy,
+		client: &http.Client{Timeout: deliveryTimeout},
+		ctx:    ctx,
+		cancel: cancel,
+		logger: zap.L().Named("webhooks"),
+	}
+
+	for _, cfg := range configs {
+		wh := &webhook{
+			cfg: cfg,
+			sub: bus.Subscribe(webhookBuffer),
+			status: WebhookStatus{
+				URL:      cfg.URL,
+				Events:   cfg.Events,
+				Prefixes: cfg.Prefixes,
+				Suffixes: cfg.Suffixes,
+				Recent:   []Delivery{},
+			},
+		}
+		d.webhooks = append(d.webhooks, wh)
+
+		d.wg.Add(1)
+		go d.run(wh)
+	}
+
+	return d
+}
+
+// Close stops delivering, abandoning events not delivered yet.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	for _, wh := range d.webhooks {
+		wh.sub.Close()
+	}
+	d.wg.Wait()
+}
+
+// Status returns the delivery status of every webhook.
+func (d *Dispatcher) Status() []WebhookStatus {
+	statuses := make([]WebhookStatus, 0, len(d.webhooks))
+	for _, wh := range d.webhooks {
+		wh.mu.Lock()
+		st := wh.status
+		st.Recent = slices.Clone(wh.status.Recent)
+		wh.mu.Unlock()
+
+		st.Dropped = wh.sub.Dropped()
+		st.Pending = len(wh.sub.C())
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// ServeHTTP serves the delivery status of every webhook.
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(d.Status()); err != nil {
+		d.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+func (d *Dispatcher) run(wh *webhook) {
+	defer d.wg.Done()
+
+	for e := range wh.sub.C() {
+		if !wh.cfg.Matches(e) {
+			continue
+		}
+		d.deliver(wh, e)
+	}
+}
+
+// deliveryError carries the response status of a failed delivery.
+type deliveryError struct {
+	status int
+}
+
+func (e *deliveryError) Error() string {
+	return fmt.Sprintf("webhook responded %d", e.status)
+}
+
+// retryable retries transport errors, throttling and server errors; other
+// client errors mean the endpoint rejected the event.
+func retryable(err error) bool {
+	var de *deliveryError
+	if errors.As(err, &de) {
+		return de.status == http.StatusTooManyRequests || de.status >= 500
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+func (d *Dispatcher) deliver(wh *webhook, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.logger.Error("Failed to encode event", zap.Error(err))
+		return
+	}
+
+	var status int
+	attempts, err := retry.Do(d.ctx, d.policy, retryable, func(ctx context.Context) error {
+		var err error
+		status, err = d.post(ctx, wh.cfg, e, body)
+		return err
+	}, func(attempt int, err error) {
+		d.logger.Warn("Webhook delivery failed, retrying",
+			zap.String("url", wh.cfg.URL),
+			zap.String("event_id", e.ID),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+	})
+
+	delivery := Delivery{
+		EventID:  e.ID,
+		Type:     e.Type,
+		Path:     e.Path,
+		Attempts: attempts,
+		Status:   status,
+		At:       time.Now(),
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		d.logger.Error("Webhook delivery failed",
+			zap.String("url", wh.cfg.URL),
+			zap.String("event_id", e.ID),
+			zap.Int("attempts", attempts),
+			zap.Error(err),
+		)
+	}
+
+	wh.record(delivery)
+}
+
+func (d *Dispatcher) post(ctx context.Context, cfg WebhookConfig, e Event, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Storage-Event", string(e.Type))
+	req.Header.Set("X-Storage-Delivery", e.ID)
+	if cfg.Secret != "" {
+		req.Header.Set("X-Storage-Signature", Sign(cfg.Secret, time.Now(), body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &deliveryError{status: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+func (wh *webhook) record(delivery Delivery) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	at := delivery.At
+	if delivery.Error == "" {
+		wh.status.Delivered++
+		wh.status.LastSuccessAt = &at
+	} else {
+		wh.status.Failed++
+		wh.status.LastFailureAt = &at
+	}
+
+	wh.status.Recent = append(wh.status.Recent, delivery)
+	if len(wh.status.Recent) > recentDeliveries {
+		wh.status.Recent = slices.Delete(wh.status.Recent, 0, len(wh.status.Recent)-recentDeliveries)
+	}
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+)
+
+func TestDispatcher(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("should deliver matching events signed with the secret", func(t *testing.T) {
+		var (
+			mu       sync.Mutex
+			received []Event
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+
+			sig := r.Header.Get("X-Storage-Signature")
+			ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+			sec, err := strconv.ParseInt(ts, 10, 64)
+			require.NoError(t, err)
+			assert.Equal(t, Sign("s3cr3t", time.Unix(sec, 0), body), sig)
+
+			var e Event
+			require.NoError(t, json.Unmarshal(body, &e))
+			mu.Lock()
+			received = append(received, e)
+			mu.Unlock()
+		}))
+		defer srv.Close()
+
+		bus := NewBus()
+		d := NewDispatcher(bus, []WebhookConfig{{
+			URL:      srv.URL,
+			Secret:   "s3cr3t",
+			Prefixes: []string{"live/"},
+			Suffixes: []string{".m3u8"},
+		}}, policy)
+		defer d.Close()
+
+		bus.Publish(Event{Type: ObjectCreated, Path: "live/index.m3u8"})
+		bus.Publish(Event{Type: ObjectCreated, Path: "live/seg1.ts"})
+		bus.Publish(Event{Type: ObjectCreated, Path: "vod/index.m3u8"})
+
+		require.Eventually(t, func() bool { return d.Status()[0].Delivered == 1 }, time.Second, time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, received, 1)
+		assert.Equal(t, "live/index.m3u8", received[0].Path)
+	})
+
+	t.Run("should retry failed deliveries and report them", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			calls int
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if strings.Contains(r.Header.Get("X-Storage-Event"), "deleted") {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer srv.Close()
+
+		bus := NewBus()
+		d := NewDispatcher(bus, []WebhookConfig{{URL: srv.URL}}, policy)
+		defer d.Close()
+
+		bus.Publish(Event{Type: ObjectCreated, Path: "vod/a.ts"})
+		bus.Publish(Event{Type: ObjectDeleted, Path: "vod/a.ts"})
+
+		require.Eventually(t, func() bool {
+			st := d.Status()[0]
+			return st.Delivered+st.Failed == 2
+		}, time.Second, time.Millisecond)
+
+		st := d.Status()[0]
+		assert.Equal(t, int64(1), st.Delivered)
+		assert.Equal(t, int64(1), st.Failed)
+		require.Len(t, st.Recent, 2)
+		assert.Equal(t, 2, st.Recent[0].Attempts)
+		assert.Equal(t, 1, st.Recent[1].Attempts)
+		assert.Equal(t, http.StatusBadRequest, st.Recent[1].Status)
+	})
+}
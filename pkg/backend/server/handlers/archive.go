@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/policy"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+// Archive formats served with ?archive=.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+)
+
+var errArchiveFormat = errors.New("unsupported archive format")
+
+// archiveWriter writes objects into an archive.
+type archiveWriter interface {
+	add(name string, st *provider.Stat, content io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, string, error) {
+	switch format {
+	case ArchiveZip:
+		return &zipArchive{w: zip.NewWriter(w)}, "application/zip", nil
+	case ArchiveTar:
+		return &tarArchive{w: tar.NewWriter(w)}, "application/x-tar", nil
+	case ArchiveTarGz, "tgz":
+		gz, _ := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+		return &tarArchive{w: tar.NewWriter(gz), gz: gz}, "application/gzip", nil
+	default:
+		return nil, "", fmt.Errorf("%w: %q", errArchiveFormat, format)
+	}
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(name string, st *provider.Stat, content io.Reader) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Modified: st.ModifiedTime,
+		Method:   zip.Deflate,
+	}
+	hdr.SetMode(0644)
+	// Media is already compressed
+	if compressed(name) {
+		hdr.Method = zip.Store
+	}
+
+	fw, err := a.w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, content)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	w  *tar.Writer
+	gz *gzip.Writer
+}
+
+func (a *tarArchive) add(name string, st *provider.Stat, content io.Reader) error {
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     st.Size,
+		Mode:     0644,
+		ModTime:  st.ModifiedTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	// An object that changed since it was listed no longer fits its header
+	n, err := io.Copy(a.w, content)
+	if err == nil && n != st.Size {
+		err = fmt.Errorf("%s changed size while archived", name)
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.w.Close()
+	if a.gz != nil {
+		err = errors.Join(err, a.gz.Close())
+	}
+	return err
+}
+
+// compressed reports whether name holds data that does not benefit from
+// compression.
+func compressed(name string) bool {
+	ct := mime.TypeByExtension(path.Ext(name))
+	switch {
+	case strings.Contains(ct, "mpegurl"):
+		// Playlists are text, whatever their registered type says
+		return false
+	case strings.HasPrefix(ct, "video/"), strings.HasPrefix(ct, "audio/"), strings.HasPrefix(ct, "image/"):
+		return !strings.HasPrefix(ct, "image/svg")
+	case ct == "application/zip", ct == "application/gzip":
+		return true
+	}
+	return path.Ext(name) == ".ts" || path.Ext(name) == ".m4s"
+}
+
+// archiveEntry is an object to archive.
+type archiveEntry struct {
+	name string
+	stat *provider.Stat
+}
+
+// serveArchive streams every object below dir the caller may read as a zip,
+// tar or gzipped tar archive, named relative to dir. Objects are listed
+// first, then copied one at a time, so memory does not grow with their size.
+// A failure once the archive started is reported by aborting the response,
+// so clients do not mistake a truncated archive for a complete one.
+func (h *DownloadHandler) serveArchive(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request, dir string) {
+	query := r.URL.Query()
+	format := query.Get("archive")
+	cw := &countingWriter{w: w}
+	aw, contentType, err := newArchiveWriter(format, cw)
+	if err != nil {
+		utils.WriteError(w, "Invalid archive", http.StatusBadRequest, err)
+		return
+	}
+
+	include, err := compileGlobs(query["include"])
+	if err != nil {
+		utils.WriteError(w, "Invalid include pattern", http.StatusBadRequest, err)
+		return
+	}
+	exclude, err := compileGlobs(query["exclude"])
+	if err != nil {
+		utils.WriteError(w, "Invalid exclude pattern", http.StatusBadRequest, err)
+		return
+	}
+
+	var entries []archiveEntry
+	err = provider.Walk(ctx, storageBackend, dir, func(name string, st *provider.Stat) error {
+		rel := strings.TrimPrefix(name, dir+"/")
+		if rel == name || !h.authorize(r, http.MethodGet, name) {
+			return nil
+		}
+		if (len(include) > 0 && !matchAny(include, rel)) || matchAny(exclude, rel) {
+			return nil
+		}
+		entries = append(entries, archiveEntry{name: rel, stat: st})
+		return nil
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, provider.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		utils.WriteError(w, "List files failed", status, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": path.Base(dir) + "." + strings.Replace(format, "tgz", ArchiveTarGz, 1),
+	}))
+	w.Header().Set("Cache-Control", "no-store")
+
+	fail := func(name string, err error) {
+		h.logger.Error("Failed to archive", zap.String("path", dir), zap.String("object", name), zap.Error(err))
+		if cw.n == 0 {
+			w.Header().Del("Content-Disposition")
+			utils.WriteError(w, "Archive failed", http.StatusInternalServerError, err)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return
+		}
+
+		rc, err := storageBackend.Open(ctx, path.Join(dir, e.name))
+		if errors.Is(err, provider.ErrNotExist) {
+			// Deleted since it was listed
+			continue
+		} else if err != nil {
+			fail(e.name, err)
+			return
+		}
+		err = aw.add(e.name, e.stat, rc)
+		rc.Close()
+		if err != nil {
+			fail(e.name, err)
+			return
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		fail("", err)
+	}
+}
+
+func compileGlobs(values []string) ([]*policy.PathGlob, error) {
+	var globs []*policy.PathGlob
+	for _, value := range values {
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			g, err := policy.CompilePathGlob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			globs = append(globs, g)
+		}
+	}
+	return globs, nil
+}
+
+func matchAny(globs []*policy.PathGlob, name string) bool {
+	for _, g := range globs {
+		if g.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// countingWriter counts the bytes written to the response.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// failingStorage fails to open or save one path.
+type failingStorage struct {
+	provider.Storage
+	path string
+}
+
+func (s *failingStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if path == s.path {
+		return nil, errors.New("backend unavailable")
+	}
+	return s.Storage.Open(ctx, path)
+}
+
+func (s *failingStorage) Save(ctx context.Context, content io.Reader, path string) error {
+	if path == s.path {
+		return errors.New("backend unavailable")
+	}
+	return s.Storage.Save(ctx, content, path)
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	newStorage := func(t *testing.T) *fs.Storage {
+		root := t.TempDir()
+		backend := fs.NewStorage(fs.Config{Root: root})
+		files := map[string]string{
+			"vod/abc/index.m3u8":      "#EXTM3U",
+			"vod/abc/720p/seg1.ts":    strings.Repeat("a", 64<<10),
+			"vod/abc/720p/seg2.ts":    "segment 2",
+			"vod/abc/private/key":     "secret",
+			"vod/abcdef/other.m3u8":   "other",
+			"vod/abc/720p/index.m3u8": "#EXTM3U 720p",
+		}
+		for p, content := range files {
+			require.NoError(t, backend.Save(ctx, strings.NewReader(content), p))
+			require.NoError(t, os.Chtimes(filepath.Join(root, p), mtime, mtime))
+		}
+		return backend
+	}
+
+	get := func(h http.Handler, dir, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+dir+"/?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ValidatedPathContextKey, dir))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	readTar := func(t *testing.T, r io.Reader) map[string]string {
+		files := make(map[string]string)
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return files
+			}
+			require.NoError(t, err)
+			assert.True(t, hdr.ModTime.Equal(mtime))
+			data, _ := io.ReadAll(tr)
+			files[hdr.Name] = string(data)
+		}
+	}
+
+	t.Run("should stream a zip of the prefix", func(t *testing.T) {
+		h := NewDownloadHandler(nil, func(r *http.Request, method, path string) bool {
+			return !strings.Contains(path, "/private/")
+		})
+		h.streaming = NewStreamingHandler(nil, nil)
+
+		rec := get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.Handle(r.Context(), newStorage(t), w, r)
+		}), "vod/abc", "archive=zip")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename=abc.zip`, rec.Header().Get("Content-Disposition"))
+
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		require.NoError(t, err)
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+			assert.True(t, f.Modified.Equal(mtime))
+		}
+		assert.Equal(t, []string{"720p/index.m3u8", "720p/seg1.ts", "720p/seg2.ts", "index.m3u8"}, names)
+		assert.Equal(t, zip.Store, zr.File[1].Method)
+		assert.Equal(t, zip.Deflate, zr.File[3].Method)
+	})
+
+	t.Run("should filter a tar archive with globs", func(t *testing.T) {
+		h := NewDownloadHandler(NewStreamingHandler(nil, nil), middleware.AllowAll)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.Handle(r.Context(), newStorage(t), w, r)
+		})
+
+		rec := get(handler, "vod/abc", "archive=tar.gz&include=**/*.m3u8,720p/*&exclude=720p/seg1.ts")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		gz, err := gzip.NewReader(rec.Body)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"index.m3u8":      "#EXTM3U",
+			"720p/index.m3u8": "#EXTM3U 720p",
+			"720p/seg2.ts":    "segment 2",
+		}, readTar(t, gz))
+
+		assert.Equal(t, http.StatusBadRequest, get(handler, "vod/abc", "archive=rar").Code)
+		assert.Equal(t, http.StatusNotFound, get(handler, "vod/missing", "archive=tar").Code)
+	})
+
+	t.Run("should abort the response when the archive fails partway", func(t *testing.T) {
+		h := NewDownloadHandler(NewStreamingHandler(nil, nil), middleware.AllowAll)
+		storage := &failingStorage{Storage: newStorage(t), path: "vod/abc/720p/seg2.ts"}
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.Handle(r.Context(), storage, w, r)
+		})
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			get(handler, "vod/abc", "archive=tar")
+		})
+
+		// Nothing was sent yet when the first object fails
+		storage.path = "vod/abc/720p/index.m3u8"
+		rec := get(handler, "vod/abc", "archive=tar")
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Disposition"))
+	})
+}
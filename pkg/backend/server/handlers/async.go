@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/deadletter"
+	"github.com/veloxpack/storage/pkg/backend/server/events"
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/tracing"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// JobsPathPrefix is where job status is served.
+const JobsPathPrefix = "/_jobs/"
+
+// errPersist is returned by submit when an operation could not be recorded
+// in the durable queue.
+var errPersist = errors.New("failed to persist operation")
+
+// replayRetryInterval is how long replay waits for a busy pool.
+const replayRetryInterval = 200 * time.Millisecond
+
+// operation is a storage write executed in the background.
+type operation struct {
+	op      jobs.Op
+	path    string
+	payload []byte
+	tenant  string
+	// replayed operations may already have been applied before a crash
+	replayed bool
+}
+
+func (o operation) run(ctx context.Context, storageBackend provider.Storage) error {
+	switch o.op {
+	case jobs.OpUpload:
+		return storageBackend.Save(ctx, bytes.NewReader(o.payload), o.path)
+	case jobs.OpDelete:
+		err := storageBackend.Delete(ctx, o.path)
+		if o.replayed && errors.Is(err, provider.ErrNotExist) {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown operation: %s", o.op)
+	}
+}
+
+// asyncRunner runs storage operations on worker pools as tracked jobs.
+type asyncRunner struct {
+	jobs        *jobs.Registry
+	queue       *queue.Queue
+	retry       retry.Policy
+	deadLetters *deadletter.Store
+	events      *events.Bus
+	// ordering serialises operations on the same path across both pools
+	ordering *worker.KeyedExecutor
+	pending  *pendingWrites
+	// changes is told about writes as soon as readers can see them
+	changes      *changeNotifier
+	cacheControl *cacheOverrides
+	livePrefixes []string
+	syncWrites   bool
+	syncTimeout  time.Duration
+	logger       *zap.Logger
+}
+
+// submit registers a job for op and runs it on pool once earlier operations
+// on the same path have finished. When a durable queue is configured the
+// operation is persisted before submit returns, so it survives a restart
+// once acknowledged.
+func (a *asyncRunner) submit(ctx context.Context, storageBackend provider.Storage, pool *worker.Pool, op operation) (*jobs.Job, error) {
+	job := a.jobs.Create(op.op, op.path)
+
+	if a.queue != nil {
+		e := &queue.Entry{
+			ID:        job.ID(),
+			Op:        string(op.op),
+			Path:      op.path,
+			Tenant:    op.tenant,
+			Size:      int64(len(op.payload)),
+			CreatedAt: time.Now(),
+		}
+		if err := a.queue.Put(e, op.payload); err != nil {
+			a.jobs.Remove(job.ID())
+			return nil, fmt.Errorf("%w: %w", errPersist, err)
+		}
+	}
+
+	a.pending.add(job.ID(), op)
+	task := a.task(ctx, storageBackend, job, op)
+	if err := a.ordering.Submit(ctx, op.path, pool, a.priority(op.path), task); err != nil {
+		a.pending.remove(op.path, job.ID())
+		a.forget(job)
+		return nil, err
+	}
+	a.changes.notify(op.path)
+	if op.op == jobs.OpDelete {
+		a.cacheControl.set(op.path, "")
+	}
+
+	return job, nil
+}
+
+// task returns the function executing op for job. It runs with a context
+// that keeps the request's values, such as its trace span, but not its
+// cancellation. Transient errors are retried on the same worker; operations
+// that still fail are moved to the dead-letter store.
+func (a *asyncRunner) task(ctx context.Context, storageBackend provider.Storage, job *jobs.Job, op operation) func() {
+	taskCtx := context.WithoutCancel(ctx)
+
+	return func() {
+		job.Start()
+
+		ctx, span := tracing.Start(taskCtx, string(op.op)+".task",
+			attribute.String("storage.path", op.path),
+			attribute.String("job.id", job.ID()),
+		)
+		// Tell creations from updates only when someone listens for events
+		var existed bool
+		if op.op == jobs.OpUpload && a.events.Active() {
+			_, statErr := storageBackend.Stat(ctx, op.path)
+			existed = statErr == nil
+		}
+
+		attempts, err := retry.Do(ctx, a.retry, retry.ClassifierFor(storageBackend), func(ctx context.Context) error {
+			return op.run(ctx, storageBackend)
+		}, func(attempt int, err error) {
+			job.SetAttempts(attempt)
+			a.logger.Warn("Task failed, retrying",
+				zap.String("op", string(op.op)),
+				zap.String("path", op.path),
+				zap.String("job_id", job.ID()),
+				zap.Int("attempt", attempt),
+				zap.Error(err),
+			)
+		})
+		job.SetAttempts(attempts)
+		span.SetAttributes(attribute.Int("task.attempts", attempts))
+		if err != nil {
+			a.logger.Error("Task failed",
+				zap.String("op", string(op.op)),
+				zap.String("path", op.path),
+				zap.String("job_id", job.ID()),
+				zap.Int("attempts", attempts),
+				zap.Error(err),
+			)
+			a.deadLetter(job, op, attempts, err)
+		}
+		tracing.End(span, err)
+		a.publish(job, op, existed, err)
+
+		a.pending.remove(op.path, job.ID())
+		a.ack(job)
+		job.Finish(err)
+	}
+}
+
+// deadLetter records a failed operation for inspection and replay. Deletes
+// of objects that do not exist are not worth replaying and are dropped.
+func (a *asyncRunner) deadLetter(job *jobs.Job, op operation, attempts int, err error) {
+	if a.deadLetters == nil || errors.Is(err, provider.ErrNotExist) {
+		return
+	}
+
+	e := &queue.Entry{
+		ID:        job.ID(),
+		Op:        string(op.op),
+		Path:      op.path,
+		Tenant:    op.tenant,
+		Size:      int64(len(op.payload)),
+		CreatedAt: time.Now(),
+		Attempts:  attempts,
+		Error:     err.Error(),
+	}
+	if err := a.deadLetters.Add(e, op.payload); err != nil {
+		a.logger.Error("Failed to record dead-lettered operation", zap.String("job_id", job.ID()), zap.Error(err))
+	}
+}
+
+// publish emits the event describing the outcome of op. existed reports
+// whether an upload replaced an existing object.
+func (a *asyncRunner) publish(job *jobs.Job, op operation, existed bool, err error) {
+	e := events.Event{Path: op.path, Tenant: op.tenant, JobID: job.ID()}
+
+	switch {
+	case op.op == jobs.OpUpload && err == nil:
+		e.Type = events.ObjectCreated
+		if existed {
+			e.Type = events.ObjectUpdated
+		}
+		e.Size = int64(len(op.payload))
+		e.Checksum = events.Checksum(op.payload)
+	case op.op == jobs.OpUpload:
+		e.Type = events.UploadFailed
+		e.Error = err.Error()
+	case op.op == jobs.OpDelete && err == nil:
+		e.Type = events.ObjectDeleted
+	default:
+		e.Type = events.DeleteFailed
+		e.Error = err.Error()
+	}
+
+	a.events.Publish(e)
+}
+
+// replay resubmits operations left in the durable queue by a previous run,
+// in order behind the same keys as new operations. It returns once every
+// operation was submitted, keeping retrying while the pools are saturated,
+// and stops if they are released.
+func (a *asyncRunner) replay(storageBackend provider.Storage, uploadPool, deletePool *worker.Pool) {
+	if a.queue == nil {
+		return
+	}
+
+	entries, err := a.queue.Pending()
+	if err != nil {
+		a.logger.Error("Failed to read durable queue", zap.Error(err))
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	a.logger.Info("Replaying queued operations", zap.Int("count", len(entries)))
+
+	for _, e := range entries {
+		payload, err := a.queue.Payload(e.ID)
+		if err != nil {
+			a.logger.Error("Failed to read queued payload", zap.String("job_id", e.ID), zap.Error(err))
+			continue
+		}
+
+		op := operation{op: jobs.Op(e.Op), path: e.Path, payload: payload, tenant: e.Tenant, replayed: true}
+		pool := uploadPool
+		if op.op == jobs.OpDelete {
+			pool = deletePool
+		}
+
+		job := a.jobs.Restore(e.ID, op.op, op.path)
+		a.pending.add(job.ID(), op)
+		task := a.task(context.Background(), storageBackend, job, op)
+		for {
+			err := a.ordering.Submit(context.Background(), op.path, pool, a.priority(op.path), task)
+			if err == nil {
+				break
+			}
+			if errors.Is(err, worker.ErrPoolClosed) {
+				return
+			}
+			time.Sleep(replayRetryInterval)
+		}
+	}
+}
+
+// ack removes a finished job's operation from the durable queue.
+func (a *asyncRunner) ack(job *jobs.Job) {
+	if a.queue == nil {
+		return
+	}
+	if err := a.queue.Ack(job.ID()); err != nil {
+		a.logger.Error("Failed to acknowledge queued operation", zap.String("job_id", job.ID()), zap.Error(err))
+	}
+}
+
+// forget drops a job that never ran.
+func (a *asyncRunner) forget(job *jobs.Job) {
+	a.ack(job)
+	a.jobs.Remove(job.ID())
+}
+
+// writeSubmitError answers a request whose operation could not be submitted
+// to pool. Saturation is reported as 429 with a Retry-After estimated from
+// the pool's queue.
+func writeSubmitError(w http.ResponseWriter, message string, pool *worker.Pool, err error) {
+	status := submitStatus(err)
+	if status == http.StatusTooManyRequests {
+		retryAfter := math.Ceil(pool.RetryAfter().Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+	}
+	utils.WriteError(w, message, status, err)
+}
+
+// submitStatus returns the status answering a submit error.
+func submitStatus(err error) int {
+	switch {
+	case errors.Is(err, errPersist):
+		return http.StatusInternalServerError
+	case errors.Is(err, worker.ErrPoolClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusTooManyRequests
+	}
+}
+
+// respond acknowledges a submitted job. The response carries the job's
+// status location; if the client asked to wait, or the server runs in
+// synchronous mode, it reflects the real outcome of the operation instead.
+func (a *asyncRunner) respond(w http.ResponseWriter, r *http.Request, job *jobs.Job, successStatus int) {
+	w.Header().Set("X-Job-Id", job.ID())
+	w.Header().Set("Location", JobsPathPrefix+job.ID())
+
+	wait, timeout := a.shouldWait(r)
+	if !wait {
+		w.WriteHeader(successStatus)
+		return
+	}
+
+	w.Header().Set("Preference-Applied", "wait")
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	err := job.Wait(ctx)
+	switch {
+	case err == nil:
+		w.WriteHeader(successStatus)
+	case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+		// Still running; the client can follow the job's location
+		w.WriteHeader(http.StatusAccepted)
+	case errors.Is(err, provider.ErrNotExist):
+		utils.WriteError(w, "Operation failed", http.StatusNotFound, err)
+	default:
+		utils.WriteError(w, "Operation failed", http.StatusInternalServerError, err)
+	}
+}
+
+// shouldWait parses an RFC 7240 Prefer header. "wait" or "wait=<seconds>"
+// requests a synchronous response and "respond-async" opts out of the
+// server's synchronous mode.
+func (a *asyncRunner) shouldWait(r *http.Request) (bool, time.Duration) {
+	wait, timeout := a.syncWrites, a.syncTimeout
+
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "respond-async":
+				wait = false
+			case "wait":
+				wait = true
+				if secs, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && secs > 0 {
+					timeout = min(time.Duration(secs)*time.Second, a.syncTimeout)
+				}
+			}
+		}
+	}
+
+	return wait, timeout
+}
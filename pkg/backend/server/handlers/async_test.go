@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// slowStorage delays saves so operations overlap.
+type slowStorage struct {
+	provider.Storage
+	delay time.Duration
+}
+
+func (s *slowStorage) Save(ctx context.Context, content io.Reader, path string) error {
+	time.Sleep(s.delay)
+	return s.Storage.Save(ctx, content, path)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should replay queued operations before later requests for their path", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := queue.Open(dir)
+		require.NoError(t, err)
+		require.NoError(t, q.Put(&queue.Entry{
+			ID:        "job-1",
+			Op:        "upload",
+			Path:      "live/index.m3u8",
+			Size:      int64(len("replayed")),
+			CreatedAt: time.Now(),
+		}, []byte("replayed")))
+
+		// The server restarts with the operation still queued
+		q, err = queue.Open(dir)
+		require.NoError(t, err)
+		backend := &slowStorage{Storage: fs.NewStorage(fs.Config{Root: t.TempDir()}), delay: 50 * time.Millisecond}
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		h := NewStorageHandler(backend, pool, pool, WithQueue(q))
+		t.Cleanup(h.Shutdown)
+
+		st, err := h.Storage().Stat(ctx, "live/index.m3u8")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("replayed")), st.Size)
+
+		req := httptest.NewRequest(http.MethodPut, "/live/index.m3u8", strings.NewReader("new"))
+		req.Header.Set("Prefer", "wait")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ValidatedPathContextKey, "live/index.m3u8"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		r, err := backend.Open(ctx, "live/index.m3u8")
+		require.NoError(t, err)
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		assert.Equal(t, "new", string(data))
+		assert.Equal(t, 0, q.Len())
+	})
+}
+
+func TestJobs(t *testing.T) {
+	newHandler := func(t *testing.T, backend provider.Storage, opts ...Option) (*StorageHandler, *jobs.Registry) {
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		registry := jobs.NewRegistry(time.Hour)
+		opts = append(opts, WithJobs(registry), WithRetryPolicy(retry.Policy{MaxAttempts: 1}))
+		h := NewStorageHandler(backend, pool, pool, opts...)
+		t.Cleanup(h.Shutdown)
+		return h, registry
+	}
+
+	do := func(h http.Handler, method, path, body, prefer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/"+path, strings.NewReader(body))
+		if prefer != "" {
+			req.Header.Set("Prefer", prefer)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ValidatedPathContextKey, path))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	status := func(t *testing.T, registry *jobs.Registry, location string) (int, jobs.Snapshot) {
+		require.True(t, strings.HasPrefix(location, JobsPathPrefix), location)
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, location, nil))
+		var snap jobs.Snapshot
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+		}
+		return rec.Code, snap
+	}
+
+	t.Run("should point to the job that completes the operation", func(t *testing.T) {
+		h, registry := newHandler(t, fs.NewStorage(fs.Config{Root: t.TempDir()}))
+
+		rec := do(h, http.MethodPut, "vod/a.ts", "segment", "")
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, JobsPathPrefix+rec.Header().Get("X-Job-Id"), rec.Header().Get("Location"))
+
+		assert.Eventually(t, func() bool {
+			code, snap := status(t, registry, rec.Header().Get("Location"))
+			return code == http.StatusOK && snap.State == jobs.StateSucceeded
+		}, time.Second, 10*time.Millisecond)
+
+		code, _ := status(t, registry, JobsPathPrefix+"unknown")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("should answer with the outcome when asked to wait", func(t *testing.T) {
+		backend := &failingStorage{Storage: fs.NewStorage(fs.Config{Root: t.TempDir()}), path: "vod/broken.ts"}
+		h, registry := newHandler(t, backend)
+
+		rec := do(h, http.MethodPut, "vod/a.ts", "segment", "wait")
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, "wait", rec.Header().Get("Preference-Applied"))
+
+		rec = do(h, http.MethodPut, "vod/broken.ts", "segment", "wait")
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		_, snap := status(t, registry, rec.Header().Get("Location"))
+		assert.Equal(t, jobs.StateFailed, snap.State)
+		assert.Equal(t, "backend unavailable", snap.Error)
+
+		rec = do(h, http.MethodDelete, "vod/missing.ts", "", "wait")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("should answer 202 while the job is still pending", func(t *testing.T) {
+		backend := &slowStorage{Storage: fs.NewStorage(fs.Config{Root: t.TempDir()}), delay: 200 * time.Millisecond}
+		h, registry := newHandler(t, backend, WithSyncWrites(true, 20*time.Millisecond))
+
+		rec := do(h, http.MethodPut, "vod/a.ts", "segment", "")
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		_, snap := status(t, registry, rec.Header().Get("Location"))
+		assert.Contains(t, []jobs.State{jobs.StatePending, jobs.StateRunning}, snap.State)
+
+		rec = do(h, http.MethodPut, "vod/b.ts", "segment", "respond-async")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Empty(t, rec.Header().Get("Preference-Applied"))
+	})
+}
@@ -1,11 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/veloxpack/storage/pkg/backend/server/cache"
+	"github.com/veloxpack/storage/pkg/backend/server/deadletter"
+	"github.com/veloxpack/storage/pkg/backend/server/events"
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
 	"github.com/veloxpack/storage/pkg/backend/server/utils"
 	"github.com/veloxpack/storage/pkg/backend/server/worker"
 	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
 )
 
 type StorageHandler struct {
@@ -13,19 +24,263 @@ type StorageHandler struct {
 	download  *DownloadHandler
 	delete    *DeleteHandler
 	streaming *StreamingHandler
+	events    *events.Bus
+	dead      *DeadLetterHandler
+	batch     *BatchHandler
+	ordering  *worker.KeyedExecutor
 	storage   provider.Storage
+	// reads is storage with acknowledged but unfinished writes overlaid
+	reads provider.Storage
 }
 
-func NewStorageHandler(storage provider.Storage, uploadPool, deletePool *worker.Pool) *StorageHandler {
-	streaming := NewStreamingHandler()
+// Option configures a StorageHandler.
+type Option func(*options)
 
-	return &StorageHandler{
+type options struct {
+	jobs         *jobs.Registry
+	queue        *queue.Queue
+	retry        retry.Policy
+	deadLetters  *deadletter.Store
+	events       *events.Bus
+	livePrefixes []string
+	syncWrites   bool
+	syncTimeout  time.Duration
+	liveTimeout  time.Duration
+	liveWindow   int64
+	liveMemory   int64
+	liveMaxSize  int64
+	reloadWait   time.Duration
+	caching      *cache.Policy
+	maxPartSize  int64
+	extract      extractLimits
+	batchWorkers int
+	authorize    middleware.Authorizer
+}
+
+// WithJobs sets the registry background uploads and deletes are tracked in.
+func WithJobs(registry *jobs.Registry) Option {
+	return func(o *options) {
+		o.jobs = registry
+	}
+}
+
+// WithQueue persists background operations in q before acknowledging them
+// and replays whatever a previous run left unfinished.
+func WithQueue(q *queue.Queue) Option {
+	return func(o *options) {
+		o.queue = q
+	}
+}
+
+// WithRetryPolicy sets how failed background operations are retried.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// WithDeadLetters records operations that could not be completed in store.
+func WithDeadLetters(store *deadletter.Store) Option {
+	return func(o *options) {
+		o.deadLetters = store
+	}
+}
+
+// WithEvents publishes the outcome of uploads and deletes on bus.
+func WithEvents(bus *events.Bus) Option {
+	return func(o *options) {
+		o.events = bus
+	}
+}
+
+// WithLivePrefixes sets the path prefixes whose writes are admitted to the
+// worker pools ahead of VOD content.
+func WithLivePrefixes(prefixes []string) Option {
+	return func(o *options) {
+		o.livePrefixes = prefixes
+	}
+}
+
+// WithSyncWrites makes uploads and deletes respond only once the backend
+// operation finished, waiting at most timeout.
+func WithSyncWrites(enabled bool, timeout time.Duration) Option {
+	return func(o *options) {
+		o.syncWrites = enabled
+		if timeout > 0 {
+			o.syncTimeout = timeout
+		}
+	}
+}
+
+// WithLiveWriteTimeout sets how long a reader following a chunked upload may
+// take to accept each write before it is disconnected.
+func WithLiveWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.liveTimeout = timeout
+		}
+	}
+}
+
+// WithLiveBuffer sets how much of each chunked upload is kept in memory for
+// its readers, and how much memory all of them may use together. Older bytes
+// are spilled to a temporary file.
+func WithLiveBuffer(window, memory int64) Option {
+	return func(o *options) {
+		if window > 0 {
+			o.liveWindow = window
+		}
+		if memory > 0 {
+			o.liveMemory = memory
+		}
+	}
+}
+
+// WithMaxChunkedUploadSize limits the size of each chunked upload. Zero, the
+// default, leaves them unlimited, since their memory is bounded by
+// WithLiveBuffer.
+func WithMaxChunkedUploadSize(n int64) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.liveMaxSize = n
+		}
+	}
+}
+
+// WithBlockingReloadTimeout sets how long a blocking playlist reload may wait
+// for the segment or part it asks for.
+func WithBlockingReloadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.reloadWait = timeout
+		}
+	}
+}
+
+// WithCachePolicy sets the caching headers of downloads.
+func WithCachePolicy(p *cache.Policy) Option {
+	return func(o *options) {
+		if p != nil {
+			o.caching = p
+		}
+	}
+}
+
+// WithMaxPartSize limits the size of each file of a multipart/form-data
+// upload.
+func WithMaxPartSize(n int64) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxPartSize = n
+		}
+	}
+}
+
+// WithExtractLimits bounds the number of files and the total size extracted
+// from one uploaded archive.
+func WithExtractLimits(entries int, size int64) Option {
+	return func(o *options) {
+		if entries > 0 {
+			o.extract.entries = entries
+		}
+		if size > 0 {
+			o.extract.size = size
+		}
+	}
+}
+
+// WithBatchConcurrency sets how many operations of a batch run at once.
+func WithBatchConcurrency(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.batchWorkers = n
+		}
+	}
+}
+
+// WithAuthorizer checks the storage paths a request writes to besides its
+// own, such as the files of a form upload.
+func WithAuthorizer(authorize middleware.Authorizer) Option {
+	return func(o *options) {
+		o.authorize = authorize
+	}
+}
+
+func NewStorageHandler(storage provider.Storage, uploadPool, deletePool *worker.Pool, opts ...Option) *StorageHandler {
+	o := &options{
+		jobs:         jobs.NewRegistry(time.Hour),
+		retry:        retry.DefaultPolicy(),
+		deadLetters:  deadletter.New(),
+		livePrefixes: DefaultLivePrefixes,
+		syncTimeout:  30 * time.Second,
+		liveTimeout:  DefaultLiveWriteTimeout,
+		liveWindow:   DefaultLiveWindow,
+		liveMemory:   DefaultLiveMemory,
+		reloadWait:   DefaultBlockingReloadTimeout,
+		caching:      cache.Default(),
+		maxPartSize:  utils.MaxUploadSize,
+		extract:      extractLimits{entries: DefaultExtractEntries, size: DefaultExtractSize},
+		batchWorkers: 16,
+		authorize:    middleware.AllowAll,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ordering := worker.NewKeyedExecutor()
+	streaming := NewStreamingHandler(ordering, o.events)
+	streaming.writeTimeout = o.liveTimeout
+	streaming.window = o.liveWindow
+	streaming.memory = newMemoryBudget(o.liveMemory)
+	streaming.maxSize = o.liveMaxSize
+	runner := &asyncRunner{
+		jobs:         o.jobs,
+		queue:        o.queue,
+		retry:        o.retry,
+		deadLetters:  o.deadLetters,
+		events:       o.events,
+		ordering:     ordering,
+		pending:      newPendingWrites(),
+		changes:      streaming.changes,
+		cacheControl: streaming.cacheControl,
+		livePrefixes: o.livePrefixes,
+		syncWrites:   o.syncWrites,
+		syncTimeout:  o.syncTimeout,
+		logger:       zap.L().Named("jobs"),
+	}
+
+	// Replayed operations take their paths before any request is served
+	runner.replay(storage, uploadPool, deletePool)
+
+	download := NewDownloadHandler(streaming, o.authorize)
+	download.reloadTimeout = o.reloadWait
+	download.caching = o.caching
+
+	h := &StorageHandler{
 		storage:   storage,
+		reads:     &pendingStorage{Storage: storage, pending: runner.pending},
 		streaming: streaming,
-		upload:    NewUploadHandler(uploadPool, utils.MaxUploadSize, streaming),
-		download:  NewDownloadHandler(streaming),
-		delete:    NewDeleteHandler(deletePool),
+		events:    o.events,
+		ordering:  ordering,
+		upload:    NewUploadHandler(uploadPool, utils.MaxUploadSize, o.maxPartSize, o.extract, o.authorize, streaming, runner),
+		download:  download,
+		delete:    NewDeleteHandler(deletePool, runner),
+		dead: &DeadLetterHandler{
+			store:      o.deadLetters,
+			runner:     runner,
+			storage:    storage,
+			uploadPool: uploadPool,
+			deletePool: deletePool,
+			logger:     zap.L().Named("deadletter"),
+		},
 	}
+	h.batch = &BatchHandler{
+		storage:     h,
+		concurrency: o.batchWorkers,
+		authorize:   o.authorize,
+		logger:      zap.L().Named("batch"),
+	}
+	return h
 }
 
 func (h *StorageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -33,7 +288,7 @@ func (h *StorageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		h.download.Handle(ctx, h.storage, w, r)
+		h.download.Handle(ctx, h.reads, w, r)
 	case http.MethodPost, http.MethodPut:
 		h.upload.Handle(ctx, h.storage, w, r)
 	case http.MethodDelete:
@@ -43,6 +298,56 @@ func (h *StorageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SaveStream writes content to path in order with background uploads and
+// deletes of the same path, publishing the outcome like a direct upload.
+func (h *StorageHandler) SaveStream(ctx context.Context, path string, content io.Reader) error {
+	return h.streaming.save(ctx, h.storage, path, content)
+}
+
+// Remove deletes path in order with background uploads and deletes of the
+// same path, publishing the outcome like a direct delete.
+func (h *StorageHandler) Remove(ctx context.Context, path string) error {
+	return h.streaming.remove(ctx, h.storage, path)
+}
+
+// Storage returns the backend as readers see it, including acknowledged
+// writes that have not completed yet.
+func (h *StorageHandler) Storage() provider.Storage {
+	return h.reads
+}
+
+// ActiveUploads returns the number of chunked uploads in progress.
+func (h *StorageHandler) ActiveUploads() int {
+	return h.streaming.ActiveUploads()
+}
+
+// LiveReaders returns the number of readers following an active upload.
+func (h *StorageHandler) LiveReaders() int {
+	return h.streaming.LiveReaders()
+}
+
+// LiveMemory returns the number of bytes held in memory for the readers of
+// chunked uploads.
+func (h *StorageHandler) LiveMemory() int64 {
+	return h.streaming.LiveMemory()
+}
+
+// WaitingWrites returns the number of uploads and deletes waiting for an
+// earlier operation on the same path.
+func (h *StorageHandler) WaitingWrites() int {
+	return h.ordering.Waiting()
+}
+
+// DeadLetters returns the handler administering dead-lettered operations.
+func (h *StorageHandler) DeadLetters() http.Handler {
+	return h.dead
+}
+
+// Batch returns the handler running batches of operations.
+func (h *StorageHandler) Batch() http.Handler {
+	return h.batch
+}
+
 func (h *StorageHandler) Shutdown() {
 	h.streaming.Shutdown()
 }
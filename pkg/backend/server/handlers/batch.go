@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+// BatchPath is where batches of operations are submitted.
+const BatchPath = "/_batch"
+
+const (
+	// maxBatchSize bounds the number of operations in one batch.
+	maxBatchSize = 10000
+	// maxBatchBody bounds the size of a batch request.
+	maxBatchBody = 8 << 20
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// Batch operations.
+const (
+	BatchStat   = "stat"
+	BatchDelete = "delete"
+	BatchCopy   = "copy"
+	BatchMove   = "move"
+)
+
+var (
+	errUnknownBatchOp   = errors.New("unknown operation")
+	errMissingBatchDest = errors.New("missing destination")
+	errSameBatchDest    = errors.New("destination is the source")
+)
+
+// batchOp is one operation of a batch.
+type batchOp struct {
+	Op          string `json:"op"`
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// batchResult is the outcome of one operation. Index is its position in the
+// batch, since streamed results arrive in completion order.
+type batchResult struct {
+	Index       int            `json:"index"`
+	Op          string         `json:"op"`
+	Path        string         `json:"path"`
+	Destination string         `json:"destination,omitempty"`
+	Status      int            `json:"status"`
+	Error       string         `json:"error,omitempty"`
+	Stat        *provider.Stat `json:"stat,omitempty"`
+}
+
+// BatchHandler runs a JSON list of stat, delete, copy and move operations
+// with bounded concurrency:
+//
+//	POST /_batch
+//	[{"op": "delete", "path": "vod/a.ts"}, {"op": "copy", "path": "vod/b.ts", "destination": "archive/b.ts"}]
+//
+// Results are returned together in batch order, or streamed as NDJSON in
+// completion order when the client accepts application/x-ndjson. Writes go
+// through the same ordered paths as single requests and publish events.
+type BatchHandler struct {
+	storage     *StorageHandler
+	concurrency int
+	authorize   middleware.Authorizer
+	logger      *zap.Logger
+}
+
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var ops []batchOp
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&ops); err != nil {
+		utils.WriteError(w, "Invalid batch", http.StatusBadRequest, err)
+		return
+	}
+	if len(ops) > maxBatchSize {
+		utils.WriteError(w, "Batch too large", http.StatusRequestEntityTooLarge,
+			fmt.Errorf("%d operations, at most %d allowed", len(ops), maxBatchSize))
+		return
+	}
+
+	if acceptsNDJSON(r) {
+		h.stream(w, r, ops)
+		return
+	}
+
+	results := make([]*batchResult, len(ops))
+	h.run(r, ops, func(res *batchResult) {
+		results[res.Index] = res
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"results": results}); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// stream writes every result as a line once its operation finished.
+func (h *BatchHandler) stream(w http.ResponseWriter, r *http.Request, ops []batchOp) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var mu sync.Mutex
+	h.run(r, ops, func(res *batchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(res); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+}
+
+// run executes ops on at most h.concurrency goroutines, calling report with
+// each result. Operations not started when the client goes away are
+// reported as failed.
+func (h *BatchHandler) run(r *http.Request, ops []batchOp, report func(*batchResult)) {
+	sem := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+
+	for i, op := range ops {
+		select {
+		case sem <- struct{}{}:
+		case <-r.Context().Done():
+			res := newBatchResult(i, op)
+			res.fail(http.StatusServiceUnavailable, r.Context().Err())
+			report(res)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			report(h.exec(r, i, op))
+		}()
+	}
+
+	wg.Wait()
+}
+
+func newBatchResult(i int, op batchOp) *batchResult {
+	return &batchResult{Index: i, Op: op.Op, Path: op.Path, Destination: op.Destination}
+}
+
+func (res *batchResult) fail(status int, err error) {
+	res.Status = status
+	res.Error = err.Error()
+}
+
+// exec runs one operation after checking it against the policy.
+func (h *BatchHandler) exec(r *http.Request, i int, op batchOp) *batchResult {
+	res := newBatchResult(i, op)
+
+	src, err := utils.SanitizePath(op.Path)
+	if err != nil {
+		res.fail(http.StatusBadRequest, err)
+		return res
+	}
+	res.Path = src
+
+	var dst string
+	if op.Op == BatchCopy || op.Op == BatchMove {
+		if op.Destination == "" {
+			res.fail(http.StatusBadRequest, errMissingBatchDest)
+			return res
+		}
+		if dst, err = utils.SanitizePath(op.Destination); err != nil {
+			res.fail(http.StatusBadRequest, err)
+			return res
+		}
+		res.Destination = dst
+		// Saving over the object being read would empty it, and a move
+		// would then delete it
+		if dst == src {
+			res.fail(http.StatusBadRequest, errSameBatchDest)
+			return res
+		}
+	}
+
+	var checks [][2]string
+	switch op.Op {
+	case BatchStat:
+		checks = [][2]string{{http.MethodGet, src}}
+	case BatchDelete:
+		checks = [][2]string{{http.MethodDelete, src}}
+	case BatchCopy:
+		checks = [][2]string{{http.MethodGet, src}, {http.MethodPut, dst}}
+	case BatchMove:
+		checks = [][2]string{{http.MethodGet, src}, {http.MethodDelete, src}, {http.MethodPut, dst}}
+	default:
+		res.fail(http.StatusBadRequest, errUnknownBatchOp)
+		return res
+	}
+	for _, c := range checks {
+		if !h.authorize(r, c[0], c[1]) {
+			res.fail(http.StatusForbidden, errAccessDenied)
+			return res
+		}
+	}
+
+	ctx := r.Context()
+	switch op.Op {
+	case BatchStat:
+		res.Stat, err = h.storage.reads.Stat(ctx, src)
+		res.Status = http.StatusOK
+	case BatchDelete:
+		err = h.storage.Remove(ctx, src)
+		res.Status = http.StatusNoContent
+	case BatchCopy:
+		err = h.copy(ctx, src, dst)
+		res.Status = http.StatusCreated
+	case BatchMove:
+		if err = h.copy(ctx, src, dst); err == nil {
+			err = h.storage.Remove(ctx, src)
+		}
+		res.Status = http.StatusCreated
+	}
+
+	switch {
+	case errors.Is(err, provider.ErrNotExist):
+		res.fail(http.StatusNotFound, err)
+	case err != nil:
+		res.fail(http.StatusInternalServerError, err)
+	}
+	return res
+}
+
+func (h *BatchHandler) copy(ctx context.Context, src, dst string) error {
+	rc, err := h.storage.reads.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return h.storage.SaveStream(ctx, dst, rc)
+}
+
+// acceptsNDJSON reports whether the client asked for streamed results.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == ndjsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
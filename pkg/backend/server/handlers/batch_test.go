@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T, opts ...Option) (http.Handler, *fs.Storage) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		for _, p := range []string{"vod/a.ts", "vod/b.ts", "vod/c.ts", "private/d.ts"} {
+			require.NoError(t, backend.Save(ctx, strings.NewReader(p), p))
+		}
+		pool, err := worker.NewPool(2)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		return NewStorageHandler(backend, pool, pool, opts...).Batch(), backend
+	}
+
+	post := func(h http.Handler, body, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, BatchPath, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("should return the result of every operation in order", func(t *testing.T) {
+		h, backend := newHandler(t, WithBatchConcurrency(2), WithAuthorizer(func(r *http.Request, method, path string) bool {
+			return !strings.HasPrefix(path, "private/")
+		}))
+
+		rec := post(h, `[
+			{"op": "stat", "path": "vod/a.ts"},
+			{"op": "delete", "path": "vod/b.ts"},
+			{"op": "copy", "path": "vod/c.ts", "destination": "archive/c.ts"},
+			{"op": "move", "path": "vod/a.ts", "destination": "archive/a.ts"},
+			{"op": "stat", "path": "vod/missing.ts"},
+			{"op": "delete", "path": "private/d.ts"},
+			{"op": "copy", "path": "vod/c.ts"},
+			{"op": "rename", "path": "vod/c.ts"}
+		]`, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			Results []batchResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Results, 8)
+		for i, res := range resp.Results {
+			assert.Equal(t, i, res.Index)
+		}
+		// Operations on the same path may run in any order
+		assert.Contains(t, []int{http.StatusOK, http.StatusNotFound}, resp.Results[0].Status)
+		assert.Equal(t, http.StatusNoContent, resp.Results[1].Status)
+		assert.Equal(t, http.StatusCreated, resp.Results[2].Status)
+		assert.Equal(t, http.StatusCreated, resp.Results[3].Status)
+		assert.Equal(t, http.StatusNotFound, resp.Results[4].Status)
+		assert.Equal(t, http.StatusForbidden, resp.Results[5].Status)
+		assert.Equal(t, http.StatusBadRequest, resp.Results[6].Status)
+		assert.Equal(t, http.StatusBadRequest, resp.Results[7].Status)
+
+		_, err := backend.Stat(ctx, "vod/b.ts")
+		assert.Error(t, err)
+		_, err = backend.Stat(ctx, "vod/a.ts")
+		assert.Error(t, err)
+		r, err := backend.Open(ctx, "archive/a.ts")
+		require.NoError(t, err)
+		data, _ := io.ReadAll(r)
+		r.Close()
+		assert.Equal(t, "vod/a.ts", string(data))
+		_, err = backend.Stat(ctx, "private/d.ts")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should stream results as NDJSON", func(t *testing.T) {
+		h, _ := newHandler(t)
+
+		rec := post(h, `[{"op": "stat", "path": "vod/a.ts"}, {"op": "stat", "path": "vod/b.ts"}, {"op": "delete", "path": "vod/c.ts"}]`,
+			"application/json;q=0.5, application/x-ndjson")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+		seen := make(map[int]int)
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var res batchResult
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+			seen[res.Index] = res.Status
+		}
+		assert.Equal(t, map[int]int{0: http.StatusOK, 1: http.StatusOK, 2: http.StatusNoContent}, seen)
+	})
+
+	t.Run("should refuse to copy or move an object onto itself", func(t *testing.T) {
+		h, backend := newHandler(t)
+
+		rec := post(h, `[
+			{"op": "copy", "path": "vod/a.ts", "destination": "vod/a.ts"},
+			{"op": "move", "path": "vod/b.ts", "destination": "/vod//b.ts"}
+		]`, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			Results []batchResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Results, 2)
+		for _, res := range resp.Results {
+			assert.Equal(t, http.StatusBadRequest, res.Status)
+		}
+
+		for _, p := range []string{"vod/a.ts", "vod/b.ts"} {
+			r, err := backend.Open(ctx, p)
+			require.NoError(t, err)
+			data, _ := io.ReadAll(r)
+			r.Close()
+			assert.Equal(t, p, string(data))
+		}
+	})
+
+	t.Run("should reject invalid batches", func(t *testing.T) {
+		h, _ := newHandler(t)
+
+		assert.Equal(t, http.StatusBadRequest, post(h, `{"op": "stat"}`, "").Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post(h, "["+strings.Repeat(`{},`, maxBatchSize)+"{}]", "").Code)
+	})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import "sync"
+
+// maxCacheOverrides bounds the number of objects cacheOverrides remembers.
+const maxCacheOverrides = 100000
+
+// cacheOverrides remembers the Cache-Control headers uploaders sent with
+// objects, served instead of the caching rules until the object is replaced
+// or deleted. They are kept in memory only; once maxCacheOverrides objects
+// have one, further objects get the caching rules.
+type cacheOverrides struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func newCacheOverrides() *cacheOverrides {
+	return &cacheOverrides{values: make(map[string]string)}
+}
+
+// set records the Cache-Control header of path; an empty value forgets it.
+func (c *cacheOverrides) set(path, cacheControl string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cacheControl == "" {
+		delete(c.values, path)
+		return
+	}
+	if _, ok := c.values[path]; !ok && len(c.values) >= maxCacheOverrides {
+		return
+	}
+	c.values[path] = cacheControl
+}
+
+func (c *cacheOverrides) get(path string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values[path]
+}
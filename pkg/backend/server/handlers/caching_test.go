@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	pool, err := worker.NewPool(4)
+	require.NoError(t, err)
+	t.Cleanup(pool.Release)
+	h := NewStorageHandler(fs.NewStorage(fs.Config{Root: t.TempDir()}), pool, pool)
+	t.Cleanup(h.Shutdown)
+
+	do := func(method, path, cacheControl string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/"+path, strings.NewReader("content"))
+		r.Header.Set("Prefer", "wait")
+		if cacheControl != "" {
+			r.Header.Set("Cache-Control", cacheControl)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), middleware.ValidatedPathContextKey, path))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("should serve media types and caching headers by extension", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, do(http.MethodPut, "live/abc/seg1.ts", "").Code)
+
+		w := do(http.MethodGet, "live/abc/seg1.ts", "")
+		assert.Equal(t, "video/mp2t", w.Header().Get("Content-Type"))
+		assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+		assert.NotEmpty(t, w.Header().Get("Expires"))
+
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "live/abc/seg2.ts", "").Code)
+	})
+
+	t.Run("should serve the Cache-Control sent with the upload until it is deleted", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, do(http.MethodPut, "live/abc/seg3.ts", "no-store").Code)
+		assert.Equal(t, "no-store", do(http.MethodGet, "live/abc/seg3.ts", "").Header().Get("Cache-Control"))
+
+		require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "live/abc/seg3.ts", "").Code)
+		require.Equal(t, http.StatusCreated, do(http.MethodPut, "live/abc/seg3.ts", "").Code)
+		assert.Equal(t, "public, max-age=31536000, immutable", do(http.MethodGet, "live/abc/seg3.ts", "").Header().Get("Cache-Control"))
+	})
+
+	t.Run("should forget the Cache-Control of objects replaced or removed directly", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, do(http.MethodPut, "live/abc/seg4.ts", "no-store").Code)
+		require.NoError(t, h.SaveStream(context.Background(), "live/abc/seg4.ts", strings.NewReader("replaced")))
+		assert.Equal(t, "public, max-age=31536000, immutable", do(http.MethodGet, "live/abc/seg4.ts", "").Header().Get("Cache-Control"))
+
+		require.Equal(t, http.StatusCreated, do(http.MethodPut, "live/abc/seg5.ts", "no-store").Code)
+		require.NoError(t, h.Remove(context.Background(), "live/abc/seg5.ts"))
+		assert.Empty(t, h.streaming.cacheControl.get("live/abc/seg5.ts"))
+	})
+
+	t.Run("should bound the number of overrides", func(t *testing.T) {
+		c := newCacheOverrides()
+		for i := range maxCacheOverrides {
+			c.set(strconv.Itoa(i), "no-store")
+		}
+		c.set("one-too-many", "no-store")
+		assert.Empty(t, c.get("one-too-many"))
+
+		c.set("0", "no-cache")
+		assert.Equal(t, "no-cache", c.get("0"))
+		c.set("0", "")
+		c.set("one-too-many", "no-store")
+		assert.Equal(t, "no-store", c.get("one-too-many"))
+	})
+}
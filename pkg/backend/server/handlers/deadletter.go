@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/deadletter"
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+const (
+	// DeadLetterPath is where dead-lettered operations are administered.
+	DeadLetterPath = "/_admin/deadletter"
+	// WebhooksPath is where webhook delivery status is served.
+	WebhooksPath = "/_admin/webhooks"
+)
+
+var errEntryNotFound = errors.New("unknown dead-letter entry")
+
+// DeadLetterHandler lists, inspects, discards and replays dead-lettered
+// operations:
+//
+//	GET    /_admin/deadletter
+//	GET    /_admin/deadletter/{id}
+//	DELETE /_admin/deadletter/{id}
+//	POST   /_admin/deadletter/{id}/replay
+type DeadLetterHandler struct {
+	store      *deadletter.Store
+	runner     *asyncRunner
+	storage    provider.Storage
+	uploadPool *worker.Pool
+	deletePool *worker.Pool
+	logger     *zap.Logger
+}
+
+func (h *DeadLetterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, DeadLetterPath), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.writeJSON(w, h.store.List())
+	case id != "" && action == "" && r.Method == http.MethodGet:
+		e, ok := h.store.Get(id)
+		if !ok {
+			utils.WriteError(w, "Entry not found", http.StatusNotFound, errEntryNotFound)
+			return
+		}
+		h.writeJSON(w, e)
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		if _, ok := h.store.Get(id); !ok {
+			utils.WriteError(w, "Entry not found", http.StatusNotFound, errEntryNotFound)
+			return
+		}
+		if err := h.store.Remove(id); err != nil {
+			utils.WriteError(w, "Failed to remove entry", http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case id != "" && action == "replay" && r.Method == http.MethodPost:
+		h.replay(r.Context(), w, r, id)
+	default:
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// replay resubmits a dead-lettered operation as a new job. The entry is
+// removed once the operation is accepted; if it fails again it is
+// dead-lettered under the new job's id.
+func (h *DeadLetterHandler) replay(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	e, ok := h.store.Get(id)
+	if !ok {
+		utils.WriteError(w, "Entry not found", http.StatusNotFound, errEntryNotFound)
+		return
+	}
+
+	payload, err := h.store.Payload(id)
+	if err != nil {
+		utils.WriteError(w, "Failed to read entry payload", http.StatusInternalServerError, err)
+		return
+	}
+
+	op := operation{op: jobs.Op(e.Op), path: e.Path, payload: payload, tenant: e.Tenant, replayed: true}
+	pool := h.uploadPool
+	if op.op == jobs.OpDelete {
+		pool = h.deletePool
+	}
+
+	job, err := h.runner.submit(ctx, h.storage, pool, op)
+	if err != nil {
+		writeSubmitError(w, "Replay failed to submit", pool, err)
+		return
+	}
+
+	if err := h.store.Remove(id); err != nil {
+		h.logger.Error("Failed to remove replayed entry", zap.String("id", id), zap.Error(err))
+	}
+
+	h.runner.respond(w, r, job, http.StatusAccepted)
+}
+
+func (h *DeadLetterHandler) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
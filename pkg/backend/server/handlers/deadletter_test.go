@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/deadletter"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestDeadLetterHandler(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T, store *deadletter.Store) (*StorageHandler, *failingStorage) {
+		backend := &failingStorage{Storage: fs.NewStorage(fs.Config{Root: t.TempDir()}), path: "vod/broken.ts"}
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		h := NewStorageHandler(backend, pool, pool, WithDeadLetters(store), WithRetryPolicy(retry.Policy{MaxAttempts: 2}))
+		t.Cleanup(h.Shutdown)
+		return h, backend
+	}
+
+	do := func(h http.Handler, method, target string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("segment"))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		path := strings.TrimPrefix(target, "/")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ValidatedPathContextKey, path))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	list := func(t *testing.T, h *StorageHandler) []queue.Entry {
+		rec := do(h.DeadLetters(), http.MethodGet, DeadLetterPath, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var entries []queue.Entry
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+		return entries
+	}
+
+	wait := map[string]string{"Prefer": "wait"}
+
+	t.Run("should list operations that ran out of retries", func(t *testing.T) {
+		h, _ := newHandler(t, deadletter.New())
+
+		rec := do(h, http.MethodPut, "/vod/broken.ts", wait)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+		entries := list(t, h)
+		require.Len(t, entries, 1)
+		assert.Equal(t, rec.Header().Get("X-Job-Id"), entries[0].ID)
+		assert.Equal(t, "upload", entries[0].Op)
+		assert.Equal(t, "vod/broken.ts", entries[0].Path)
+		assert.Equal(t, 2, entries[0].Attempts)
+		assert.Equal(t, "backend unavailable", entries[0].Error)
+
+		rec = do(h.DeadLetters(), http.MethodGet, DeadLetterPath+"/"+entries[0].ID, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, http.StatusNotFound, do(h.DeadLetters(), http.MethodGet, DeadLetterPath+"/unknown", nil).Code)
+
+		rec = do(h.DeadLetters(), http.MethodDelete, DeadLetterPath+"/"+entries[0].ID, nil)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, list(t, h))
+	})
+
+	t.Run("should replay an entry once the backend recovers", func(t *testing.T) {
+		h, backend := newHandler(t, deadletter.New())
+		require.Equal(t, http.StatusInternalServerError, do(h, http.MethodPut, "/vod/broken.ts", wait).Code)
+		entries := list(t, h)
+		require.Len(t, entries, 1)
+
+		backend.path = ""
+		rec := do(h.DeadLetters(), http.MethodPost, DeadLetterPath+"/"+entries[0].ID+"/replay", wait)
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		assert.NotEqual(t, entries[0].ID, rec.Header().Get("X-Job-Id"))
+		assert.Empty(t, list(t, h))
+
+		r, err := backend.Open(ctx, "vod/broken.ts")
+		require.NoError(t, err)
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		assert.Equal(t, "segment", string(data))
+
+		rec = do(h.DeadLetters(), http.MethodPost, DeadLetterPath+"/"+entries[0].ID+"/replay", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("should replay entries persisted before a restart", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := deadletter.Open(dir)
+		require.NoError(t, err)
+		h, _ := newHandler(t, store)
+		require.Equal(t, http.StatusInternalServerError, do(h, http.MethodPut, "/vod/broken.ts", wait).Code)
+
+		store, err = deadletter.Open(dir)
+		require.NoError(t, err)
+		h, backend := newHandler(t, store)
+		entries := list(t, h)
+		require.Len(t, entries, 1)
+
+		backend.path = ""
+		rec := do(h.DeadLetters(), http.MethodPost, DeadLetterPath+"/"+entries[0].ID+"/replay", wait)
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		assert.Eventually(t, func() bool {
+			_, err := backend.Stat(ctx, "vod/broken.ts")
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 0, store.Len())
+	})
+}
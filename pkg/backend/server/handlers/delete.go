@@ -4,8 +4,8 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
 	"github.com/veloxpack/storage/pkg/backend/server/middleware"
-	"github.com/veloxpack/storage/pkg/backend/server/utils"
 	"github.com/veloxpack/storage/pkg/backend/server/worker"
 	"github.com/veloxpack/storage/pkg/storage/provider"
 	"go.uber.org/zap"
@@ -14,11 +14,13 @@ import (
 type DeleteHandler struct {
 	pool   *worker.Pool
 	logger *zap.Logger
+	runner *asyncRunner
 }
 
-func NewDeleteHandler(pool *worker.Pool) *DeleteHandler {
+func NewDeleteHandler(pool *worker.Pool, runner *asyncRunner) *DeleteHandler {
 	return &DeleteHandler{
 		pool:   pool,
+		runner: runner,
 		logger: zap.L().Named("delete"),
 	}
 }
@@ -26,16 +28,12 @@ func NewDeleteHandler(pool *worker.Pool) *DeleteHandler {
 func (h *DeleteHandler) Handle(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request) {
 	path := middleware.GetValidatedPath(ctx)
 
-	task := func() {
-		if err := storageBackend.Delete(context.Background(), path); err != nil {
-			h.logger.Error("Delete failed", zap.String("path", path), zap.Error(err))
-		}
-	}
-
-	if err := h.pool.Submit(task); err != nil {
-		utils.WriteError(w, "Delete failed to submit", http.StatusTooManyRequests, err)
+	op := operation{op: jobs.OpDelete, path: path, tenant: middleware.GetTenant(ctx)}
+	job, err := h.runner.submit(ctx, storageBackend, h.pool, op)
+	if err != nil {
+		writeSubmitError(w, "Delete failed to submit", h.pool, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	h.runner.respond(w, r, job, http.StatusNoContent)
 }
@@ -8,40 +8,79 @@ import (
 	"mime"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/veloxpack/storage/pkg/backend/server/cache"
 	"github.com/veloxpack/storage/pkg/backend/server/middleware"
 	"github.com/veloxpack/storage/pkg/backend/server/utils"
 	"github.com/veloxpack/storage/pkg/storage/provider"
 	"go.uber.org/zap"
 )
 
+var errNotDirectory = errors.New("not a directory")
+
 type DownloadHandler struct {
 	logger    *zap.Logger
 	streaming *StreamingHandler
+	authorize middleware.Authorizer
+	// reloadTimeout bounds blocking playlist reloads
+	reloadTimeout time.Duration
+	// caching sets the caching headers of downloads
+	caching *cache.Policy
 }
 
-func NewDownloadHandler(streaming *StreamingHandler) *DownloadHandler {
+func NewDownloadHandler(streaming *StreamingHandler, authorize middleware.Authorizer) *DownloadHandler {
 	return &DownloadHandler{
-		logger:    zap.L().Named("download"),
-		streaming: streaming,
+		logger:        zap.L().Named("download"),
+		streaming:     streaming,
+		authorize:     authorize,
+		reloadTimeout: DefaultBlockingReloadTimeout,
+		caching:       cache.Default(),
 	}
 }
 
 func (h *DownloadHandler) Handle(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request) {
 	path := middleware.GetValidatedPath(ctx)
 
-	if au, exists := h.streaming.GetActiveUpload(path); exists {
-		h.serveActiveUpload(w, r, au)
+	// Low-Latency requests wait for the playlist rather than following it
+	if isPlaylist(path) && hasHLSQuery(r.URL.Query()) {
+		h.servePlaylist(ctx, storageBackend, w, r, path)
 		return
 	}
 
-	if filepath.Ext(path) != "" {
-		h.serveFile(ctx, storageBackend, w, path)
+	// A trailing slash or ?list asks for a directory listing
+	listing := strings.HasSuffix(r.URL.Path, "/") || r.URL.Query().Has("list")
+
+	// Uploads released since they were looked up are read from storage
+	if au, exists := h.streaming.GetActiveUpload(path); !listing && exists && au.acquire() {
+		h.serveActiveUpload(w, r, path, au)
+		return
+	}
+
+	if r.URL.Query().Has("archive") {
+		h.serveArchive(ctx, storageBackend, w, r, path)
 		return
 	}
 
-	h.listFiles(ctx, storageBackend, w, path)
+	st, err := storageBackend.Stat(ctx, path)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, provider.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		utils.WriteError(w, "Stat failed", status, err)
+		return
+	}
+
+	switch {
+	case st.IsDir:
+		h.listFiles(ctx, storageBackend, w, path)
+	case listing:
+		utils.WriteError(w, "List files failed", http.StatusNotFound, errNotDirectory)
+	default:
+		h.serveFile(ctx, storageBackend, w, path)
+	}
 }
 
 func (h *DownloadHandler) serveFile(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, path string) {
@@ -61,6 +100,7 @@ func (h *DownloadHandler) serveFile(ctx context.Context, storageBackend provider
 	}
 	defer reader.Close()
 
+	h.setCacheHeaders(w, path, contentType)
 	if _, err := io.Copy(w, reader); err != nil {
 		h.logger.Error("Failed to stream file", zap.Error(err))
 	}
@@ -83,9 +123,20 @@ func (h *DownloadHandler) listFiles(ctx context.Context, storageBackend provider
 	}
 }
 
-func (h *DownloadHandler) serveActiveUpload(w http.ResponseWriter, r *http.Request, au *ActiveUpload) {
+// setCacheHeaders sets the caching headers of a stored object.
+func (h *DownloadHandler) setCacheHeaders(w http.ResponseWriter, path, contentType string) {
+	h.caching.Apply(w.Header(), path, contentType, h.streaming.cacheControl.get(path))
+}
+
+// serveActiveUpload follows an upload the caller acquired, and releases it.
+func (h *DownloadHandler) serveActiveUpload(w http.ResponseWriter, r *http.Request, path string, au *ActiveUpload) {
+	defer au.release()
+	defer h.streaming.trackReader()()
+
+	contentType := utils.DetermineContentType(au.header.Get("Content-Type"), path)
 	w.Header().Set("Transfer-Encoding", "chunked")
-	w.Header().Set("Content-Type", utils.DetermineContentType(au.header.Get("Content-Type"), r.URL.Path))
+	w.Header().Set("Content-Type", contentType)
+	h.caching.Apply(w.Header(), path, contentType, au.header.Get("Cache-Control"))
 	w.WriteHeader(http.StatusOK)
 
 	flusher, ok := w.(http.Flusher)
@@ -94,32 +145,37 @@ func (h *DownloadHandler) serveActiveUpload(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var offset int
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	rc := http.NewResponseController(w)
+	defer rc.SetWriteDeadline(time.Time{})
 
+	buf := make([]byte, 32*1024)
+	var offset int64
 	for {
-		select {
-		case <-ticker.C:
-			au.mu.RLock()
-			if offset >= len(au.buffer) && au.eof {
-				au.mu.RUnlock()
+		n, wait, done, err := au.read(buf, offset)
+		switch {
+		case n > 0:
+		case err != nil:
+			// Ending the response normally would pass off the partial
+			// upload as complete
+			panic(http.ErrAbortHandler)
+		case done:
+			return
+		default:
+			select {
+			case <-wait:
+				continue
+			case <-r.Context().Done():
 				return
 			}
+		}
 
-			if offset < len(au.buffer) {
-				n, err := w.Write(au.buffer[offset:])
-				if err != nil {
-					au.mu.RUnlock()
-					return
-				}
-				offset += n
-				flusher.Flush()
-			}
-			au.mu.RUnlock()
-
-		case <-r.Context().Done():
+		// A reader that cannot keep up is dropped rather than buffered for
+		rc.SetWriteDeadline(time.Now().Add(h.streaming.writeTimeout))
+		if _, err := w.Write(buf[:n]); err != nil {
+			h.logger.Debug("Live reader disconnected", zap.String("path", r.URL.Path), zap.Error(err))
 			return
 		}
+		offset += int64(n)
+		flusher.Flush()
 	}
 }
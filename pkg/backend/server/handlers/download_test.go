@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+func TestDownloadResolution(t *testing.T) {
+	ctx := context.Background()
+	pool, err := worker.NewPool(4)
+	require.NoError(t, err)
+	t.Cleanup(pool.Release)
+	backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+	h := NewStorageHandler(backend, pool, pool)
+	t.Cleanup(h.Shutdown)
+
+	require.NoError(t, backend.Save(ctx, strings.NewReader("init"), "vod/film/init"))
+	require.NoError(t, backend.Save(ctx, strings.NewReader("segment"), "vod/film/v1.0/seg1.m4s"))
+
+	get := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/"+target, nil)
+		path, _, _ := strings.Cut(strings.TrimSuffix(target, "/"), "?")
+		r = r.WithContext(context.WithValue(r.Context(), middleware.ValidatedPathContextKey, strings.TrimSuffix(path, "/")))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	names := func(t *testing.T, w *httptest.ResponseRecorder) []string {
+		var stats []*provider.Stat
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		var names []string
+		for _, st := range stats {
+			names = append(names, st.Name)
+		}
+		return names
+	}
+
+	t.Run("should serve files without an extension", func(t *testing.T) {
+		w := get("vod/film/init")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "init", w.Body.String())
+	})
+
+	t.Run("should list directories with a dot in their name", func(t *testing.T) {
+		w := get("vod/film/v1.0")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"seg1.m4s"}, names(t, w))
+	})
+
+	t.Run("should list only directories when asked to", func(t *testing.T) {
+		w := get("vod/film/")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.ElementsMatch(t, []string{"init", "v1.0"}, names(t, w))
+
+		assert.Equal(t, http.StatusOK, get("vod/film?list").Code)
+		assert.Equal(t, http.StatusNotFound, get("vod/film/init/").Code)
+		assert.Equal(t, http.StatusNotFound, get("vod/film/init?list").Code)
+		assert.Equal(t, http.StatusNotFound, get("vod/missing").Code)
+	})
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultExtractEntries bounds the number of files extracted from one
+	// archive.
+	DefaultExtractEntries = 10000
+	// DefaultExtractSize bounds the total extracted size of one archive.
+	DefaultExtractSize = 1 << 30
+)
+
+var (
+	errTooManyEntries    = errors.New("too many archive entries")
+	errArchiveTooLarge   = errors.New("archive exceeds the extracted size limit")
+	errInvalidEntryName  = errors.New("invalid entry name")
+	errUnsupportedEntry  = errors.New("unsupported entry type")
+	errEntrySizeMismatch = errors.New("entry size does not match its header")
+	errRolledBack        = errors.New("rolled back")
+	errNotExtracted      = errors.New("not written, the archive was rejected")
+)
+
+// extractLimits bounds what is extracted from one archive.
+type extractLimits struct {
+	entries int
+	size    int64
+}
+
+type extractResponse struct {
+	Written int         `json:"written"`
+	Files   []*formFile `json:"files"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// extractEntry is a file or directory of an uploaded archive.
+type extractEntry struct {
+	name string
+	// size is the size declared in the archive, which its reader enforces
+	size int64
+	mode fs.FileMode
+	open func() (io.ReadCloser, error)
+}
+
+// archiveEntries iterates over the entries of an uploaded archive.
+type archiveEntries interface {
+	// next returns the next entry, or io.EOF after the last one.
+	next() (*extractEntry, error)
+}
+
+// openArchive reads an archive of the given format from body. Zip archives
+// need random access and are spooled to a temporary file of at most limit
+// bytes first; tar archives are read as they arrive.
+func openArchive(format string, body io.Reader, limit int64) (archiveEntries, func(), error) {
+	switch format {
+	case ArchiveTar:
+		return &tarEntries{r: tar.NewReader(body)}, func() {}, nil
+	case ArchiveTarGz, "tgz":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tarEntries{r: tar.NewReader(gz)}, func() {}, nil
+	case ArchiveZip:
+		return spoolZip(body, limit)
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", errArchiveFormat, format)
+	}
+}
+
+type tarEntries struct {
+	r *tar.Reader
+}
+
+func (t *tarEntries) next() (*extractEntry, error) {
+	hdr, err := t.r.Next()
+	// Global PAX headers, as written by git archive, hold no file
+	for err == nil && hdr.Typeflag == tar.TypeXGlobalHeader {
+		hdr, err = t.r.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &extractEntry{
+		name: hdr.Name,
+		size: hdr.Size,
+		mode: hdr.FileInfo().Mode(),
+		open: func() (io.ReadCloser, error) { return io.NopCloser(t.r), nil },
+	}, nil
+}
+
+type zipEntries struct {
+	files []*zip.File
+}
+
+func spoolZip(body io.Reader, limit int64) (archiveEntries, func(), error) {
+	f, err := os.CreateTemp("", "storage-extract-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	n, err := io.Copy(f, io.LimitReader(body, limit+1))
+	if err == nil && n > limit {
+		err = errArchiveTooLarge
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	zr, err := zip.NewReader(f, n)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return &zipEntries{files: zr.File}, cleanup, nil
+}
+
+func (z *zipEntries) next() (*extractEntry, error) {
+	if len(z.files) == 0 {
+		return nil, io.EOF
+	}
+	f := z.files[0]
+	z.files = z.files[1:]
+
+	size := int64(f.UncompressedSize64)
+	if size < 0 {
+		return nil, errArchiveTooLarge
+	}
+	return &extractEntry{name: f.Name, size: size, mode: f.Mode(), open: f.Open}, nil
+}
+
+// extractName returns the cleaned name of an entry, or false for names
+// that are absolute or step outside the archive.
+func extractName(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "./")
+	if strings.Contains(name, `\`) || !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+	return name, true
+}
+
+// handleExtract saves every file of a tar, gzipped tar or zip archive below
+// dir, streaming each to storage as it is read.
+//
+// Every entry is checked before anything is read from it: its name must stay
+// below dir, it must be a regular file the caller may PUT and fit the part
+// size limit, and the archive must stay within the entry count and total
+// size limits. Sizes are taken from the archive headers, which the readers
+// enforce, so a small archive cannot expand beyond them.
+//
+// With atomic=true the whole archive is staged in a temporary directory and
+// checked before anything is written. Manifests are written once every other
+// file has been, and if any write fails the objects replaced are restored
+// and the new ones deleted, so players never see a manifest referring to
+// missing segments.
+func (h *UploadHandler) handleExtract(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request, dir string) {
+	query := r.URL.Query()
+	atomic, _ := strconv.ParseBool(query.Get("atomic"))
+
+	entries, closeArchive, err := openArchive(query.Get("extract"), r.Body, h.extract.size)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errArchiveTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		utils.WriteError(w, "Invalid archive", status, err)
+		return
+	}
+	defer closeArchive()
+
+	var staging string
+	if atomic {
+		if staging, err = os.MkdirTemp("", "storage-extract-*"); err != nil {
+			utils.WriteError(w, "Failed to stage archive", http.StatusInternalServerError, err)
+			return
+		}
+		defer os.RemoveAll(staging)
+	}
+
+	resp := extractResponse{Files: []*formFile{}}
+	errStatus := http.StatusBadRequest
+	remaining := h.extract.size
+	for count := 0; ; {
+		e, err := entries.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && !e.mode.IsDir() {
+			if count++; count > h.extract.entries {
+				err = errTooManyEntries
+			} else if e.size > remaining {
+				err = errArchiveTooLarge
+			}
+		}
+		if err != nil {
+			if errors.Is(err, errTooManyEntries) || errors.Is(err, errArchiveTooLarge) {
+				errStatus = http.StatusRequestEntityTooLarge
+			}
+			resp.Error = err.Error()
+			break
+		}
+		if e.mode.IsDir() {
+			continue
+		}
+		remaining -= e.size
+
+		f := h.extractFile(ctx, storageBackend, r, dir, e, staging, count)
+		resp.Files = append(resp.Files, f)
+		if atomic && f.Status >= http.StatusMultipleChoices {
+			break
+		}
+	}
+
+	if len(resp.Files) == 0 && resp.Error == "" {
+		resp.Error = "no files in archive"
+	}
+	if atomic {
+		h.writeJSON(w, h.commitExtract(ctx, storageBackend, &resp, staging, errStatus), resp)
+		return
+	}
+	if len(resp.Files) == 0 {
+		h.writeJSON(w, errStatus, resp)
+		return
+	}
+
+	status := http.StatusCreated
+	for _, f := range resp.Files {
+		if f.Status == http.StatusCreated {
+			resp.Written++
+		} else if f.Status >= http.StatusMultipleChoices {
+			status = http.StatusMultiStatus
+		}
+	}
+	if resp.Error != "" {
+		status = http.StatusMultiStatus
+	}
+	h.writeJSON(w, status, resp)
+}
+
+// extractFile checks an entry and reads it. The entry is submitted right
+// away, or staged as the n-th file of the archive when staging is set.
+func (h *UploadHandler) extractFile(ctx context.Context, storageBackend provider.Storage, r *http.Request, dir string, e *extractEntry, staging string, n int) *formFile {
+	f := &formFile{Filename: e.name, Size: e.size}
+
+	name, ok := extractName(e.name)
+	dest, err := utils.SanitizePath(path.Join(dir, name))
+	if !ok || err != nil || !strings.HasPrefix(dest, dir+"/") {
+		f.fail(http.StatusBadRequest, errInvalidEntryName)
+		return f
+	}
+	f.Path = dest
+
+	switch {
+	case !e.mode.IsRegular():
+		f.fail(http.StatusBadRequest, errUnsupportedEntry)
+		return f
+	case !h.authorize(r, http.MethodPut, dest):
+		f.fail(http.StatusForbidden, errAccessDenied)
+		return f
+	case e.size > h.maxPartSize:
+		f.fail(http.StatusRequestEntityTooLarge, errPartTooLarge)
+		return f
+	}
+
+	rc, err := e.open()
+	if err != nil {
+		f.fail(http.StatusBadRequest, err)
+		return f
+	}
+	defer rc.Close()
+
+	if staging != "" {
+		err = stageFile(filepath.Join(staging, strconv.Itoa(n)), &exactReader{r: rc, n: e.size})
+		if err != nil {
+			f.fail(http.StatusBadRequest, err)
+			return f
+		}
+		f.Status = http.StatusOK
+		return f
+	}
+
+	h.saveFile(ctx, storageBackend, f, &exactReader{r: rc, n: e.size})
+	return f
+}
+
+// exactReader reads exactly n bytes, failing with errEntrySizeMismatch if
+// the underlying reader holds fewer or more.
+type exactReader struct {
+	r io.Reader
+	n int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > e.n+1 {
+		p = p[:e.n+1]
+	}
+	n, err := e.r.Read(p)
+	e.n -= int64(n)
+	switch {
+	case e.n < 0:
+		return n - 1, errEntrySizeMismatch
+	case err == io.EOF && e.n > 0:
+		return n, errEntrySizeMismatch
+	}
+	return n, err
+}
+
+func stageFile(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return errors.Join(err, f.Close())
+}
+
+// extractCommit is a staged file of an atomic extraction being written.
+type extractCommit struct {
+	file   *formFile
+	staged string
+	// backup holds the object the file replaces if replaced is set
+	backup   string
+	replaced bool
+}
+
+// commitExtract writes the staged files of an atomic extraction, manifests
+// last, and returns the response status. If the archive could not be staged
+// completely nothing is written and errStatus or the status of the failed
+// file is returned. If a write fails the extraction is rolled back.
+func (h *UploadHandler) commitExtract(ctx context.Context, storageBackend provider.Storage, resp *extractResponse, staging string, errStatus int) int {
+	status := 0
+	if resp.Error != "" {
+		status = errStatus
+	}
+	for _, f := range resp.Files {
+		if status == 0 && f.Status >= http.StatusMultipleChoices {
+			status = f.Status
+		}
+	}
+	if status != 0 {
+		for _, f := range resp.Files {
+			if f.Status == http.StatusOK {
+				f.fail(http.StatusFailedDependency, errNotExtracted)
+			}
+		}
+		return status
+	}
+
+	// The upload is completed or undone even if the client goes away
+	ctx = context.WithoutCancel(ctx)
+
+	var media, manifests []int
+	for i, f := range resp.Files {
+		if isManifest(f.Path) {
+			manifests = append(manifests, i)
+		} else {
+			media = append(media, i)
+		}
+	}
+
+	commits := make([]*extractCommit, 0, len(resp.Files))
+	for _, i := range append(media, manifests...) {
+		// Staged files are numbered from 1 in archive order
+		name := filepath.Join(staging, strconv.Itoa(i+1))
+		c := &extractCommit{file: resp.Files[i], staged: name, backup: name + ".prev"}
+		if err := h.keepPrevious(ctx, storageBackend, c); err != nil {
+			c.file.fail(http.StatusInternalServerError, err)
+		} else {
+			commits = append(commits, c)
+			h.saveStaged(ctx, storageBackend, c.file, c.staged)
+		}
+
+		if c.file.Status != http.StatusCreated {
+			resp.Error = fmt.Sprintf("%s: %s", c.file.Filename, c.file.Error)
+			h.rollback(ctx, storageBackend, commits)
+			return http.StatusInternalServerError
+		}
+	}
+
+	resp.Written = len(resp.Files)
+	return http.StatusCreated
+}
+
+// keepPrevious copies the object a commit replaces to its backup, in order
+// with other writes of the path.
+func (h *UploadHandler) keepPrevious(ctx context.Context, storageBackend provider.Storage, c *extractCommit) error {
+	var err error
+	h.streaming.ordering.Run(c.file.Path, func() {
+		var rc io.ReadCloser
+		rc, err = storageBackend.Open(ctx, c.file.Path)
+		if errors.Is(err, provider.ErrNotExist) {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		defer rc.Close()
+		err = stageFile(c.backup, rc)
+		c.replaced = err == nil
+	})
+	return err
+}
+
+// saveStaged streams a staged file to f.Path.
+func (h *UploadHandler) saveStaged(ctx context.Context, storageBackend provider.Storage, f *formFile, name string) {
+	staged, err := os.Open(name)
+	if err != nil {
+		f.fail(http.StatusInternalServerError, err)
+		return
+	}
+	defer staged.Close()
+	h.saveFile(ctx, storageBackend, f, staged)
+}
+
+// rollback undoes the commits of an atomic extraction, latest first: the
+// objects replaced are restored from their backups and the others deleted.
+func (h *UploadHandler) rollback(ctx context.Context, storageBackend provider.Storage, commits []*extractCommit) {
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+		var err error
+		if c.replaced {
+			err = h.restore(ctx, storageBackend, c)
+		} else if err = h.streaming.remove(ctx, storageBackend, c.file.Path); errors.Is(err, provider.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			h.logger.Error("Failed to roll back extracted file", zap.String("path", c.file.Path), zap.Error(err))
+		}
+		if c.file.Status == http.StatusCreated {
+			c.file.fail(http.StatusFailedDependency, errRolledBack)
+		}
+	}
+}
+
+func (h *UploadHandler) restore(ctx context.Context, storageBackend provider.Storage, c *extractCommit) error {
+	backup, err := os.Open(c.backup)
+	if err != nil {
+		return err
+	}
+	defer backup.Close()
+	return h.streaming.save(ctx, storageBackend, c.file.Path, backup)
+}
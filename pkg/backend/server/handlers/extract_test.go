@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+func TestExtract(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T, backend provider.Storage, opts ...Option) *StorageHandler {
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		opts = append(opts, WithRetryPolicy(retry.Policy{MaxAttempts: 1}))
+		return NewStorageHandler(backend, pool, pool, opts...)
+	}
+
+	makeTar := func(gzipped bool, headers ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		var w io.Writer = &buf
+		gz := gzip.NewWriter(&buf)
+		if gzipped {
+			w = gz
+		}
+		tw := tar.NewWriter(w)
+		for _, hdr := range headers {
+			content := hdr.Name
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = int64(len(content))
+			}
+			if hdr.Mode == 0 {
+				hdr.Mode = 0644
+			}
+			tw.WriteHeader(hdr)
+			if hdr.Typeflag == tar.TypeReg {
+				io.WriteString(tw, content)
+			}
+		}
+		tw.Close()
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	extract := func(h http.Handler, dir, query string, body []byte) (*httptest.ResponseRecorder, extractResponse) {
+		req := httptest.NewRequest(http.MethodPut, "/"+dir+"/?"+query, bytes.NewReader(body))
+		req.Header.Set("Prefer", "wait")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ValidatedPathContextKey, dir))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var resp extractResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	read := func(t *testing.T, backend provider.Storage, path string) string {
+		r, err := backend.Open(ctx, path)
+		require.NoError(t, err)
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	t.Run("should save every file of a tarball below the prefix", func(t *testing.T) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		h := newHandler(t, backend, WithAuthorizer(func(r *http.Request, method, path string) bool {
+			return !strings.Contains(path, "private")
+		}))
+
+		rec, resp := extract(h, "vod/abc", "extract=tar.gz", makeTar(true,
+			&tar.Header{Name: "./", Typeflag: tar.TypeDir},
+			&tar.Header{Name: "./index.m3u8", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "720p/", Typeflag: tar.TypeDir},
+			&tar.Header{Name: "720p/seg1.ts", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "../escape.ts", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "720p/link.ts", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			&tar.Header{Name: "private/key", Typeflag: tar.TypeReg},
+		))
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+		assert.Equal(t, 2, resp.Written)
+		require.Len(t, resp.Files, 5)
+		assert.Equal(t, http.StatusCreated, resp.Files[0].Status)
+		assert.Equal(t, "vod/abc/index.m3u8", resp.Files[0].Path)
+		assert.Equal(t, http.StatusCreated, resp.Files[1].Status)
+		assert.Equal(t, http.StatusBadRequest, resp.Files[2].Status)
+		assert.Equal(t, http.StatusBadRequest, resp.Files[3].Status)
+		assert.Equal(t, http.StatusForbidden, resp.Files[4].Status)
+
+		assert.Equal(t, "./index.m3u8", read(t, backend, "vod/abc/index.m3u8"))
+		assert.Equal(t, "720p/seg1.ts", read(t, backend, "vod/abc/720p/seg1.ts"))
+		_, err := backend.Stat(ctx, "vod/escape.ts")
+		assert.Error(t, err)
+	})
+
+	t.Run("should enforce the entry and size limits", func(t *testing.T) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		h := newHandler(t, backend, WithExtractLimits(2, 1<<20))
+
+		rec, resp := extract(h, "vod/abc", "extract=tar", makeTar(false,
+			&tar.Header{Name: "a.ts", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "b.ts", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "c.ts", Typeflag: tar.TypeReg},
+		))
+		assert.Equal(t, http.StatusMultiStatus, rec.Code)
+		assert.Equal(t, 2, resp.Written)
+		assert.Equal(t, errTooManyEntries.Error(), resp.Error)
+
+		// A megabyte of zeros compresses to about a kilobyte
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		fw, _ := zw.Create("bomb.ts")
+		fw.Write(make([]byte, 2<<20))
+		zw.Close()
+		require.Less(t, buf.Len(), 16<<10)
+
+		rec, resp = extract(h, "vod/abc", "extract=zip", buf.Bytes())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, errArchiveTooLarge.Error(), resp.Error)
+		_, err := backend.Stat(ctx, "vod/abc/bomb.ts")
+		assert.Error(t, err)
+
+		rec, _ = extract(h, "vod/abc", "extract=rar", nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("should write all files of an atomic extraction or none", func(t *testing.T) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		h := newHandler(t, backend)
+
+		rec, resp := extract(h, "vod/abc", "extract=tar&atomic=true", makeTar(false,
+			&tar.Header{Name: "index.m3u8", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "seg1.ts", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg},
+		))
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Equal(t, 0, resp.Written)
+		assert.Equal(t, http.StatusFailedDependency, resp.Files[0].Status)
+		_, err := backend.Stat(ctx, "vod/abc/index.m3u8")
+		assert.Error(t, err)
+
+		rec, resp = extract(h, "vod/abc", "extract=tar&atomic=true", makeTar(false,
+			&tar.Header{Name: "index.m3u8", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "seg1.ts", Typeflag: tar.TypeReg},
+		))
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Equal(t, 2, resp.Written)
+		assert.Equal(t, "seg1.ts", read(t, backend, "vod/abc/seg1.ts"))
+	})
+
+	t.Run("should roll back an atomic extraction that fails to write", func(t *testing.T) {
+		backend := &failingStorage{Storage: fs.NewStorage(fs.Config{Root: t.TempDir()}), path: "vod/abc/index.m3u8"}
+		h := newHandler(t, backend)
+		require.NoError(t, backend.Save(ctx, strings.NewReader("previous"), "vod/abc/seg1.ts"))
+
+		rec, resp := extract(h, "vod/abc", "extract=tar&atomic=1", makeTar(false,
+			&tar.Header{Name: "index.m3u8", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "seg1.ts", Typeflag: tar.TypeReg},
+			&tar.Header{Name: "seg2.ts", Typeflag: tar.TypeReg},
+		))
+		require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+		assert.Equal(t, 0, resp.Written)
+		assert.Equal(t, http.StatusInternalServerError, resp.Files[0].Status)
+		assert.Equal(t, http.StatusFailedDependency, resp.Files[1].Status)
+		assert.Equal(t, "previous", read(t, backend, "vod/abc/seg1.ts"))
+		_, err := backend.Stat(ctx, "vod/abc/seg2.ts")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+	})
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxFormParts bounds the number of parts read from one form.
+	maxFormParts = 1000
+	// maxFormKeySize bounds the value of a "key" field.
+	maxFormKeySize = 1024
+
+	// formKeyField names the field setting the key of the next file part.
+	formKeyField = "key"
+)
+
+var (
+	errPartTooLarge   = errors.New("part exceeds the size limit")
+	errFormTooLarge   = errors.New("form exceeds the size limit")
+	errTooManyParts   = errors.New("too many parts")
+	errInvalidFileKey = errors.New("invalid file key")
+	errAccessDenied   = errors.New("access denied")
+)
+
+// formFile is the outcome of one file of a multipart/form-data upload or an
+// extracted archive.
+type formFile struct {
+	Field    string `json:"field,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Size     int64  `json:"size"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+type formResponse struct {
+	Files []*formFile `json:"files"`
+	Error string      `json:"error,omitempty"`
+}
+
+// isForm reports whether r carries a multipart/form-data body.
+func isForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// handleForm saves every file of a multipart/form-data body below dir. Files
+// are named after their filename
+// unless a preceding "key" field names them; "${filename}" in a key is
+// replaced by the filename. Parts are streamed to storage one after the
+// other as they are read, and the whole form is limited to the upload size
+// limit.
+func (h *UploadHandler) handleForm(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request, dir string) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.WriteError(w, "Invalid multipart form", http.StatusBadRequest, err)
+		return
+	}
+
+	resp := formResponse{Files: []*formFile{}}
+	var key string
+	for parts := 0; ; parts++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && parts == maxFormParts {
+			err = errTooManyParts
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = errFormTooLarge
+		}
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+
+		if part.FileName() == "" {
+			if part.FormName() == formKeyField {
+				value, _ := io.ReadAll(io.LimitReader(part, maxFormKeySize))
+				key = string(value)
+			}
+			part.Close()
+			continue
+		}
+
+		f := h.submitPart(ctx, storageBackend, r, dir, key, part)
+		resp.Files = append(resp.Files, f)
+		key = ""
+		part.Close()
+	}
+
+	if len(resp.Files) == 0 {
+		if resp.Error == "" {
+			resp.Error = "no files in form"
+		}
+		h.writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	status := http.StatusCreated
+	for _, f := range resp.Files {
+		if f.Status >= http.StatusMultipleChoices {
+			status = http.StatusMultiStatus
+		}
+	}
+	if resp.Error != "" {
+		status = http.StatusMultiStatus
+	}
+	h.writeJSON(w, status, resp)
+}
+
+// submitPart streams a file part to storage.
+func (h *UploadHandler) submitPart(ctx context.Context, storageBackend provider.Storage, r *http.Request, dir, key string, part *multipart.Part) *formFile {
+	f := &formFile{Field: part.FormName(), Filename: part.FileName()}
+
+	if key == "" {
+		key = part.FileName()
+	}
+	key = strings.ReplaceAll(key, "${filename}", part.FileName())
+	dest, err := utils.SanitizePath(path.Join(dir, key))
+	if err != nil || !strings.HasPrefix(dest, dir+"/") {
+		f.fail(http.StatusBadRequest, errInvalidFileKey)
+		return f
+	}
+	f.Path = dest
+
+	if !h.authorize(r, http.MethodPut, dest) {
+		f.fail(http.StatusForbidden, errAccessDenied)
+		return f
+	}
+
+	h.saveFile(ctx, storageBackend, f, http.MaxBytesReader(nil, part, h.maxPartSize))
+	return f
+}
+
+// saveFile streams content to f.Path in order with other writes of the
+// path. Failures to read content are blamed on the request.
+func (h *UploadHandler) saveFile(ctx context.Context, storageBackend provider.Storage, f *formFile, content io.Reader) {
+	body := &requestReader{r: content}
+	err := h.streaming.save(ctx, storageBackend, f.Path, body)
+	f.Size = body.n
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		f.Status = http.StatusCreated
+	case errors.As(body.err, &tooLarge):
+		f.fail(http.StatusRequestEntityTooLarge, errPartTooLarge)
+	case body.err != nil:
+		f.fail(http.StatusBadRequest, body.err)
+	default:
+		f.fail(http.StatusInternalServerError, err)
+	}
+}
+
+// requestReader counts the bytes read from a request body and records the
+// error reading it failed with.
+type requestReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (r *requestReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (f *formFile) fail(status int, err error) {
+	f.Status = status
+	f.Error = err.Error()
+}
+
+func (h *UploadHandler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestFormUpload(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T, opts ...Option) (*StorageHandler, *fs.Storage) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		return NewStorageHandler(backend, pool, pool, opts...), backend
+	}
+
+	upload := func(h http.Handler, dir string, fields [][3]string) (*httptest.ResponseRecorder, formResponse) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, f := range fields {
+			if f[1] == "" {
+				mw.WriteField(f[0], f[2])
+				continue
+			}
+			fw, _ := mw.CreateFormFile(f[0], f[1])
+			io.WriteString(fw, f[2])
+		}
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/"+dir, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Prefer", "wait")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.ValidatedPathContextKey, dir))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var resp formResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	read := func(t *testing.T, backend *fs.Storage, path string) string {
+		r, err := backend.Open(ctx, path)
+		require.NoError(t, err)
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	t.Run("should save every file below the request path", func(t *testing.T) {
+		h, backend := newHandler(t)
+
+		rec, resp := upload(h, "acme/uploads", [][3]string{
+			{"file", "a.txt", "first"},
+			{"key", "", "renamed/${filename}.bak"},
+			{"file", "b.txt", "second"},
+			{"title", "", "ignored"},
+		})
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		require.Len(t, resp.Files, 2)
+		assert.Equal(t, "acme/uploads/a.txt", resp.Files[0].Path)
+		assert.Equal(t, "acme/uploads/renamed/b.txt.bak", resp.Files[1].Path)
+		assert.Equal(t, int64(6), resp.Files[1].Size)
+
+		assert.Equal(t, "first", read(t, backend, "acme/uploads/a.txt"))
+		assert.Equal(t, "second", read(t, backend, "acme/uploads/renamed/b.txt.bak"))
+	})
+
+	t.Run("should report files that could not be saved", func(t *testing.T) {
+		h, backend := newHandler(t,
+			WithMaxPartSize(8),
+			WithAuthorizer(func(r *http.Request, method, path string) bool {
+				return !strings.Contains(path, "private")
+			}),
+		)
+
+		rec, resp := upload(h, "acme/uploads", [][3]string{
+			{"file", "ok.txt", "fits"},
+			{"file", "big.txt", "far too large"},
+			{"key", "", "../escape.txt"},
+			{"file", "x.txt", "x"},
+			{"key", "", "private/x.txt"},
+			{"file", "x.txt", "x"},
+		})
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+		require.Len(t, resp.Files, 4)
+		assert.Equal(t, http.StatusCreated, resp.Files[0].Status)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Files[1].Status)
+		assert.Equal(t, http.StatusBadRequest, resp.Files[2].Status)
+		assert.Equal(t, http.StatusForbidden, resp.Files[3].Status)
+
+		assert.Equal(t, "fits", read(t, backend, "acme/uploads/ok.txt"))
+		_, err := backend.Stat(ctx, "acme/uploads/big.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("should stop reading forms over the upload size limit", func(t *testing.T) {
+		h, backend := newHandler(t)
+		h.upload.maxSize = 512
+
+		rec, resp := upload(h, "acme/uploads", [][3]string{
+			{"file", "a.txt", "first"},
+			{"file", "b.txt", strings.Repeat("x", 1024)},
+			{"file", "c.txt", "third"},
+		})
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+		require.Len(t, resp.Files, 2)
+		assert.Equal(t, http.StatusCreated, resp.Files[0].Status)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Files[1].Status)
+		assert.Equal(t, errFormTooLarge.Error(), resp.Error)
+
+		assert.Equal(t, "first", read(t, backend, "acme/uploads/a.txt"))
+		for _, p := range []string{"acme/uploads/b.txt", "acme/uploads/c.txt"} {
+			_, err := backend.Stat(ctx, p)
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("should reject forms without files", func(t *testing.T) {
+		h, _ := newHandler(t)
+
+		rec, resp := upload(h, "acme/uploads", [][3]string{{"title", "", "nothing"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "no files in form", resp.Error)
+	})
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLiveWriteTimeout is how long a live reader may take to accept
+	// each write before it is disconnected.
+	DefaultLiveWriteTimeout = 10 * time.Second
+	// DefaultLiveWindow is how much of each active upload is kept in memory.
+	DefaultLiveWindow = 4 << 20
+	// DefaultLiveMemory bounds the memory held by all active uploads.
+	DefaultLiveMemory = 256 << 20
+
+	// minLiveWindow is the smallest window allocated; windows double from
+	// there up to their size.
+	minLiveWindow = 64 << 10
+	// liveIdleSpill is how long an upload may receive nothing before its
+	// window is moved to disk.
+	liveIdleSpill = 30 * time.Second
+)
+
+var errUploadReleased = errors.New("upload released")
+
+// memoryBudget bounds the memory held by the windows of all active uploads.
+type memoryBudget struct {
+	mu    sync.Mutex
+	used  int64
+	limit int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// acquire reserves n bytes, or reports false when that would exceed the
+// budget.
+func (b *memoryBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+}
+
+// Used returns the number of bytes reserved.
+func (b *memoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// ActiveUpload is a chunked upload in progress, which readers follow as it
+// arrives.
+//
+// The most recent bytes are kept in a ring buffer of at most window bytes,
+// taken from a budget shared by all uploads. Bytes pushed out of the ring,
+// or every byte once the budget is exhausted, are spilled to a temporary
+// file, so readers joining late can still read from the start. Everything
+// is released once the writer and every reader let go of the upload.
+type ActiveUpload struct {
+	mu sync.Mutex
+	// ring holds the bytes from start to size, beginning at ring[head]
+	ring  []byte
+	head  int
+	start int64
+	size  int64
+	// spill holds the bytes before start
+	spill    *os.File
+	spillDir string
+	window   int64
+	budget   *memoryBudget
+	// refs counts the writer and the readers; at zero the upload is released
+	refs      int
+	lastWrite time.Time
+	eof       bool
+	err       error
+	// notify is closed and replaced whenever data arrives or the upload ends
+	notify    chan struct{}
+	header    http.Header
+	createdAt time.Time
+	maxAge    int64
+}
+
+func newActiveUpload(header http.Header, maxAge int64, window int64, budget *memoryBudget, spillDir string) *ActiveUpload {
+	return &ActiveUpload{
+		window:    window,
+		budget:    budget,
+		spillDir:  spillDir,
+		refs:      1,
+		notify:    make(chan struct{}),
+		header:    header,
+		createdAt: time.Now(),
+		lastWrite: time.Now(),
+		maxAge:    maxAge,
+	}
+}
+
+// write appends p and wakes up the readers waiting for it.
+func (au *ActiveUpload) write(p []byte) error {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+
+	au.lastWrite = time.Now()
+	for len(p) > 0 {
+		buffered := int(au.size - au.start)
+		if buffered == len(au.ring) && !au.grow(len(p)) {
+			if len(au.ring) == 0 {
+				// No memory to spare: straight to disk
+				if err := au.spillAt(p, au.size); err != nil {
+					return err
+				}
+				au.size += int64(len(p))
+				au.start = au.size
+				break
+			}
+			if err := au.evict(min(len(p), len(au.ring))); err != nil {
+				return err
+			}
+			buffered = int(au.size - au.start)
+		}
+
+		// Fill the free part of the ring, which may wrap around
+		free := len(au.ring) - buffered
+		pos := (au.head + buffered) % len(au.ring)
+		n := copy(au.ring[pos:], p[:min(len(p), free)])
+		n += copy(au.ring, p[n:min(len(p), free)])
+		au.size += int64(n)
+		p = p[n:]
+	}
+
+	au.wake()
+	return nil
+}
+
+// grow enlarges the ring for n more bytes, doubling it up to the window as
+// far as the budget allows.
+func (au *ActiveUpload) grow(n int) bool {
+	current := int64(len(au.ring))
+	if current >= au.window {
+		return false
+	}
+	next := max(current*2, minLiveWindow)
+	for next < current+int64(n) && next < au.window {
+		next *= 2
+	}
+	next = min(next, au.window)
+	if !au.budget.acquire(next - current) {
+		return false
+	}
+
+	ring := make([]byte, next)
+	buffered := int(au.size - au.start)
+	copied := copy(ring, au.ring[au.head:min(au.head+buffered, len(au.ring))])
+	copy(ring[copied:], au.ring[:buffered-copied])
+	au.ring, au.head = ring, 0
+	return true
+}
+
+// evict moves the n oldest bytes of the ring to the spill file.
+func (au *ActiveUpload) evict(n int) error {
+	first := min(n, len(au.ring)-au.head)
+	if err := au.spillAt(au.ring[au.head:au.head+first], au.start); err != nil {
+		return err
+	}
+	if err := au.spillAt(au.ring[:n-first], au.start+int64(first)); err != nil {
+		return err
+	}
+	au.start += int64(n)
+	au.head = (au.head + n) % len(au.ring)
+	return nil
+}
+
+func (au *ActiveUpload) spillAt(p []byte, off int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if au.spill == nil {
+		f, err := os.CreateTemp(au.spillDir, "storage-live-*")
+		if err != nil {
+			return err
+		}
+		au.spill = f
+	}
+	_, err := au.spill.WriteAt(p, off)
+	return err
+}
+
+// spillIdle moves the whole window to disk and returns its memory to the
+// budget if the upload ended or received nothing since before.
+func (au *ActiveUpload) spillIdle(before time.Time) error {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+
+	if len(au.ring) == 0 || (!au.eof && au.lastWrite.After(before)) {
+		return nil
+	}
+	if err := au.evict(int(au.size - au.start)); err != nil {
+		return err
+	}
+	au.budget.release(int64(len(au.ring)))
+	au.ring, au.head = nil, 0
+	return nil
+}
+
+// finish marks the upload complete, or failed if err is set, and wakes up
+// every reader.
+func (au *ActiveUpload) finish(err error) {
+	au.mu.Lock()
+	au.eof, au.err = true, err
+	au.wake()
+	au.mu.Unlock()
+}
+
+func (au *ActiveUpload) wake() {
+	close(au.notify)
+	au.notify = make(chan struct{})
+}
+
+// acquire registers a reader, or reports false once the upload was released.
+func (au *ActiveUpload) acquire() bool {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	if au.refs == 0 {
+		return false
+	}
+	au.refs++
+	return true
+}
+
+// release lets go of the upload, freeing its window and spill file after
+// the last user.
+func (au *ActiveUpload) release() {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+
+	if au.refs--; au.refs > 0 {
+		return
+	}
+	au.budget.release(int64(len(au.ring)))
+	au.ring = nil
+	if au.spill != nil {
+		au.spill.Close()
+		os.Remove(au.spill.Name())
+		au.spill = nil
+	}
+}
+
+// read copies the data received at offset into p. When there is none, done
+// reports whether the upload ended, with err set if it failed, and wait is
+// closed once there is more.
+func (au *ActiveUpload) read(p []byte, offset int64) (n int, wait <-chan struct{}, done bool, err error) {
+	au.mu.Lock()
+	if au.refs == 0 {
+		au.mu.Unlock()
+		return 0, nil, true, errUploadReleased
+	}
+	if offset >= au.size {
+		defer au.mu.Unlock()
+		return 0, au.notify, au.eof, au.err
+	}
+
+	if offset >= au.start {
+		defer au.mu.Unlock()
+		pos := (au.head + int(offset-au.start)) % len(au.ring)
+		p = p[:min(int64(len(p)), au.size-offset)]
+		n = copy(p, au.ring[pos:])
+		n += copy(p[n:], au.ring)
+		return n, nil, false, nil
+	}
+
+	// Spilled bytes never change, so they are read without the lock; the
+	// reader's reference keeps the file open
+	spill := au.spill
+	p = p[:min(int64(len(p)), au.start-offset)]
+	au.mu.Unlock()
+
+	n, err = spill.ReadAt(p, offset)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, nil, false, err
+}
+
+// reader returns the content of a finished upload.
+func (au *ActiveUpload) reader() io.Reader {
+	return &activeUploadReader{au: au}
+}
+
+type activeUploadReader struct {
+	au     *ActiveUpload
+	offset int64
+}
+
+func (r *activeUploadReader) Read(p []byte) (int, error) {
+	n, _, done, err := r.au.read(p, r.offset)
+	r.offset += int64(n)
+	switch {
+	case n > 0 || len(p) == 0:
+		return n, nil
+	case err != nil:
+		return 0, err
+	case done:
+		return 0, io.EOF
+	default:
+		return 0, io.ErrNoProgress
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveUpload(t *testing.T) {
+	payload := make([]byte, 300_000)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	// write feeds payload in uneven chunks so the ring wraps at odd places.
+	write := func(t *testing.T, au *ActiveUpload) {
+		rng := rand.New(rand.NewSource(2))
+		for p := payload; len(p) > 0; {
+			n := min(len(p), 1+rng.Intn(20_000))
+			require.NoError(t, au.write(p[:n]))
+			p = p[n:]
+		}
+		au.finish(nil)
+	}
+
+	spilled := func(t *testing.T, dir string) int {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	t.Run("should spill bytes pushed out of the window", func(t *testing.T) {
+		dir := t.TempDir()
+		budget := newMemoryBudget(1 << 20)
+		au := newActiveUpload(http.Header{}, -1, 100_000, budget, dir)
+
+		// A reader following from the start while the upload arrives
+		require.True(t, au.acquire())
+		received := make(chan []byte)
+		go func() {
+			defer au.release()
+			var got []byte
+			buf := make([]byte, 7_000)
+			for {
+				n, wait, done, err := au.read(buf, int64(len(got)))
+				got = append(got, buf[:n]...)
+				if n == 0 && (done || err != nil) {
+					received <- got
+					return
+				}
+				if n == 0 {
+					<-wait
+				}
+			}
+		}()
+
+		write(t, au)
+		assert.Equal(t, payload, <-received)
+		assert.Equal(t, int64(100_000), budget.Used())
+		assert.Equal(t, 1, spilled(t, dir))
+
+		content, err := io.ReadAll(au.reader())
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(payload, content))
+
+		au.release()
+		assert.Zero(t, budget.Used())
+		assert.Zero(t, spilled(t, dir))
+		assert.False(t, au.acquire())
+	})
+
+	t.Run("should write straight to disk once the budget is exhausted", func(t *testing.T) {
+		dir := t.TempDir()
+		budget := newMemoryBudget(minLiveWindow)
+		first := newActiveUpload(http.Header{}, -1, minLiveWindow, budget, dir)
+		defer first.release()
+		require.NoError(t, first.write(make([]byte, minLiveWindow)))
+
+		au := newActiveUpload(http.Header{}, -1, DefaultLiveWindow, budget, dir)
+		defer au.release()
+		write(t, au)
+		assert.Equal(t, int64(minLiveWindow), budget.Used())
+
+		content, err := io.ReadAll(au.reader())
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(payload, content))
+	})
+
+	t.Run("should return the memory of idle uploads", func(t *testing.T) {
+		budget := newMemoryBudget(1 << 20)
+		au := newActiveUpload(http.Header{}, -1, DefaultLiveWindow, budget, t.TempDir())
+		defer au.release()
+		require.NoError(t, au.write(payload[:1000]))
+
+		require.NoError(t, au.spillIdle(time.Now().Add(-time.Minute)))
+		assert.NotZero(t, budget.Used())
+		require.NoError(t, au.spillIdle(time.Now().Add(time.Second)))
+		assert.Zero(t, budget.Used())
+
+		require.NoError(t, au.write(payload[1000:]))
+		au.finish(nil)
+		content, err := io.ReadAll(au.reader())
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(payload, content))
+	})
+}
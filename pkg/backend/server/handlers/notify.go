@@ -0,0 +1,63 @@
+package handlers
+
+import "sync"
+
+// changeNotifier wakes up requests waiting for a path to be written, such
+// as blocking playlist reloads. Paths are only tracked while someone waits.
+type changeNotifier struct {
+	mu      sync.Mutex
+	waiting map[string]*waiters
+}
+
+// waiters is a channel closed when a path is written, and how many are
+// waiting on it.
+type waiters struct {
+	ch chan struct{}
+	n  int
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{waiting: make(map[string]*waiters)}
+}
+
+// wait returns a channel closed the next time path is written, and a
+// function to call once the caller stopped waiting. Callers get the channel
+// before reading path, so a write in between is not missed.
+func (n *changeNotifier) wait(path string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	w, ok := n.waiting[path]
+	if !ok {
+		w = &waiters{ch: make(chan struct{})}
+		n.waiting[path] = w
+	}
+	w.n++
+
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			// Entries that fired were removed already
+			if w.n--; w.n == 0 && n.waiting[path] == w {
+				delete(n.waiting, path)
+			}
+		})
+	}
+}
+
+// notify wakes up everyone waiting for path.
+func (n *changeNotifier) notify(path string) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if w, ok := n.waiting[path]; ok {
+		close(w.ch)
+		delete(n.waiting, path)
+	}
+}
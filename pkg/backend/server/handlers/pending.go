@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// pendingWrite is an acknowledged operation the backend has not completed yet.
+type pendingWrite struct {
+	jobID     string
+	op        jobs.Op
+	payload   []byte
+	createdAt time.Time
+}
+
+func (p *pendingWrite) stat(name string) *provider.Stat {
+	return &provider.Stat{
+		ModifiedTime: p.createdAt,
+		Size:         int64(len(p.payload)),
+		Name:         path.Base(name),
+		Path:         name,
+		ContentType:  mime.TypeByExtension(path.Ext(name)),
+	}
+}
+
+// pendingWrites tracks acknowledged uploads and deletes per path until their
+// task finishes, so reads observe them before the backend does.
+type pendingWrites struct {
+	mu     sync.RWMutex
+	byPath map[string][]*pendingWrite
+}
+
+func newPendingWrites() *pendingWrites {
+	return &pendingWrites{byPath: make(map[string][]*pendingWrite)}
+}
+
+// add records op, submitted as the job with jobID.
+func (p *pendingWrites) add(jobID string, op operation) {
+	w := &pendingWrite{jobID: jobID, op: op.op, payload: op.payload, createdAt: time.Now()}
+
+	p.mu.Lock()
+	p.byPath[op.path] = append(p.byPath[op.path], w)
+	p.mu.Unlock()
+}
+
+// remove forgets the operation of jobID once it finished or was rejected.
+func (p *pendingWrites) remove(name, jobID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	writes := p.byPath[name]
+	for i, w := range writes {
+		if w.jobID == jobID {
+			writes = append(writes[:i], writes[i+1:]...)
+			break
+		}
+	}
+	if len(writes) == 0 {
+		delete(p.byPath, name)
+		return
+	}
+	p.byPath[name] = writes
+}
+
+// latest returns the most recent pending operation on name. Operations on a
+// path run in submission order, so it is what the backend will end up with.
+func (p *pendingWrites) latest(name string) (*pendingWrite, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	writes := p.byPath[name]
+	if len(writes) == 0 {
+		return nil, false
+	}
+	return writes[len(writes)-1], true
+}
+
+// below returns the latest pending operation of every path below dir; with
+// recursive unset, only of those directly inside it.
+func (p *pendingWrites) below(dir string, recursive bool) map[string]*pendingWrite {
+	prefix := dirPrefix(dir)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	below := make(map[string]*pendingWrite)
+	for name, writes := range p.byPath {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok || (!recursive && strings.Contains(rest, "/")) || len(writes) == 0 {
+			continue
+		}
+		below[name] = writes[len(writes)-1]
+	}
+	return below
+}
+
+// subdirs returns the directories directly inside dir that have pending
+// uploads below them.
+func (p *pendingWrites) subdirs(dir string) map[string]bool {
+	prefix := dirPrefix(dir)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	subdirs := make(map[string]bool)
+	for name, writes := range p.byPath {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok || len(writes) == 0 || writes[len(writes)-1].op != jobs.OpUpload {
+			continue
+		}
+		if sub, _, nested := strings.Cut(rest, "/"); nested {
+			subdirs[prefix+sub] = true
+		}
+	}
+	return subdirs
+}
+
+// dirPrefix returns the prefix of the paths inside dir.
+func dirPrefix(dir string) string {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// uploadsUnder reports whether there are pending uploads below dir, which
+// makes it a directory before the backend knows it.
+func (p *pendingWrites) uploadsUnder(dir string) bool {
+	prefix := dirPrefix(dir)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for name, writes := range p.byPath {
+		if strings.HasPrefix(name, prefix) && len(writes) > 0 && writes[len(writes)-1].op == jobs.OpUpload {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingStorage overlays pending writes on a storage for reads: pending
+// uploads are served from memory and pending deletes hide the object.
+type pendingStorage struct {
+	provider.Storage
+	pending *pendingWrites
+}
+
+func (s *pendingStorage) Unwrap() provider.Storage {
+	return s.Storage
+}
+
+func (s *pendingStorage) Stat(ctx context.Context, name string) (*provider.Stat, error) {
+	if w, ok := s.pending.latest(name); ok {
+		if w.op == jobs.OpDelete {
+			return nil, provider.ErrNotExist
+		}
+		return w.stat(name), nil
+	}
+
+	st, err := s.Storage.Stat(ctx, name)
+	if errors.Is(err, provider.ErrNotExist) && s.pending.uploadsUnder(name) {
+		return &provider.Stat{Name: path.Base(name), Path: name, IsDir: true}, nil
+	}
+	return st, err
+}
+
+func (s *pendingStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if w, ok := s.pending.latest(name); ok {
+		if w.op == jobs.OpDelete {
+			return nil, provider.ErrNotExist
+		}
+		return io.NopCloser(bytes.NewReader(w.payload)), nil
+	}
+	return s.Storage.Open(ctx, name)
+}
+
+// List merges pending writes into the backend listing at the depth the
+// backend lists: every object below dir for recursive listings, otherwise
+// the direct children, with directories that only have pending uploads.
+func (s *pendingStorage) List(ctx context.Context, dir string) ([]*provider.Stat, error) {
+	lister, ok := provider.As[provider.RecursiveLister](s.Storage)
+	recursive := ok && lister.ListsRecursively()
+
+	stats, err := s.Storage.List(ctx, dir)
+	pending := s.pending.below(dir, recursive)
+	var subdirs map[string]bool
+	if !recursive {
+		subdirs = s.pending.subdirs(dir)
+	}
+	if err != nil && (!errors.Is(err, provider.ErrNotExist) || len(pending)+len(subdirs) == 0) {
+		return nil, err
+	}
+	if len(pending)+len(subdirs) == 0 {
+		return stats, nil
+	}
+
+	merged := make([]*provider.Stat, 0, len(stats)+len(pending)+len(subdirs))
+	for _, st := range stats {
+		name := st.Path
+		if name == "" {
+			name = path.Join(dir, st.Name)
+		}
+		if st.IsDir {
+			delete(subdirs, name)
+		}
+		if _, ok := pending[name]; !ok {
+			merged = append(merged, st)
+		}
+	}
+	for name, w := range pending {
+		if w.op == jobs.OpUpload {
+			merged = append(merged, w.stat(name))
+		}
+	}
+	for name := range subdirs {
+		merged = append(merged, &provider.Stat{Name: path.Base(name), Path: name, IsDir: true})
+	}
+	return merged, nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+func TestPendingStorage(t *testing.T) {
+	ctx := context.Background()
+
+	newStorage := func(t *testing.T) (*fs.Storage, *pendingWrites, *pendingStorage) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		pending := newPendingWrites()
+		return backend, pending, &pendingStorage{Storage: backend, pending: pending}
+	}
+
+	t.Run("should serve pending uploads before the backend has them", func(t *testing.T) {
+		_, pending, s := newStorage(t)
+		pending.add("job-1", operation{op: jobs.OpUpload, path: "live/seg1.ts", payload: []byte("segment")})
+
+		r, err := s.Open(ctx, "live/seg1.ts")
+		require.NoError(t, err)
+		data, _ := io.ReadAll(r)
+		assert.Equal(t, "segment", string(data))
+
+		st, err := s.Stat(ctx, "live/seg1.ts")
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), st.Size)
+
+		stats, err := s.List(ctx, "live")
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.Equal(t, "seg1.ts", stats[0].Name)
+
+		pending.remove("live/seg1.ts", "job-1")
+		_, err = s.Open(ctx, "live/seg1.ts")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+	})
+
+	t.Run("should report directories that only have pending uploads", func(t *testing.T) {
+		_, pending, s := newStorage(t)
+		pending.add("job-1", operation{op: jobs.OpUpload, path: "live/abc/seg1.ts", payload: []byte("segment")})
+
+		for _, dir := range []string{"live", "live/abc"} {
+			st, err := s.Stat(ctx, dir)
+			require.NoError(t, err)
+			assert.True(t, st.IsDir)
+		}
+
+		pending.add("job-2", operation{op: jobs.OpDelete, path: "live/abc/seg1.ts"})
+		_, err := s.Stat(ctx, "live/abc")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+	})
+
+	t.Run("should hide objects with a pending delete", func(t *testing.T) {
+		backend, pending, s := newStorage(t)
+		require.NoError(t, backend.Save(ctx, bytes.NewBufferString("old"), "vod/a.ts"))
+		require.NoError(t, backend.Save(ctx, bytes.NewBufferString("old"), "vod/b.ts"))
+
+		pending.add("job-1", operation{op: jobs.OpUpload, path: "vod/a.ts", payload: []byte("new")})
+		pending.add("job-2", operation{op: jobs.OpDelete, path: "vod/a.ts"})
+
+		_, err := s.Stat(ctx, "vod/a.ts")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+
+		stats, err := s.List(ctx, "vod")
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.Equal(t, "b.ts", stats[0].Name)
+	})
+
+	t.Run("should list pending uploads in new directories as directories", func(t *testing.T) {
+		backend, pending, s := newStorage(t)
+		require.NoError(t, backend.Save(ctx, bytes.NewBufferString("old"), "live/abc/720p/seg1.ts"))
+
+		pending.add("job-1", operation{op: jobs.OpUpload, path: "live/abc/720p/seg2.ts", payload: []byte("new")})
+		pending.add("job-2", operation{op: jobs.OpUpload, path: "live/abc/1080p/seg1.ts", payload: []byte("new")})
+
+		stats, err := s.List(ctx, "live/abc")
+		require.NoError(t, err)
+		names := make([]string, 0, len(stats))
+		for _, st := range stats {
+			assert.True(t, st.IsDir)
+			names = append(names, st.Name)
+		}
+		assert.ElementsMatch(t, []string{"720p", "1080p"}, names)
+
+		var objects []string
+		require.NoError(t, provider.Walk(ctx, s, "", func(name string, st *provider.Stat) error {
+			objects = append(objects, name)
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{"live/abc/720p/seg1.ts", "live/abc/720p/seg2.ts", "live/abc/1080p/seg1.ts"}, objects)
+	})
+
+	t.Run("should list every pending object below a directory of recursive backends", func(t *testing.T) {
+		backend := &recursiveStorage{Storage: fs.NewStorage(fs.Config{Root: t.TempDir()})}
+		pending := newPendingWrites()
+		s := &pendingStorage{Storage: backend, pending: pending}
+		require.NoError(t, backend.Save(ctx, bytes.NewBufferString("old"), "live/abc/720p/seg1.ts"))
+
+		pending.add("job-1", operation{op: jobs.OpUpload, path: "live/abc/720p/seg2.ts", payload: []byte("new")})
+		pending.add("job-2", operation{op: jobs.OpDelete, path: "live/abc/720p/seg1.ts"})
+		pending.add("job-3", operation{op: jobs.OpUpload, path: "live/abc/index.m3u8", payload: []byte("new")})
+
+		stats, err := s.List(ctx, "live")
+		require.NoError(t, err)
+		paths := make([]string, 0, len(stats))
+		for _, st := range stats {
+			assert.False(t, st.IsDir)
+			paths = append(paths, st.Path)
+		}
+		assert.ElementsMatch(t, []string{"live/abc/720p/seg2.ts", "live/abc/index.m3u8"}, paths)
+	})
+}
+
+// recursiveStorage lists every object below a path, like object storages.
+type recursiveStorage struct {
+	provider.Storage
+}
+
+func (s *recursiveStorage) ListsRecursively() bool {
+	return true
+}
+
+func (s *recursiveStorage) List(ctx context.Context, dir string) ([]*provider.Stat, error) {
+	var stats []*provider.Stat
+	err := provider.Walk(ctx, s.Storage, dir, func(name string, st *provider.Stat) error {
+		stats = append(stats, &provider.Stat{Name: name, Path: name, Size: st.Size})
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/hls"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+// DefaultBlockingReloadTimeout bounds how long a blocking playlist reload
+// is held.
+const DefaultBlockingReloadTimeout = 10 * time.Second
+
+// maxPlaylistSize bounds the playlists rewritten in memory; larger ones are
+// served as they are.
+const maxPlaylistSize = 4 << 20
+
+var errMSNTooFar = errors.New("_HLS_msn is more than two segments ahead")
+
+// hlsQuery is a Low-Latency HLS playlist request.
+type hlsQuery struct {
+	msn  int64
+	part int
+	skip hls.SkipMode
+	// blocking is set when the request waits for msn and part
+	blocking bool
+}
+
+func isPlaylist(p string) bool {
+	return strings.EqualFold(path.Ext(p), ".m3u8")
+}
+
+// hasHLSQuery reports whether the request carries Low-Latency HLS
+// parameters.
+func hasHLSQuery(query url.Values) bool {
+	return query.Has(hls.ParamMSN) || query.Has(hls.ParamPart) || query.Has(hls.ParamSkip)
+}
+
+func parseHLSQuery(query url.Values) (hlsQuery, error) {
+	q := hlsQuery{part: -1}
+	var err error
+
+	if v := query.Get(hls.ParamMSN); v != "" {
+		if q.msn, err = strconv.ParseInt(v, 10, 64); err != nil || q.msn < 0 {
+			return q, fmt.Errorf("invalid %s: %q", hls.ParamMSN, v)
+		}
+		q.blocking = true
+	}
+	if v := query.Get(hls.ParamPart); v != "" {
+		if !q.blocking {
+			return q, fmt.Errorf("%s requires %s", hls.ParamPart, hls.ParamMSN)
+		}
+		if q.part, err = strconv.Atoi(v); err != nil || q.part < 0 {
+			return q, fmt.Errorf("invalid %s: %q", hls.ParamPart, v)
+		}
+	}
+	q.skip, err = hls.ParseSkip(query.Get(hls.ParamSkip))
+	return q, err
+}
+
+// servePlaylist serves an HLS playlist for Low-Latency delivery.
+//
+// With _HLS_msn, and optionally _HLS_part, the request is held until the
+// playlist contains that segment or part, waking up whenever the playlist is
+// written, for at most three target durations or the reload timeout; past
+// that it fails with 503. _HLS_skip asks for a Playlist Delta Update.
+// Low-Latency playlists also announce the oldest upload in progress next to
+// them they do not list yet as a preload hint. Playlists requested without
+// these parameters are served as they are stored.
+func (h *DownloadHandler) servePlaylist(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request, name string) {
+	q, err := parseHLSQuery(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, "Invalid playlist request", http.StatusBadRequest, err)
+		return
+	}
+
+	var timeout *time.Timer
+	// Only blocking reloads wait, holding one registration at a time
+	var changed <-chan struct{}
+	release := func() {}
+	defer func() { release() }()
+	for {
+		if q.blocking {
+			release()
+			changed, release = h.streaming.changes.wait(name)
+		}
+
+		data, err := readPlaylist(ctx, storageBackend, name)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, provider.ErrNotExist) {
+				status = http.StatusNotFound
+			}
+			utils.WriteError(w, "File open failed", status, err)
+			return
+		}
+		if data == nil {
+			h.serveFile(ctx, storageBackend, w, name)
+			return
+		}
+
+		p, err := hls.Parse(bytes.NewReader(data))
+		if err != nil {
+			// Master playlists are served as they are
+			h.writePlaylist(w, name, data)
+			return
+		}
+		if !q.blocking || p.Has(q.msn, q.part) {
+			h.encodePlaylist(w, name, p, q.skip)
+			return
+		}
+		if q.msn > p.NextMSN()+1 {
+			utils.WriteError(w, "Invalid playlist request", http.StatusBadRequest, errMSNTooFar)
+			return
+		}
+
+		if timeout == nil {
+			d := h.reloadTimeout
+			if target := time.Duration(3 * p.TargetDuration * float64(time.Second)); target > 0 {
+				d = min(d, target)
+			}
+			timeout = time.NewTimer(d)
+			defer timeout.Stop()
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			utils.WriteError(w, "Playlist not updated in time", http.StatusServiceUnavailable,
+				fmt.Errorf("segment %d part %d not available", q.msn, q.part))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readPlaylist reads a playlist, or returns nil if it is too large to be
+// rewritten.
+func readPlaylist(ctx context.Context, storageBackend provider.Storage, name string) ([]byte, error) {
+	rc, err := storageBackend.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxPlaylistSize+1))
+	if err != nil || len(data) > maxPlaylistSize {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (h *DownloadHandler) encodePlaylist(w http.ResponseWriter, name string, p *hls.Playlist, skip hls.SkipMode) {
+	var buf bytes.Buffer
+	if err := p.Encode(&buf, hls.EncodeOptions{Skip: skip, PreloadHint: h.preloadHint(name, p)}); err != nil {
+		utils.WriteError(w, "Failed to encode playlist", http.StatusInternalServerError, err)
+		return
+	}
+	h.writePlaylist(w, name, buf.Bytes())
+}
+
+func (h *DownloadHandler) writePlaylist(w http.ResponseWriter, name string, data []byte) {
+	contentType := mime.TypeByExtension(".m3u8")
+	w.Header().Set("Content-Type", contentType)
+	h.setCacheHeaders(w, name, contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if _, err := w.Write(data); err != nil {
+		h.logger.Error("Failed to write playlist", zap.Error(err))
+	}
+}
+
+// preloadHint returns the oldest upload in progress below the playlist's
+// directory that the playlist does not list yet, relative to the playlist.
+func (h *DownloadHandler) preloadHint(name string, p *hls.Playlist) string {
+	if p.Ended || p.PreloadHint || p.PartTarget == 0 {
+		return ""
+	}
+
+	dir := path.Dir(name)
+	for _, upload := range h.streaming.activeUploadsUnder(dir) {
+		uri := strings.TrimPrefix(upload, dir+"/")
+		if !isManifest(uri) && !p.References(uri) {
+			return uri
+		}
+	}
+	return ""
+}
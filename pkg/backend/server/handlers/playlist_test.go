@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+// llPlaylist returns a Low-Latency playlist of segments 4s segments from
+// msn 0 with parts of the next one.
+func llPlaylist(segments, parts int) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:4\n")
+	b.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=3.0,CAN-SKIP-UNTIL=12.0\n")
+	b.WriteString("#EXT-X-PART-INF:PART-TARGET=1.0\n#EXT-X-MEDIA-SEQUENCE:0\n")
+	for i := range segments {
+		fmt.Fprintf(&b, "#EXTINF:4.0,\nseg%d.mp4\n", i)
+	}
+	for p := range parts {
+		fmt.Fprintf(&b, "#EXT-X-PART:DURATION=1.0,URI=\"seg%d.%d.mp4\"\n", segments, p)
+	}
+	return b.String()
+}
+
+func TestPlaylistReload(t *testing.T) {
+	newServer := func(t *testing.T, opts ...Option) *httptest.Server {
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		h := NewStorageHandler(fs.NewStorage(fs.Config{Root: t.TempDir()}), pool, pool, opts...)
+		t.Cleanup(h.Shutdown)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), middleware.ValidatedPathContextKey, strings.TrimPrefix(r.URL.Path, "/"))
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	put := func(t *testing.T, srv *httptest.Server, path, body string) {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Prefer", "wait")
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Less(t, resp.StatusCode, 300)
+	}
+
+	get := func(t *testing.T, srv *httptest.Server, path string) (int, string) {
+		resp, err := srv.Client().Get(srv.URL + "/" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("should hold a blocking reload until the part is published", func(t *testing.T) {
+		srv := newServer(t)
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(3, 1))
+
+		type result struct {
+			status int
+			body   string
+		}
+		done := make(chan result, 1)
+		go func() {
+			status, body := get(t, srv, "live/abc/index.m3u8?_HLS_msn=3&_HLS_part=2")
+			done <- result{status, body}
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(3, 2))
+		select {
+		case <-done:
+			t.Fatal("released before part 2 was published")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(3, 3))
+		select {
+		case res := <-done:
+			assert.Equal(t, http.StatusOK, res.status)
+			assert.Contains(t, res.body, `URI="seg3.2.mp4"`)
+		case <-time.After(time.Second):
+			t.Fatal("blocking reload not released")
+		}
+
+		status, _ := get(t, srv, "live/abc/index.m3u8?_HLS_msn=2")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("should fail with 503 when the playlist is not updated in time", func(t *testing.T) {
+		srv := newServer(t, WithBlockingReloadTimeout(50*time.Millisecond))
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(3, 1))
+
+		start := time.Now()
+		status, _ := get(t, srv, "live/abc/index.m3u8?_HLS_msn=4")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("should reject invalid parameters", func(t *testing.T) {
+		srv := newServer(t)
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(3, 1))
+
+		for _, query := range []string{"_HLS_part=1", "_HLS_msn=x", "_HLS_msn=3&_HLS_part=-1", "_HLS_skip=NO", "_HLS_msn=9"} {
+			status, _ := get(t, srv, "live/abc/index.m3u8?"+query)
+			assert.Equal(t, http.StatusBadRequest, status, query)
+		}
+		status, _ := get(t, srv, "live/abc/missing.m3u8?_HLS_msn=1")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("should serve delta updates", func(t *testing.T) {
+		srv := newServer(t)
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(6, 1))
+
+		status, body := get(t, srv, "live/abc/index.m3u8?_HLS_skip=YES")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "#EXT-X-SKIP:SKIPPED-SEGMENTS=3\n")
+		assert.NotContains(t, body, "seg2.mp4")
+		assert.Contains(t, body, "seg3.mp4")
+
+		_, body = get(t, srv, "live/abc/index.m3u8")
+		assert.Equal(t, llPlaylist(6, 1), body)
+	})
+
+	t.Run("should serve playlists without directives as they are stored", func(t *testing.T) {
+		srv := newServer(t)
+		playlist := "#EXTM3U\n# packaged by test\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.000,\nseg0.ts\n"
+		put(t, srv, "vod/abc/index.m3u8", playlist)
+
+		status, body := get(t, srv, "vod/abc/index.m3u8")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, playlist, body)
+	})
+
+	t.Run("should announce the part being uploaded as a preload hint", func(t *testing.T) {
+		srv := newServer(t)
+		put(t, srv, "live/abc/index.m3u8", llPlaylist(3, 1))
+
+		pr, pw := io.Pipe()
+		defer pw.Close()
+		go func() {
+			req, _ := http.NewRequest(http.MethodPut, srv.URL+"/live/abc/seg3.1.mp4", pr)
+			if resp, err := srv.Client().Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		io.WriteString(pw, "part")
+
+		require.Eventually(t, func() bool {
+			_, body := get(t, srv, "live/abc/index.m3u8?_HLS_msn=3&_HLS_part=0")
+			return strings.HasSuffix(body, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg3.1.mp4\"\n")
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestChangeNotifier(t *testing.T) {
+	t.Run("should only track paths while someone waits", func(t *testing.T) {
+		n := newChangeNotifier()
+
+		first, releaseFirst := n.wait("live/index.m3u8")
+		_, releaseSecond := n.wait("live/index.m3u8")
+		releaseFirst()
+		releaseFirst()
+		assert.Len(t, n.waiting, 1)
+		releaseSecond()
+		assert.Empty(t, n.waiting)
+
+		_, release := n.wait("live/index.m3u8")
+		n.notify("live/index.m3u8")
+		assert.Empty(t, n.waiting)
+		release()
+		assert.Empty(t, n.waiting)
+
+		select {
+		case <-first:
+			t.Fatal("released waiters were woken up")
+		default:
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"path"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+)
+
+// Operations waiting for a worker are admitted live before VOD and, within
+// each, manifests before segments. Players poll manifests, so a late manifest
+// stalls every viewer while a late segment is only fetched once it is listed.
+const (
+	priorityLiveManifest worker.Priority = iota
+	priorityLiveSegment
+	priorityManifest
+	prioritySegment
+)
+
+// DefaultLivePrefixes are the path prefixes treated as live content.
+var DefaultLivePrefixes = []string{"live/"}
+
+// priority classifies a storage path for admission to the worker pools.
+func (a *asyncRunner) priority(p string) worker.Priority {
+	live := false
+	for _, prefix := range a.livePrefixes {
+		if strings.HasPrefix(p, prefix) {
+			live = true
+			break
+		}
+	}
+
+	switch manifest := isManifest(p); {
+	case live && manifest:
+		return priorityLiveManifest
+	case live:
+		return priorityLiveSegment
+	case manifest:
+		return priorityManifest
+	default:
+		return prioritySegment
+	}
+}
+
+func isManifest(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".m3u8", ".mpd":
+		return true
+	default:
+		return false
+	}
+}
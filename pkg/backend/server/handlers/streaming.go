@@ -1,37 +1,60 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"io"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/veloxpack/storage/pkg/backend/server/events"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
 	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
 	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
 )
 
-type ActiveUpload struct {
-	mu        sync.RWMutex
-	buffer    []byte
-	eof       bool
-	header    http.Header
-	createdAt time.Time
-	maxAge    int64
-}
-
 type StreamingHandler struct {
 	activeUploads map[string]*ActiveUpload
 	uploadsLock   sync.RWMutex
 	stopChan      chan struct{}
+	liveReaders   atomic.Int64
+	writeTimeout  time.Duration
+	// window is how much of each upload is kept in memory, all uploads
+	// together being limited to the memory budget
+	window   int64
+	memory   *memoryBudget
+	spillDir string
+	// maxSize limits each chunked upload, zero meaning no limit
+	maxSize  int64
+	logger   *zap.Logger
+	ordering *worker.KeyedExecutor
+	events   *events.Bus
+	// changes is told about every path saved
+	changes *changeNotifier
+	// cacheControl holds the Cache-Control headers sent with uploads
+	cacheControl *cacheOverrides
 }
 
-func NewStreamingHandler() *StreamingHandler {
+func NewStreamingHandler(ordering *worker.KeyedExecutor, bus *events.Bus) *StreamingHandler {
 	h := &StreamingHandler{
 		activeUploads: make(map[string]*ActiveUpload),
+		writeTimeout:  DefaultLiveWriteTimeout,
+		window:        DefaultLiveWindow,
+		memory:        newMemoryBudget(DefaultLiveMemory),
+		logger:        zap.L().Named("streaming"),
+		ordering:      ordering,
+		events:        bus,
+		changes:       newChangeNotifier(),
+		cacheControl:  newCacheOverrides(),
 		stopChan:      make(chan struct{}),
 	}
 	go h.cleanupActiveUploads()
@@ -45,11 +68,8 @@ func (h *StreamingHandler) HandleChunkedUpload(
 	r *http.Request,
 	path string,
 ) {
-	au := &ActiveUpload{
-		header:    r.Header.Clone(),
-		createdAt: time.Now(),
-		maxAge:    getMaxAgeOr(r.Header.Get("Cache-Control"), -1),
-	}
+	au := newActiveUpload(r.Header.Clone(), getMaxAgeOr(r.Header.Get("Cache-Control"), -1), h.window, h.memory, h.spillDir)
+	defer au.release()
 
 	h.uploadsLock.Lock()
 	h.activeUploads[path] = au
@@ -57,37 +77,121 @@ func (h *StreamingHandler) HandleChunkedUpload(
 
 	defer h.cleanupUpload(path)
 
-	r.Body = http.MaxBytesReader(w, r.Body, utils.MaxUploadSize)
+	if h.maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxSize)
+	}
 	defer r.Body.Close()
 
 	buf := make([]byte, 32*1024) // 32KB chunks
 	for {
 		n, err := r.Body.Read(buf)
 		if n > 0 {
-			au.mu.Lock()
-			au.buffer = append(au.buffer, buf[:n]...)
-			au.mu.Unlock()
+			if err := au.write(buf[:n]); err != nil {
+				au.finish(err)
+				utils.WriteError(w, "Failed to buffer upload", http.StatusInternalServerError, err)
+				return
+			}
 		}
 
 		if err != nil {
 			if err != io.EOF {
+				au.finish(err)
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					utils.WriteError(w, "Upload too large", http.StatusRequestEntityTooLarge, nil)
+					return
+				}
 				utils.WriteError(w, "Upload failed", http.StatusBadRequest, err)
 				return
 			}
 			break
 		}
 	}
+	au.finish(nil)
 
-	au.mu.RLock()
-	defer au.mu.RUnlock()
-	if err := storageBackend.Save(context.Background(), bytes.NewReader(au.buffer), path); err != nil {
+	if err := h.save(ctx, storageBackend, path, au.reader()); err != nil {
 		utils.WriteError(w, "Final save failed", http.StatusInternalServerError, err)
 		return
 	}
-
+	h.cacheControl.set(path, r.Header.Get("Cache-Control"))
 	w.WriteHeader(http.StatusCreated)
 }
 
+// save writes content to path in order with background uploads and deletes
+// of the same path, and publishes the outcome.
+func (h *StreamingHandler) save(ctx context.Context, storageBackend provider.Storage, path string, content io.Reader) error {
+	tenant := middleware.TenantOf(ctx, path)
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(content, hash)}
+
+	var (
+		err     error
+		existed bool
+	)
+	h.ordering.Run(path, func() {
+		saveCtx := context.WithoutCancel(ctx)
+		if h.events.Active() {
+			_, statErr := storageBackend.Stat(saveCtx, path)
+			existed = statErr == nil
+		}
+		err = storageBackend.Save(saveCtx, counter, path)
+	})
+	if err != nil {
+		h.events.Publish(events.Event{Type: events.UploadFailed, Path: path, Tenant: tenant, Error: err.Error()})
+		return err
+	}
+
+	h.changes.notify(path)
+	// The Cache-Control of the replaced object does not carry over
+	h.cacheControl.set(path, "")
+
+	eventType := events.ObjectCreated
+	if existed {
+		eventType = events.ObjectUpdated
+	}
+	h.events.Publish(events.Event{
+		Type:     eventType,
+		Path:     path,
+		Size:     counter.n,
+		Checksum: events.FormatChecksum(hash.Sum(nil)),
+		Tenant:   tenant,
+	})
+	return nil
+}
+
+// remove deletes path in order with other writes of the path, publishing
+// the outcome like a direct delete.
+func (h *StreamingHandler) remove(ctx context.Context, storageBackend provider.Storage, path string) error {
+	tenant := middleware.TenantOf(ctx, path)
+
+	var err error
+	h.ordering.Run(path, func() {
+		err = storageBackend.Delete(context.WithoutCancel(ctx), path)
+	})
+	if err != nil {
+		if !errors.Is(err, provider.ErrNotExist) {
+			h.events.Publish(events.Event{Type: events.DeleteFailed, Path: path, Tenant: tenant, Error: err.Error()})
+		}
+		return err
+	}
+
+	h.cacheControl.set(path, "")
+	h.events.Publish(events.Event{Type: events.ObjectDeleted, Path: path, Tenant: tenant})
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func (h *StreamingHandler) GetActiveUpload(path string) (*ActiveUpload, bool) {
 	h.uploadsLock.RLock()
 	defer h.uploadsLock.RUnlock()
@@ -95,6 +199,43 @@ func (h *StreamingHandler) GetActiveUpload(path string) (*ActiveUpload, bool) {
 	return au, exists
 }
 
+// activeUploadsUnder returns the paths of the chunked uploads in progress
+// below dir, oldest first.
+func (h *StreamingHandler) activeUploadsUnder(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	h.uploadsLock.RLock()
+	var paths []string
+	for path := range h.activeUploads {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return h.activeUploads[paths[i]].createdAt.Before(h.activeUploads[paths[j]].createdAt)
+	})
+	h.uploadsLock.RUnlock()
+	return paths
+}
+
+// ActiveUploads returns the number of chunked uploads in progress.
+func (h *StreamingHandler) ActiveUploads() int {
+	h.uploadsLock.RLock()
+	defer h.uploadsLock.RUnlock()
+	return len(h.activeUploads)
+}
+
+// LiveReaders returns the number of readers following an active upload.
+func (h *StreamingHandler) LiveReaders() int {
+	return int(h.liveReaders.Load())
+}
+
+// trackReader counts a live reader until the returned function is called.
+func (h *StreamingHandler) trackReader() func() {
+	h.liveReaders.Add(1)
+	return func() { h.liveReaders.Add(-1) }
+}
+
 func (h *StreamingHandler) cleanupUpload(path string) {
 	h.uploadsLock.Lock()
 	delete(h.activeUploads, path)
@@ -104,20 +245,22 @@ func (h *StreamingHandler) cleanupUpload(path string) {
 func (h *StreamingHandler) cleanupActiveUploads() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
+	spillTicker := time.NewTicker(liveIdleSpill / 3)
+	defer spillTicker.Stop()
 
 	for {
 		select {
+		case <-spillTicker.C:
+			h.spillIdleUploads(time.Now().Add(-liveIdleSpill))
 		case <-ticker.C:
 			h.uploadsLock.Lock()
 			now := time.Now()
 			// Check for expired files
 			for path, au := range h.activeUploads {
-				au.mu.RLock()
 				expirationTime := au.createdAt.Add(time.Second * time.Duration(au.maxAge))
 				if expirationTime.Before(now) {
 					delete(h.activeUploads, path)
 				}
-				au.mu.RUnlock()
 			}
 			h.uploadsLock.Unlock()
 		case <-h.stopChan:
@@ -126,6 +269,28 @@ func (h *StreamingHandler) cleanupActiveUploads() {
 	}
 }
 
+// spillIdleUploads moves the windows of uploads that ended or received
+// nothing since before to disk, returning their memory to the budget.
+func (h *StreamingHandler) spillIdleUploads(before time.Time) {
+	h.uploadsLock.RLock()
+	uploads := make(map[string]*ActiveUpload, len(h.activeUploads))
+	for path, au := range h.activeUploads {
+		uploads[path] = au
+	}
+	h.uploadsLock.RUnlock()
+
+	for path, au := range uploads {
+		if err := au.spillIdle(before); err != nil {
+			h.logger.Warn("Failed to spill idle upload", zap.String("path", path), zap.Error(err))
+		}
+	}
+}
+
+// LiveMemory returns the number of bytes held in memory by active uploads.
+func (h *StreamingHandler) LiveMemory() int64 {
+	return h.memory.Used()
+}
+
 func (h *StreamingHandler) Shutdown() {
 	close(h.stopChan)
 }
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestLiveUpload(t *testing.T) {
+	newServer := func(t *testing.T, opts ...Option) (*httptest.Server, *StorageHandler) {
+		pool, err := worker.NewPool(4)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		h := NewStorageHandler(fs.NewStorage(fs.Config{Root: t.TempDir()}), pool, pool, opts...)
+		t.Cleanup(h.Shutdown)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), middleware.ValidatedPathContextKey, strings.TrimPrefix(r.URL.Path, "/"))
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}))
+		t.Cleanup(srv.Close)
+		return srv, h
+	}
+
+	// upload starts a chunked upload fed from the returned pipe.
+	upload := func(t *testing.T, srv *httptest.Server, h *StorageHandler, path string) (*io.PipeWriter, <-chan int) {
+		pr, pw := io.Pipe()
+		status := make(chan int, 1)
+		go func() {
+			req, _ := http.NewRequest(http.MethodPut, srv.URL+"/"+path, pr)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				status <- 0
+				return
+			}
+			resp.Body.Close()
+			status <- resp.StatusCode
+		}()
+		return pw, status
+	}
+
+	readN := func(t *testing.T, r io.Reader, n int) string {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	t.Run("should pass each chunk on to readers as it arrives", func(t *testing.T) {
+		srv, h := newServer(t)
+		pw, status := upload(t, srv, h, "live/abc/seg1.ts")
+
+		io.WriteString(pw, "first")
+		require.Eventually(t, func() bool { return h.ActiveUploads() == 1 }, time.Second, time.Millisecond)
+
+		resp, err := srv.Client().Get(srv.URL + "/live/abc/seg1.ts")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "first", readN(t, resp.Body, 5))
+
+		start := time.Now()
+		io.WriteString(pw, "second")
+		assert.Equal(t, "second", readN(t, resp.Body, 6))
+		assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+		pw.Close()
+		rest, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Empty(t, rest)
+		assert.Equal(t, http.StatusCreated, <-status)
+	})
+
+	t.Run("should abort readers when the upload fails", func(t *testing.T) {
+		srv, h := newServer(t)
+		pw, _ := upload(t, srv, h, "live/abc/seg2.ts")
+
+		io.WriteString(pw, "partial")
+		require.Eventually(t, func() bool { return h.ActiveUploads() == 1 }, time.Second, time.Millisecond)
+
+		resp, err := srv.Client().Get(srv.URL + "/live/abc/seg2.ts")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "partial", readN(t, resp.Body, 7))
+
+		pw.CloseWithError(io.ErrUnexpectedEOF)
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err)
+	})
+
+	t.Run("should drop readers that stop reading without slowing the upload", func(t *testing.T) {
+		srv, h := newServer(t, WithLiveWriteTimeout(50*time.Millisecond))
+		pw, status := upload(t, srv, h, "live/abc/seg3.ts")
+
+		io.WriteString(pw, "start")
+		require.Eventually(t, func() bool { return h.ActiveUploads() == 1 }, time.Second, time.Millisecond)
+
+		resp, err := srv.Client().Get(srv.URL + "/live/abc/seg3.ts")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Eventually(t, func() bool { return h.LiveReaders() == 1 }, time.Second, time.Millisecond)
+
+		// More than the socket buffers hold, so the reader's writes block
+		chunk := bytes.Repeat([]byte("x"), 1<<20)
+		for range 24 {
+			_, err := pw.Write(chunk)
+			require.NoError(t, err)
+		}
+		assert.Eventually(t, func() bool { return h.LiveReaders() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+		pw.Close()
+		assert.Equal(t, http.StatusCreated, <-status)
+	})
+
+	t.Run("should stream uploads past the window and the upload size limit", func(t *testing.T) {
+		srv, h := newServer(t, WithLiveBuffer(minLiveWindow, DefaultLiveMemory))
+		pw, status := upload(t, srv, h, "live/abc/seg4.mp4")
+
+		// Each chunk differs, so readers would notice bytes out of order
+		chunks := make([][]byte, 60)
+		want := sha256.New()
+		for i := range chunks {
+			chunks[i] = bytes.Repeat([]byte{byte(i)}, 1<<20)
+			want.Write(chunks[i])
+		}
+
+		for _, chunk := range chunks[:10] {
+			_, err := pw.Write(chunk)
+			require.NoError(t, err)
+		}
+		require.Eventually(t, func() bool { return h.ActiveUploads() == 1 }, time.Second, time.Millisecond)
+
+		// The reader joins once the start of the upload left the window
+		resp, err := srv.Client().Get(srv.URL + "/live/abc/seg4.mp4")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		got := sha256.New()
+		read := make(chan int64, 1)
+		go func() {
+			n, _ := io.Copy(got, resp.Body)
+			read <- n
+		}()
+
+		for _, chunk := range chunks[10:] {
+			_, err := pw.Write(chunk)
+			require.NoError(t, err)
+		}
+		pw.Close()
+		assert.Equal(t, http.StatusCreated, <-status)
+
+		assert.Equal(t, int64(len(chunks))<<20, <-read)
+		assert.Equal(t, want.Sum(nil), got.Sum(nil))
+		assert.LessOrEqual(t, h.LiveMemory(), int64(minLiveWindow))
+
+		stored, err := srv.Client().Get(srv.URL + "/live/abc/seg4.mp4")
+		require.NoError(t, err)
+		defer stored.Body.Close()
+		saved := sha256.New()
+		_, err = io.Copy(saved, stored.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want.Sum(nil), saved.Sum(nil))
+	})
+
+	t.Run("should reject uploads past the configured size", func(t *testing.T) {
+		srv, h := newServer(t, WithMaxChunkedUploadSize(1<<20))
+		pw, status := upload(t, srv, h, "live/abc/seg5.mp4")
+
+		go func() {
+			for range 2 {
+				if _, err := pw.Write(bytes.Repeat([]byte("x"), 1<<20)); err != nil {
+					return
+				}
+			}
+			pw.Close()
+		}()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, <-status)
+
+		resp, err := srv.Client().Get(srv.URL + "/live/abc/seg5.mp4")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// discardResponse is a ResponseWriter dropping everything written to it.
+type discardResponse struct {
+	header http.Header
+}
+
+func (d *discardResponse) Header() http.Header         { return d.header }
+func (d *discardResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponse) WriteHeader(int)             {}
+func (d *discardResponse) Flush()                      {}
+
+// BenchmarkLiveFanout measures passing each chunk of an upload on to many
+// readers at once.
+func BenchmarkLiveFanout(b *testing.B) {
+	chunk := bytes.Repeat([]byte("x"), 32*1024)
+
+	for _, readers := range []int{1, 100, 500} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			streaming := NewStreamingHandler(nil, nil)
+			defer streaming.Shutdown()
+			h := NewDownloadHandler(streaming, middleware.AllowAll)
+			au := newActiveUpload(http.Header{}, -1, DefaultLiveWindow, newMemoryBudget(DefaultLiveMemory), b.TempDir())
+			defer au.release()
+
+			var wg sync.WaitGroup
+			for range readers {
+				au.acquire()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodGet, "/live/abc/seg.ts", nil)
+					h.serveActiveUpload(&discardResponse{header: http.Header{}}, r, "live/abc/seg.ts", au)
+				}()
+			}
+
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+			for range b.N {
+				au.write(chunk)
+			}
+			au.finish(nil)
+			wg.Wait()
+		})
+	}
+}
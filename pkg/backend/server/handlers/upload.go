@@ -1,11 +1,11 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
 	"io"
 	"net/http"
 
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
 	"github.com/veloxpack/storage/pkg/backend/server/middleware"
 	"github.com/veloxpack/storage/pkg/backend/server/utils"
 	"github.com/veloxpack/storage/pkg/backend/server/worker"
@@ -14,28 +14,50 @@ import (
 )
 
 type UploadHandler struct {
-	pool      *worker.Pool
-	maxSize   int64
-	logger    *zap.Logger
-	streaming *StreamingHandler
+	pool        *worker.Pool
+	maxSize     int64
+	maxPartSize int64
+	extract     extractLimits
+	authorize   middleware.Authorizer
+	logger      *zap.Logger
+	streaming   *StreamingHandler
+	runner      *asyncRunner
 }
 
 func NewUploadHandler(
 	pool *worker.Pool,
 	maxSize int64,
+	maxPartSize int64,
+	extract extractLimits,
+	authorize middleware.Authorizer,
 	streaming *StreamingHandler,
+	runner *asyncRunner,
 ) *UploadHandler {
 	return &UploadHandler{
-		pool:      pool,
-		maxSize:   maxSize,
-		streaming: streaming,
-		logger:    zap.L().Named("upload"),
+		pool:        pool,
+		maxSize:     maxSize,
+		maxPartSize: maxPartSize,
+		extract:     extract,
+		authorize:   authorize,
+		streaming:   streaming,
+		runner:      runner,
+		logger:      zap.L().Named("upload"),
 	}
 }
 
 func (h *UploadHandler) Handle(ctx context.Context, storageBackend provider.Storage, w http.ResponseWriter, r *http.Request) {
 	path := middleware.GetValidatedPath(ctx)
 
+	if r.URL.Query().Has("extract") {
+		h.handleExtract(ctx, storageBackend, w, r, path)
+		return
+	}
+
+	if isForm(r) {
+		h.handleForm(ctx, storageBackend, w, r, path)
+		return
+	}
+
 	if h.isChunked(r) {
 		h.streaming.HandleChunkedUpload(ctx, storageBackend, w, r, path)
 		return
@@ -47,18 +69,15 @@ func (h *UploadHandler) Handle(ctx context.Context, storageBackend provider.Stor
 		return
 	}
 
-	task := func() {
-		if err := storageBackend.Save(context.Background(), bytes.NewReader(body), path); err != nil {
-			h.logger.Error("Upload failed", zap.Error(err))
-		}
-	}
-
-	if err := h.pool.Submit(task); err != nil {
-		utils.WriteError(w, "Server busy", http.StatusTooManyRequests, err)
+	op := operation{op: jobs.OpUpload, path: path, payload: body, tenant: middleware.GetTenant(ctx)}
+	job, err := h.runner.submit(ctx, storageBackend, h.pool, op)
+	if err != nil {
+		writeSubmitError(w, "Server busy", h.pool, err)
 		return
 	}
+	h.streaming.cacheControl.set(path, r.Header.Get("Cache-Control"))
 
-	w.WriteHeader(http.StatusCreated)
+	h.runner.respond(w, r, job, http.StatusCreated)
 }
 
 func (h *UploadHandler) isChunked(r *http.Request) bool {
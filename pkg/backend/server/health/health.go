@@ -0,0 +1,246 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+// probePath is stat'ed to check a backend. It is not expected to exist; a
+// not-found answer proves the backend is reachable and authorised.
+const probePath = ".storage-readiness-probe"
+
+const defaultCheckTimeout = 5 * time.Second
+
+// diskUsager is implemented by storages backed by a local filesystem.
+type diskUsager interface {
+	DiskUsage() (free, total uint64, err error)
+}
+
+// Checker answers liveness, readiness and backend diagnostics requests.
+type Checker struct {
+	backends     []*Backend
+	pools        map[string]*worker.Pool
+	minFreeBytes uint64
+	timeout      time.Duration
+	logger       *zap.Logger
+}
+
+// NewChecker creates a checker for backends. Readiness fails when a local
+// filesystem backend has less than minFreeBytes available.
+func NewChecker(backends []*Backend, pools map[string]*worker.Pool, minFreeBytes uint64) *Checker {
+	return &Checker{
+		backends:     backends,
+		pools:        pools,
+		minFreeBytes: minFreeBytes,
+		timeout:      defaultCheckTimeout,
+		logger:       zap.L().Named("health"),
+	}
+}
+
+type backendReport struct {
+	Name      string      `json:"name"`
+	OK        bool        `json:"ok"`
+	LatencyMS float64     `json:"latency_ms"`
+	Error     string      `json:"error,omitempty"`
+	Disk      *diskReport `json:"disk,omitempty"`
+}
+
+type diskReport struct {
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+}
+
+type poolReport struct {
+	Name      string `json:"name"`
+	Running   int    `json:"running"`
+	Capacity  int    `json:"capacity"`
+	Queued    int    `json:"queued"`
+	Rejected  int64  `json:"rejected"`
+	Saturated bool   `json:"saturated"`
+}
+
+type readinessReport struct {
+	Status   string          `json:"status"`
+	Backends []backendReport `json:"backends"`
+	Pools    []poolReport    `json:"pools"`
+}
+
+// Healthz reports that the process is alive.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz actively checks every backend and reports worker pool saturation.
+// Saturated pools are reported but do not fail readiness, since they recover
+// on their own and callers already get 429 responses.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+
+	report := readinessReport{Status: "ready"}
+
+	for _, b := range c.backends {
+		br := c.checkBackend(ctx, b)
+		if !br.OK || (br.Disk != nil && !br.Disk.OK) {
+			report.Status = "not_ready"
+		}
+		report.Backends = append(report.Backends, br)
+	}
+
+	names := make([]string, 0, len(c.pools))
+	for name := range c.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := c.pools[name]
+		report.Pools = append(report.Pools, poolReport{
+			Name:      name,
+			Running:   p.Running(),
+			Capacity:  p.Cap(),
+			Queued:    p.Queued(),
+			Rejected:  p.Rejected(),
+			Saturated: p.Running() >= p.Cap(),
+		})
+	}
+
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+		c.logger.Warn("Readiness check failed", zap.Any("report", report))
+	}
+
+	writeJSON(w, status, report)
+}
+
+// Backends reports the last observed error and latency of each backend.
+func (c *Checker) Backends(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]Status, 0, len(c.backends))
+	for _, b := range c.backends {
+		statuses = append(statuses, b.Status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (c *Checker) checkBackend(ctx context.Context, b *Backend) backendReport {
+	start := time.Now()
+	// Probe the inner storage so probes are not counted as live operations
+	_, err := b.inner.Stat(ctx, probePath)
+	if errors.Is(err, provider.ErrNotExist) {
+		err = nil
+	}
+	latency := time.Since(start)
+	b.recordProbe(latency, err)
+
+	br := backendReport{
+		Name:      b.name,
+		OK:        err == nil,
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+	}
+	if err != nil {
+		br.Error = err.Error()
+	}
+
+	if du, ok := provider.As[diskUsager](b.inner); ok {
+		free, total, err := du.DiskUsage()
+		br.Disk = &diskReport{FreeBytes: free, TotalBytes: total, OK: err == nil && free >= c.minFreeBytes}
+		if err != nil {
+			br.Disk.Error = err.Error()
+		}
+	}
+
+	return br
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// Status is the diagnostic state of a backend.
+type Status struct {
+	Name          string     `json:"name"`
+	LastProbeAt   *time.Time `json:"last_probe_at,omitempty"`
+	LastProbeMS   float64    `json:"last_probe_latency_ms"`
+	LastLatencyMS float64    `json:"last_operation_latency_ms"`
+	LastOperation string     `json:"last_operation,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	Operations    int64      `json:"operations"`
+	Errors        int64      `json:"errors"`
+}
+
+// Backend tracks the outcome of probes and live operations for a storage.
+type Backend struct {
+	name    string
+	inner   provider.Storage
+	storage provider.Storage
+	mu      sync.Mutex
+	status  Status
+}
+
+// NewBackend wraps s so every operation updates the backend's diagnostic
+// status. The tracked storage is available from Storage.
+func NewBackend(name string, s provider.Storage) *Backend {
+	b := &Backend{name: name, inner: s, status: Status{Name: name}}
+	b.storage = &trackedStorage{Storage: s, backend: b}
+	return b
+}
+
+// Storage returns the tracked storage.
+func (b *Backend) Storage() provider.Storage {
+	return b.storage
+}
+
+// Status returns a snapshot of the backend's diagnostic status.
+func (b *Backend) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *Backend) recordProbe(latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.status.LastProbeAt = &now
+	b.status.LastProbeMS = float64(latency.Microseconds()) / 1000
+	if err != nil {
+		b.status.LastError = err.Error()
+		b.status.LastErrorAt = &now
+	}
+}
+
+func (b *Backend) recordOperation(op string, latency time.Duration, err error) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status.Operations++
+	b.status.LastOperation = op
+	b.status.LastLatencyMS = float64(latency.Microseconds()) / 1000
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		b.status.Errors++
+		b.status.LastError = err.Error()
+		b.status.LastErrorAt = &now
+		return
+	}
+	b.status.LastSuccessAt = &now
+}
@@ -0,0 +1,115 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// unreachableStorage fails every operation.
+type unreachableStorage struct {
+	provider.Storage
+}
+
+func (unreachableStorage) Stat(ctx context.Context, path string) (*provider.Stat, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+
+	serve := func(t *testing.T, h http.HandlerFunc, path string, v any) int {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+		return rec.Code
+	}
+
+	newPools := func(t *testing.T) map[string]*worker.Pool {
+		pool, err := worker.NewPool(2)
+		require.NoError(t, err)
+		t.Cleanup(pool.Release)
+		return map[string]*worker.Pool{"upload": pool}
+	}
+
+	t.Run("should report a live process", func(t *testing.T) {
+		c := NewChecker(nil, nil, 0)
+		var body map[string]string
+		assert.Equal(t, http.StatusOK, serve(t, c.Healthz, "/healthz", &body))
+		assert.Equal(t, "ok", body["status"])
+	})
+
+	t.Run("should be ready when every backend answers", func(t *testing.T) {
+		backend := NewBackend("fs", fs.NewStorage(fs.Config{Root: t.TempDir()}))
+		c := NewChecker([]*Backend{backend}, newPools(t), 0)
+
+		var report readinessReport
+		require.Equal(t, http.StatusOK, serve(t, c.Readyz, "/readyz", &report))
+		assert.Equal(t, "ready", report.Status)
+		require.Len(t, report.Backends, 1)
+		assert.True(t, report.Backends[0].OK)
+		require.NotNil(t, report.Backends[0].Disk)
+		assert.True(t, report.Backends[0].Disk.OK)
+		require.Len(t, report.Pools, 1)
+		assert.Equal(t, poolReport{Name: "upload", Capacity: 2}, report.Pools[0])
+
+		var statuses []Status
+		require.Equal(t, http.StatusOK, serve(t, c.Backends, "/debug/backends", &statuses))
+		require.Len(t, statuses, 1)
+		assert.NotNil(t, statuses[0].LastProbeAt)
+		assert.Empty(t, statuses[0].LastError)
+	})
+
+	t.Run("should not be ready when a backend fails", func(t *testing.T) {
+		backend := NewBackend("s3", unreachableStorage{})
+		c := NewChecker([]*Backend{backend}, newPools(t), 0)
+
+		var report readinessReport
+		require.Equal(t, http.StatusServiceUnavailable, serve(t, c.Readyz, "/readyz", &report))
+		assert.Equal(t, "not_ready", report.Status)
+		assert.False(t, report.Backends[0].OK)
+		assert.Equal(t, "connection refused", report.Backends[0].Error)
+
+		var statuses []Status
+		require.Equal(t, http.StatusOK, serve(t, c.Backends, "/debug/backends", &statuses))
+		assert.Equal(t, "connection refused", statuses[0].LastError)
+		assert.NotNil(t, statuses[0].LastErrorAt)
+	})
+
+	t.Run("should not be ready when the disk is almost full", func(t *testing.T) {
+		backend := NewBackend("fs", fs.NewStorage(fs.Config{Root: t.TempDir()}))
+		c := NewChecker([]*Backend{backend}, nil, math.MaxUint64)
+
+		var report readinessReport
+		require.Equal(t, http.StatusServiceUnavailable, serve(t, c.Readyz, "/readyz", &report))
+		assert.True(t, report.Backends[0].OK)
+		assert.False(t, report.Backends[0].Disk.OK)
+	})
+
+	t.Run("should track live operations of a backend", func(t *testing.T) {
+		backend := NewBackend("fs", fs.NewStorage(fs.Config{Root: t.TempDir()}))
+		s := backend.Storage()
+
+		require.NoError(t, s.Save(ctx, strings.NewReader("segment"), "vod/a.ts"))
+		_, err := s.Open(ctx, "vod/missing.ts")
+		require.Error(t, err)
+
+		status := backend.Status()
+		assert.Equal(t, int64(2), status.Operations)
+		assert.Equal(t, int64(0), status.Errors, "missing objects are not backend errors")
+		assert.Equal(t, "open", status.LastOperation)
+		assert.NotNil(t, status.LastSuccessAt)
+	})
+}
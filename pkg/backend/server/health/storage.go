@@ -0,0 +1,63 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// trackedStorage records the outcome of every operation on its backend.
+type trackedStorage struct {
+	provider.Storage
+	backend *Backend
+}
+
+func (s *trackedStorage) Save(ctx context.Context, content io.Reader, path string) error {
+	start := time.Now()
+	err := s.Storage.Save(ctx, content, path)
+	s.backend.recordOperation("save", time.Since(start), err)
+	return err
+}
+
+func (s *trackedStorage) Stat(ctx context.Context, path string) (*provider.Stat, error) {
+	start := time.Now()
+	stat, err := s.Storage.Stat(ctx, path)
+	s.backend.recordOperation("stat", time.Since(start), err)
+	return stat, err
+}
+
+func (s *trackedStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := s.Storage.Open(ctx, path)
+	s.backend.recordOperation("open", time.Since(start), err)
+	return rc, err
+}
+
+func (s *trackedStorage) Delete(ctx context.Context, path string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, path)
+	s.backend.recordOperation("delete", time.Since(start), err)
+	return err
+}
+
+func (s *trackedStorage) List(ctx context.Context, path string) ([]*provider.Stat, error) {
+	start := time.Now()
+	stats, err := s.Storage.List(ctx, path)
+	s.backend.recordOperation("list", time.Since(start), err)
+	return stats, err
+}
+
+func (s *trackedStorage) String() string {
+	if named, ok := s.Storage.(fmt.Stringer); ok {
+		return named.String()
+	}
+	return "unknown"
+}
+
+// Unwrap returns the wrapped storage.
+func (s *trackedStorage) Unwrap() provider.Storage {
+	return s.Storage
+}
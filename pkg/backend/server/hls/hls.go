@@ -0,0 +1,340 @@
+// Package hls reads HLS media playlists and rewrites them for Low-Latency
+// HLS delivery: it tells whether a playlist satisfies a blocking reload,
+// produces Playlist Delta Updates and adds preload hints.
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Query parameters of Low-Latency HLS playlist requests.
+const (
+	ParamMSN  = "_HLS_msn"
+	ParamPart = "_HLS_part"
+	ParamSkip = "_HLS_skip"
+)
+
+// SkipMode selects what a Playlist Delta Update leaves out.
+type SkipMode int
+
+const (
+	// SkipNone returns the whole playlist.
+	SkipNone SkipMode = iota
+	// SkipSegments skips segments older than the skip boundary.
+	SkipSegments
+	// SkipDateRanges also skips EXT-X-DATERANGE tags of skipped segments.
+	SkipDateRanges
+)
+
+// ParseSkip parses the value of the _HLS_skip parameter.
+func ParseSkip(value string) (SkipMode, error) {
+	switch value {
+	case "":
+		return SkipNone, nil
+	case "YES":
+		return SkipSegments, nil
+	case "v2":
+		return SkipDateRanges, nil
+	default:
+		return SkipNone, errInvalidSkip
+	}
+}
+
+var (
+	// ErrNotMediaPlaylist is returned by Parse for master playlists and
+	// anything that is not a playlist.
+	ErrNotMediaPlaylist = errors.New("not an HLS media playlist")
+	errInvalidSkip      = errors.New("invalid _HLS_skip value")
+)
+
+// skipVersion is the EXT-X-VERSION required by EXT-X-SKIP.
+const skipVersion = 9
+
+// playlistTags are the tags that apply to the whole playlist rather than
+// to the segment following them.
+var playlistTags = map[string]bool{
+	"#EXTM3U":                       true,
+	"#EXT-X-VERSION":                true,
+	"#EXT-X-TARGETDURATION":         true,
+	"#EXT-X-MEDIA-SEQUENCE":         true,
+	"#EXT-X-DISCONTINUITY-SEQUENCE": true,
+	"#EXT-X-PLAYLIST-TYPE":          true,
+	"#EXT-X-I-FRAMES-ONLY":          true,
+	"#EXT-X-INDEPENDENT-SEGMENTS":   true,
+	"#EXT-X-START":                  true,
+	"#EXT-X-SERVER-CONTROL":         true,
+	"#EXT-X-PART-INF":               true,
+	"#EXT-X-DEFINE":                 true,
+}
+
+// Segment is a complete media segment.
+type Segment struct {
+	URI      string
+	Duration float64
+	// Parts are the partial segments it was published as, if any
+	Parts []string
+	// lines are the segment's tags followed by its URI
+	lines []string
+}
+
+// Playlist is a parsed media playlist.
+type Playlist struct {
+	Version        int
+	TargetDuration float64
+	MediaSequence  int64
+	// CanSkipUntil is the skip boundary in seconds; zero if the playlist
+	// does not allow delta updates
+	CanSkipUntil      float64
+	CanSkipDateRanges bool
+	// PartTarget is the part target duration of Low-Latency playlists
+	PartTarget float64
+	Segments   []*Segment
+	// Parts are the partial segments of the segment in progress
+	Parts       []string
+	PreloadHint bool
+	Ended       bool
+
+	header  []string
+	trailer []string
+}
+
+// Parse reads a media playlist.
+func Parse(r io.Reader) (*Playlist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	p := &Playlist{}
+	var pending []string
+	var parts []string
+	var duration float64
+	first := true
+	inHeader := true
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if first {
+			if line != "#EXTM3U" {
+				return nil, ErrNotMediaPlaylist
+			}
+			first = false
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		if inHeader && playlistTags[tag] {
+			p.header = append(p.header, line)
+			p.parseHeaderTag(tag, value)
+			continue
+		}
+		inHeader = false
+
+		switch {
+		case tag == "#EXT-X-STREAM-INF" || tag == "#EXT-X-I-FRAME-STREAM-INF":
+			return nil, ErrNotMediaPlaylist
+		case !strings.HasPrefix(line, "#"):
+			p.Segments = append(p.Segments, &Segment{
+				URI:      line,
+				Duration: duration,
+				Parts:    parts,
+				lines:    append(pending, line),
+			})
+			pending, parts, duration = nil, nil, 0
+			continue
+		case tag == "#EXTINF":
+			d, _, _ := strings.Cut(value, ",")
+			duration, _ = strconv.ParseFloat(d, 64)
+		case tag == "#EXT-X-PART":
+			parts = append(parts, attributes(value)["URI"])
+		case tag == "#EXT-X-PRELOAD-HINT":
+			p.PreloadHint = true
+		case tag == "#EXT-X-ENDLIST":
+			p.Ended = true
+		}
+		pending = append(pending, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if first {
+		return nil, ErrNotMediaPlaylist
+	}
+
+	p.Parts = parts
+	p.trailer = pending
+	return p, nil
+}
+
+func (p *Playlist) parseHeaderTag(tag, value string) {
+	switch tag {
+	case "#EXT-X-VERSION":
+		p.Version, _ = strconv.Atoi(value)
+	case "#EXT-X-TARGETDURATION":
+		p.TargetDuration, _ = strconv.ParseFloat(value, 64)
+	case "#EXT-X-MEDIA-SEQUENCE":
+		p.MediaSequence, _ = strconv.ParseInt(value, 10, 64)
+	case "#EXT-X-SERVER-CONTROL":
+		attrs := attributes(value)
+		p.CanSkipUntil, _ = strconv.ParseFloat(attrs["CAN-SKIP-UNTIL"], 64)
+		p.CanSkipDateRanges = attrs["CAN-SKIP-DATERANGES"] == "YES"
+	case "#EXT-X-PART-INF":
+		p.PartTarget, _ = strconv.ParseFloat(attributes(value)["PART-TARGET"], 64)
+	}
+}
+
+// attributes parses an attribute list such as `URI="a.mp4",DURATION=1.0`.
+func attributes(list string) map[string]string {
+	attrs := make(map[string]string)
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		attrs[strings.TrimSpace(name)] = value
+		list = strings.TrimPrefix(rest, ",")
+	}
+	return attrs
+}
+
+// NextMSN returns the media sequence number of the segment in progress.
+func (p *Playlist) NextMSN() int64 {
+	return p.MediaSequence + int64(len(p.Segments))
+}
+
+// Has reports whether the playlist contains segment msn, or part part of it
+// when part is not negative, or anything later. An ended playlist has
+// everything it will ever have.
+func (p *Playlist) Has(msn int64, part int) bool {
+	next := p.NextMSN()
+	switch {
+	case p.Ended || msn < next:
+		return true
+	case part < 0:
+		return false
+	default:
+		return msn == next && part < len(p.Parts)
+	}
+}
+
+// References reports whether uri is a segment or part of the playlist.
+func (p *Playlist) References(uri string) bool {
+	for _, s := range p.Segments {
+		if s.URI == uri || contains(s.Parts, uri) {
+			return true
+		}
+	}
+	return contains(p.Parts, uri)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeOptions selects how a playlist is rewritten.
+type EncodeOptions struct {
+	// Skip produces a Playlist Delta Update if the playlist allows it
+	Skip SkipMode
+	// PreloadHint is the URI of the next part, announced unless the
+	// playlist already announces one
+	PreloadHint string
+}
+
+// Encode writes the playlist rewritten as selected by opts.
+func (p *Playlist) Encode(w io.Writer, opts EncodeOptions) error {
+	var buf bytes.Buffer
+	writeLine := func(line string) {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	skipped := p.skipped(opts.Skip)
+	for _, line := range p.header {
+		if skipped > 0 && strings.HasPrefix(line, "#EXT-X-VERSION:") && p.Version < skipVersion {
+			line = "#EXT-X-VERSION:" + strconv.Itoa(skipVersion)
+		}
+		writeLine(line)
+		if skipped > 0 && line == "#EXTM3U" && p.Version == 0 {
+			writeLine("#EXT-X-VERSION:" + strconv.Itoa(skipVersion))
+		}
+	}
+
+	if skipped > 0 {
+		writeLine("#EXT-X-SKIP:SKIPPED-SEGMENTS=" + strconv.Itoa(skipped))
+		// Clients that cannot skip date ranges still need them
+		if opts.Skip != SkipDateRanges || !p.CanSkipDateRanges {
+			for _, s := range p.Segments[:skipped] {
+				for _, line := range s.lines {
+					if strings.HasPrefix(line, "#EXT-X-DATERANGE:") {
+						writeLine(line)
+					}
+				}
+			}
+		}
+	}
+	for _, s := range p.Segments[skipped:] {
+		for _, line := range s.lines {
+			writeLine(line)
+		}
+	}
+
+	hint := opts.PreloadHint != "" && !p.PreloadHint && !p.Ended && p.PartTarget > 0
+	for _, line := range p.trailer {
+		if hint && strings.HasPrefix(line, "#EXT-X-RENDITION-REPORT:") {
+			writeLine(preloadHint(opts.PreloadHint))
+			hint = false
+		}
+		writeLine(line)
+	}
+	if hint {
+		writeLine(preloadHint(opts.PreloadHint))
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func preloadHint(uri string) string {
+	return `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="` + uri + `"`
+}
+
+// skipped returns how many segments a delta update skips: those starting
+// more than CAN-SKIP-UNTIL seconds before the end of the playlist.
+func (p *Playlist) skipped(mode SkipMode) int {
+	if mode == SkipNone || p.CanSkipUntil <= 0 {
+		return 0
+	}
+
+	var remaining float64
+	for _, s := range p.Segments {
+		remaining += s.Duration
+	}
+	for i, s := range p.Segments {
+		if remaining <= p.CanSkipUntil {
+			return i
+		}
+		remaining -= s.Duration
+	}
+	return len(p.Segments)
+}
@@ -0,0 +1,107 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// livePlaylist returns a Low-Latency playlist of segments complete
+// segments of 4s, starting at msn 100, with parts of the next one.
+func livePlaylist(segments, parts int) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:4\n")
+	b.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=3.0,CAN-SKIP-UNTIL=24.0\n")
+	b.WriteString("#EXT-X-PART-INF:PART-TARGET=1.0\n#EXT-X-MEDIA-SEQUENCE:100\n")
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	for i := range segments {
+		msn := 100 + i
+		if i == 2 {
+			b.WriteString("#EXT-X-DATERANGE:ID=\"ad\",START-DATE=\"2024-01-01T00:00:08Z\"\n")
+		}
+		for p := range 4 {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=1.0,URI=\"seg%d.%d.mp4\"\n", msn, p)
+		}
+		fmt.Fprintf(&b, "#EXTINF:4.0,\nseg%d.mp4\n", msn)
+	}
+	for p := range parts {
+		fmt.Fprintf(&b, "#EXT-X-PART:DURATION=1.0,URI=\"seg%d.%d.mp4\"\n", 100+segments, p)
+	}
+	b.WriteString("#EXT-X-RENDITION-REPORT:URI=\"../720p/index.m3u8\",LAST-MSN=109\n")
+	return b.String()
+}
+
+func TestPlaylist(t *testing.T) {
+	t.Run("should tell which segments and parts it has", func(t *testing.T) {
+		p, err := Parse(strings.NewReader(livePlaylist(10, 2)))
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(110), p.NextMSN())
+		assert.Equal(t, 1.0, p.PartTarget)
+		assert.Equal(t, 24.0, p.CanSkipUntil)
+		assert.Equal(t, []string{"seg110.0.mp4", "seg110.1.mp4"}, p.Parts)
+		assert.Equal(t, []string{"seg100.0.mp4", "seg100.1.mp4", "seg100.2.mp4", "seg100.3.mp4"}, p.Segments[0].Parts)
+
+		assert.True(t, p.Has(109, -1))
+		assert.True(t, p.Has(109, 3))
+		assert.False(t, p.Has(110, -1))
+		assert.True(t, p.Has(110, 1))
+		assert.False(t, p.Has(110, 2))
+		assert.False(t, p.Has(111, 0))
+
+		assert.True(t, p.References("seg110.1.mp4"))
+		assert.True(t, p.References("seg104.mp4"))
+		assert.False(t, p.References("seg110.2.mp4"))
+	})
+
+	t.Run("should skip segments older than the skip boundary", func(t *testing.T) {
+		p, err := Parse(strings.NewReader(livePlaylist(10, 2)))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, p.Encode(&buf, EncodeOptions{Skip: SkipSegments}))
+		out := buf.String()
+
+		// 40s of segments, the last 24s are kept
+		assert.Contains(t, out, "#EXT-X-VERSION:9\n")
+		assert.Contains(t, out, "#EXT-X-SKIP:SKIPPED-SEGMENTS=4\n")
+		assert.NotContains(t, out, "seg103.mp4")
+		assert.Contains(t, out, "#EXTINF:4.0,\nseg104.mp4\n")
+		assert.Contains(t, out, `#EXT-X-DATERANGE:ID="ad"`)
+
+		delta, err := Parse(strings.NewReader(out))
+		require.NoError(t, err)
+		assert.Len(t, delta.Segments, 6)
+		assert.Equal(t, p.Parts, delta.Parts)
+
+		buf.Reset()
+		p.CanSkipDateRanges = true
+		require.NoError(t, p.Encode(&buf, EncodeOptions{Skip: SkipDateRanges}))
+		assert.NotContains(t, buf.String(), "EXT-X-DATERANGE")
+	})
+
+	t.Run("should add a preload hint for the next part", func(t *testing.T) {
+		p, err := Parse(strings.NewReader(livePlaylist(3, 1)))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, p.Encode(&buf, EncodeOptions{PreloadHint: "seg103.1.mp4"}))
+		assert.True(t, strings.HasSuffix(buf.String(),
+			"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg103.1.mp4\"\n#EXT-X-RENDITION-REPORT:URI=\"../720p/index.m3u8\",LAST-MSN=109\n"))
+
+		buf.Reset()
+		require.NoError(t, p.Encode(&buf, EncodeOptions{}))
+		assert.Equal(t, livePlaylist(3, 1), buf.String())
+	})
+
+	t.Run("should reject master playlists", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n720p/index.m3u8\n"))
+		assert.ErrorIs(t, err, ErrNotMediaPlaylist)
+		_, err = Parse(strings.NewReader("<html>"))
+		assert.ErrorIs(t, err, ErrNotMediaPlaylist)
+	})
+}
@@ -0,0 +1,229 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"go.uber.org/zap"
+)
+
+// Op is the kind of storage operation a job performs.
+type Op string
+
+const (
+	OpUpload Op = "upload"
+	OpDelete Op = "delete"
+)
+
+// State is the lifecycle state of a job.
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+const sweepInterval = time.Minute
+
+var errNotFound = errors.New("unknown or expired job id")
+
+// Snapshot is a point-in-time copy of a job's state.
+type Snapshot struct {
+	ID         string     `json:"id"`
+	Op         Op         `json:"op"`
+	Path       string     `json:"path"`
+	State      State      `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	Attempts   int        `json:"attempts,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Job tracks a background storage operation.
+type Job struct {
+	mu   sync.Mutex
+	snap Snapshot
+	err  error
+	done chan struct{}
+}
+
+// ID returns the job identifier.
+func (j *Job) ID() string {
+	return j.snap.ID
+}
+
+// Snapshot returns a copy of the job's current state.
+func (j *Job) Snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snap
+}
+
+// Start marks the job as running.
+func (j *Job) Start() {
+	now := time.Now()
+	j.mu.Lock()
+	j.snap.State = StateRunning
+	j.snap.StartedAt = &now
+	j.mu.Unlock()
+}
+
+// SetAttempts records how many times the operation has been tried.
+func (j *Job) SetAttempts(n int) {
+	j.mu.Lock()
+	j.snap.Attempts = n
+	j.mu.Unlock()
+}
+
+// Finish records the outcome of the job and wakes up waiters.
+func (j *Job) Finish(err error) {
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.snap.FinishedAt != nil {
+		return
+	}
+
+	j.snap.FinishedAt = &now
+	j.err = err
+	if err != nil {
+		j.snap.State = StateFailed
+		j.snap.Error = err.Error()
+	} else {
+		j.snap.State = StateSucceeded
+	}
+	close(j.done)
+}
+
+// Wait blocks until the job finishes or ctx is done, and returns the job's
+// error. It returns ctx.Err() if the job is still running.
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return j.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel closed when the job finishes.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Registry keeps jobs in memory for a retention period after they finish.
+type Registry struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	retention time.Duration
+	lastSweep time.Time
+	logger    *zap.Logger
+}
+
+// NewRegistry creates a registry that forgets finished jobs after retention.
+func NewRegistry(retention time.Duration) *Registry {
+	return &Registry{
+		jobs:      make(map[string]*Job),
+		retention: retention,
+		lastSweep: time.Now(),
+		logger:    zap.L().Named("jobs"),
+	}
+}
+
+// Create registers a new pending job.
+func (r *Registry) Create(op Op, path string) *Job {
+	return r.Restore(newID(), op, path)
+}
+
+// Restore registers a pending job under a known id, e.g. when replaying
+// work persisted before a restart.
+func (r *Registry) Restore(id string, op Op, path string) *Job {
+	j := &Job{
+		snap: Snapshot{
+			ID:        id,
+			Op:        op,
+			Path:      path,
+			State:     StatePending,
+			CreatedAt: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.sweep(j.snap.CreatedAt)
+	r.jobs[j.snap.ID] = j
+	r.mu.Unlock()
+
+	return j
+}
+
+// Get returns the job with id.
+func (r *Registry) Get(id string) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+// Remove forgets a job, e.g. when it could not be submitted.
+func (r *Registry) Remove(id string) {
+	r.mu.Lock()
+	delete(r.jobs, id)
+	r.mu.Unlock()
+}
+
+// ServeHTTP serves the status of the job named by the last path segment.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	id := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	j, ok := r.Get(id)
+	if !ok {
+		utils.WriteError(w, "Job not found", http.StatusNotFound, errNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(j.Snapshot()); err != nil {
+		r.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// sweep forgets finished jobs past their retention period. Callers must hold r.mu.
+func (r *Registry) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < sweepInterval {
+		return
+	}
+	r.lastSweep = now
+
+	for id, j := range r.jobs {
+		snap := j.Snapshot()
+		if snap.FinishedAt != nil && now.Sub(*snap.FinishedAt) > r.retention {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
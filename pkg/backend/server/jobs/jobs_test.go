@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	get := func(t *testing.T, r *Registry, id string) (int, Snapshot) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_jobs/"+id, nil))
+		var snap Snapshot
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		}
+		return rec.Code, snap
+	}
+
+	t.Run("should report pending and running jobs", func(t *testing.T) {
+		r := NewRegistry(time.Hour)
+		j := r.Create(OpUpload, "vod/a.ts")
+
+		status, snap := get(t, r, j.ID())
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, StatePending, snap.State)
+		assert.Equal(t, OpUpload, snap.Op)
+		assert.Equal(t, "vod/a.ts", snap.Path)
+
+		j.Start()
+		_, snap = get(t, r, j.ID())
+		assert.Equal(t, StateRunning, snap.State)
+		assert.NotNil(t, snap.StartedAt)
+		assert.Nil(t, snap.FinishedAt)
+	})
+
+	t.Run("should report finished jobs with their error", func(t *testing.T) {
+		r := NewRegistry(time.Hour)
+		succeeded := r.Create(OpUpload, "vod/a.ts")
+		succeeded.Start()
+		succeeded.Finish(nil)
+		failed := r.Create(OpDelete, "vod/b.ts")
+		failed.Start()
+		failed.SetAttempts(3)
+		failed.Finish(errors.New("backend unavailable"))
+
+		_, snap := get(t, r, succeeded.ID())
+		assert.Equal(t, StateSucceeded, snap.State)
+		assert.Empty(t, snap.Error)
+		assert.NotNil(t, snap.FinishedAt)
+
+		_, snap = get(t, r, failed.ID())
+		assert.Equal(t, StateFailed, snap.State)
+		assert.Equal(t, "backend unavailable", snap.Error)
+		assert.Equal(t, 3, snap.Attempts)
+	})
+
+	t.Run("should answer 404 for unknown jobs", func(t *testing.T) {
+		r := NewRegistry(time.Hour)
+		status, _ := get(t, r, "unknown")
+		assert.Equal(t, http.StatusNotFound, status)
+
+		j := r.Create(OpUpload, "vod/a.ts")
+		r.Remove(j.ID())
+		status, _ = get(t, r, j.ID())
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("should reject other methods", func(t *testing.T) {
+		r := NewRegistry(time.Hour)
+		j := r.Create(OpUpload, "vod/a.ts")
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/_jobs/"+j.ID(), nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+
+	t.Run("should forget finished jobs after the retention period", func(t *testing.T) {
+		r := NewRegistry(time.Millisecond)
+		j := r.Create(OpUpload, "vod/a.ts")
+		j.Finish(nil)
+		running := r.Create(OpUpload, "vod/b.ts")
+
+		r.mu.Lock()
+		r.sweep(time.Now().Add(2 * sweepInterval))
+		r.mu.Unlock()
+
+		_, ok := r.Get(j.ID())
+		assert.False(t, ok)
+		_, ok = r.Get(running.ID())
+		assert.True(t, ok)
+	})
+}
+
+func TestJobWait(t *testing.T) {
+	t.Run("should return the outcome once the job finishes", func(t *testing.T) {
+		j := NewRegistry(time.Hour).Create(OpUpload, "vod/a.ts")
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			j.Finish(errors.New("backend unavailable"))
+		}()
+		assert.EqualError(t, j.Wait(context.Background()), "backend unavailable")
+	})
+
+	t.Run("should stop waiting when the context is done", func(t *testing.T) {
+		j := NewRegistry(time.Hour).Create(OpUpload, "vod/a.ts")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, j.Wait(ctx), context.DeadlineExceeded)
+	})
+}
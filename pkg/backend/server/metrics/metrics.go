@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+const namespace = "storage"
+
+// Metrics holds the Prometheus collectors exposed by the server.
+type Metrics struct {
+	registry *prometheus.Registry
+	backend  string
+
+	requests   *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	bytesIn    *prometheus.CounterVec
+	bytesOut   *prometheus.CounterVec
+	opDuration *prometheus.HistogramVec
+	opErrors   *prometheus.CounterVec
+}
+
+// New creates a registry with HTTP, provider and runtime metrics for the
+// named storage backend.
+func New(backend string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		backend:  backend,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by method, status and backend.",
+		}, []string{"method", "status", "backend"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method, status and backend.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "status", "backend"}),
+		bytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_bytes_total",
+			Help:      "Bytes received in HTTP request bodies.",
+		}, []string{"method", "backend"}),
+		bytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "response_bytes_total",
+			Help:      "Bytes sent in HTTP response bodies.",
+		}, []string{"method", "backend"}),
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      "operation_duration_seconds",
+			Help:      "Storage provider operation latency.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "operation"}),
+		opErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "provider",
+			Name:      "operation_errors_total",
+			Help:      "Storage provider operations that failed, excluding missing objects.",
+		}, []string{"backend", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.bytesIn,
+		m.bytesOut,
+		m.opDuration,
+		m.opErrors,
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus
+// exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records a completed HTTP request.
+func (m *Metrics) ObserveRequest(method string, status int, elapsed time.Duration, in, out int64) {
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(method, code, m.backend).Inc()
+	m.duration.WithLabelValues(method, code, m.backend).Observe(elapsed.Seconds())
+	m.bytesIn.WithLabelValues(method, m.backend).Add(float64(in))
+	m.bytesOut.WithLabelValues(method, m.backend).Add(float64(out))
+}
+
+// RegisterPool exposes the running, capacity, queued and rejection counts of a
+// worker pool.
+func (m *Metrics) RegisterPool(name string, pool *worker.Pool) {
+	labels := prometheus.Labels{"pool": name}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "worker_pool",
+			Name:        "running",
+			Help:        "Tasks currently running in the worker pool.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(pool.Running()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "worker_pool",
+			Name:        "capacity",
+			Help:        "Maximum number of concurrently running tasks.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(pool.Cap()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "worker_pool",
+			Name:        "queued",
+			Help:        "Tasks waiting for a free worker.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(pool.Queued()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "worker_pool",
+			Name:        "rejected_total",
+			Help:        "Tasks refused because the worker pool and its queue were saturated.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(pool.Rejected()) }),
+	)
+}
+
+// RegisterGauge exposes a value computed on every scrape.
+func (m *Metrics) RegisterGauge(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+func (m *Metrics) observeOperation(operation string, start time.Time, err error) {
+	m.opDuration.WithLabelValues(m.backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		m.opErrors.WithLabelValues(m.backend, operation).Inc()
+	}
+}
+
+// BackendName returns a label for a storage provider.
+func BackendName(s provider.Storage) string {
+	if named, ok := s.(fmt.Stringer); ok {
+		return named.String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/worker"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestMetrics(t *testing.T) {
+	scrape := func(t *testing.T, m *Metrics) string {
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	t.Run("should report the state of worker pools", func(t *testing.T) {
+		pool, err := worker.NewPool(2, worker.WithMaxQueued(4))
+		require.NoError(t, err)
+		defer pool.Release()
+
+		m := New("fs")
+		m.RegisterPool("upload", pool)
+
+		block := make(chan struct{})
+		defer close(block)
+		for range 2 {
+			require.NoError(t, pool.Submit(func() { <-block }))
+		}
+		// The third task waits for a free worker
+		go func() { _ = pool.Submit(func() { <-block }) }()
+		require.Eventually(t, func() bool { return pool.Queued() == 1 }, time.Second, time.Millisecond)
+
+		body := scrape(t, m)
+		assert.Contains(t, body, `storage_worker_pool_capacity{pool="upload"} 2`)
+		assert.Contains(t, body, `storage_worker_pool_running{pool="upload"} 2`)
+		assert.Contains(t, body, `storage_worker_pool_queued{pool="upload"} 1`)
+		assert.Contains(t, body, `storage_worker_pool_rejected_total{pool="upload"} 0`)
+	})
+
+	t.Run("should observe provider operations", func(t *testing.T) {
+		m := New("fs")
+		s := m.InstrumentStorage(fs.NewStorage(fs.Config{Root: t.TempDir()}))
+		ctx := context.Background()
+
+		require.NoError(t, s.Save(ctx, strings.NewReader("segment"), "vod/a.ts"))
+		_, err := s.Stat(ctx, "vod/missing.ts")
+		require.Error(t, err)
+
+		body := scrape(t, m)
+		assert.Contains(t, body, `storage_provider_operation_duration_seconds_count{backend="fs",operation="save"} 1`)
+		assert.Contains(t, body, `storage_provider_operation_duration_seconds_count{backend="fs",operation="stat"} 1`)
+		assert.NotContains(t, body, `storage_provider_operation_errors_total{backend="fs",operation="stat"}`)
+	})
+
+	t.Run("should expose registered gauges", func(t *testing.T) {
+		m := New("fs")
+		m.RegisterGauge("active_uploads", "Uploads in progress.", func() float64 { return 3 })
+		assert.Contains(t, scrape(t, m), "storage_active_uploads 3")
+	})
+}
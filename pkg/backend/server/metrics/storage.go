@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// instrumentedStorage records latency and errors of every provider call.
+type instrumentedStorage struct {
+	provider.Storage
+	metrics *Metrics
+}
+
+// InstrumentStorage wraps s so its operations are observed by m.
+func (m *Metrics) InstrumentStorage(s provider.Storage) provider.Storage {
+	return &instrumentedStorage{Storage: s, metrics: m}
+}
+
+func (s *instrumentedStorage) Save(ctx context.Context, content io.Reader, path string) error {
+	start := time.Now()
+	err := s.Storage.Save(ctx, content, path)
+	s.metrics.observeOperation("save", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Stat(ctx context.Context, path string) (*provider.Stat, error) {
+	start := time.Now()
+	stat, err := s.Storage.Stat(ctx, path)
+	s.metrics.observeOperation("stat", start, err)
+	return stat, err
+}
+
+func (s *instrumentedStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := s.Storage.Open(ctx, path)
+	s.metrics.observeOperation("open", start, err)
+	return rc, err
+}
+
+func (s *instrumentedStorage) Delete(ctx context.Context, path string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, path)
+	s.metrics.observeOperation("delete", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) List(ctx context.Context, path string) ([]*provider.Stat, error) {
+	start := time.Now()
+	stats, err := s.Storage.List(ctx, path)
+	s.metrics.observeOperation("list", start, err)
+	return stats, err
+}
+
+func (s *instrumentedStorage) String() string {
+	return BackendName(s.Storage)
+}
+
+// Unwrap returns the wrapped storage.
+func (s *instrumentedStorage) Unwrap() provider.Storage {
+	return s.Storage
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+)
+
+// AdminTokenHeader carries the admin token on requests to the admin API.
+const AdminTokenHeader = "X-Admin-Token"
+
+var (
+	errAdminToken    = errors.New("missing or invalid admin token")
+	errAdminDisabled = errors.New("admin API requires a policy or an admin token")
+)
+
+// AdminMiddleware guards the admin API. With a token, requests must send it
+// in the X-Admin-Token header. Without one, requests are only served when an
+// enforced policy decides who may reach the admin paths; otherwise anyone
+// could replay or discard operations.
+func AdminMiddleware(token string, policyEnforced bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case token != "":
+				if subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(token)) != 1 {
+					utils.WriteError(w, "Admin token required", http.StatusUnauthorized, errAdminToken)
+					return
+				}
+			case !policyEnforced:
+				utils.WriteError(w, "Access denied", http.StatusForbidden, errAdminDisabled)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(h http.Handler, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/_admin/deadletter", nil)
+		if token != "" {
+			req.Header.Set(AdminTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("should deny everyone without a policy or token", func(t *testing.T) {
+		h := AdminMiddleware("", false)(ok)
+		assert.Equal(t, http.StatusForbidden, do(h, ""))
+		assert.Equal(t, http.StatusForbidden, do(h, "secret"))
+	})
+
+	t.Run("should leave access to an enforced policy", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(AdminMiddleware("", true)(ok), ""))
+	})
+
+	t.Run("should require the admin token when one is set", func(t *testing.T) {
+		for _, enforced := range []bool{false, true} {
+			h := AdminMiddleware("secret", enforced)(ok)
+			assert.Equal(t, http.StatusUnauthorized, do(h, ""))
+			assert.Equal(t, http.StatusUnauthorized, do(h, "wrong"))
+			assert.Equal(t, http.StatusOK, do(h, "secret"))
+		}
+	})
+}
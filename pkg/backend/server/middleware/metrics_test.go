@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/metrics"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	scrape := func(t *testing.T, m *metrics.Metrics) string {
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	t.Run("should count requests by method, status and backend", func(t *testing.T) {
+		m := metrics.New("fs")
+		h := MetricsMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			_, _ = io.WriteString(w, "segment")
+		}))
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/vod/a.ts", strings.NewReader("upload")))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/vod/a.ts", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/vod/a.ts", nil))
+
+		body := scrape(t, m)
+		assert.Contains(t, body, `storage_http_requests_total{backend="fs",method="PUT",status="201"} 1`)
+		assert.Contains(t, body, `storage_http_requests_total{backend="fs",method="GET",status="200"} 2`)
+		assert.Contains(t, body, `storage_http_request_duration_seconds_count{backend="fs",method="GET",status="200"} 2`)
+		assert.Contains(t, body, `storage_http_request_bytes_total{backend="fs",method="PUT"} 6`)
+		assert.Contains(t, body, `storage_http_response_bytes_total{backend="fs",method="GET"} 14`)
+	})
+
+	t.Run("should keep streaming responses flushable", func(t *testing.T) {
+		m := metrics.New("fs")
+		h := MetricsMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := w.(http.Flusher)
+			assert.True(t, ok)
+		}))
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/_events", nil))
+		assert.Contains(t, scrape(t, m), `storage_http_requests_total{backend="fs",method="GET",status="200"} 1`)
+	})
+}
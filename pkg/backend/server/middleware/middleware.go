@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/veloxpack/storage/pkg/backend/server/utils"
@@ -19,12 +20,43 @@ func ChainMiddleware(h http.Handler, middlewares ...func(http.Handler) http.Hand
 
 type pathContextKey string
 
-const ValidatedPathContextKey pathContextKey = "validatedPath"
+const (
+	ValidatedPathContextKey pathContextKey = "validatedPath"
+	tenantContextKey        pathContextKey = "tenant"
+)
 
 func GetValidatedPath(ctx context.Context) string {
 	return ctx.Value(ValidatedPathContextKey).(string)
 }
 
+// GetTenant returns the tenant a request belongs to: the principal's "tenant"
+// claim when present, otherwise the first segment of the storage path.
+func GetTenant(ctx context.Context) string {
+	path, _ := ctx.Value(ValidatedPathContextKey).(string)
+	return TenantOf(ctx, path)
+}
+
+// TenantOf returns the tenant owning path for the principal of ctx: the
+// tenant set by WithTenant, its "tenant" claim when present, otherwise the
+// first segment of path.
+func TenantOf(ctx context.Context, path string) string {
+	if tenant, ok := ctx.Value(tenantContextKey).(string); ok {
+		return tenant
+	}
+	if tenant := GetClaims(ctx)["tenant"]; tenant != "" {
+		return tenant
+	}
+	tenant, _, _ := strings.Cut(path, "/")
+	return tenant
+}
+
+// WithTenant attributes operations under ctx to tenant, regardless of the
+// principal making the request. Work continued on behalf of another request,
+// such as a resumable upload, keeps the tenant that started it.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
 func PathValidationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path, err := utils.SanitizePath(r.URL.Path)
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/policy"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"go.uber.org/zap"
+)
+
+var errDenied = errors.New("request denied by policy")
+
+type claimsContextKey string
+
+const ClaimsContextKey claimsContextKey = "claims"
+
+// GetClaims returns the claims of the authenticated principal, or nil for
+// anonymous requests.
+func GetClaims(ctx context.Context) map[string]string {
+	claims, _ := ctx.Value(ClaimsContextKey).(map[string]string)
+	return claims
+}
+
+// PolicyMiddleware enforces engine on every request. In dry-run mode denials
+// are only logged so a new policy can be audited against live traffic.
+func PolicyMiddleware(engine *policy.Engine, dryRun bool) func(http.Handler) http.Handler {
+	authorize := PolicyAuthorizer(engine, dryRun)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _ := utils.ParseBearerToken(r)
+			if claims, _ := engine.Principal(token); claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), ClaimsContextKey, claims))
+			}
+
+			if !authorize(r, r.Method, resourcePath(r)) {
+				utils.WriteError(w, "Access denied", http.StatusForbidden, errDenied)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PrincipalMiddleware resolves the principal of requests to handlers that
+// authorize requests themselves. Clients limited to Basic authentication,
+// such as WebDAV mounts, can send the token as the password.
+func PrincipalMiddleware(engine *policy.Engine) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _ := utils.ParseBearerToken(r)
+			if _, password, ok := r.BasicAuth(); ok && token == "" {
+				token = password
+			}
+			if claims, _ := engine.Principal(token); claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), ClaimsContextKey, claims))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authorizer reports whether the caller of r may perform method on path.
+// Handlers use it for storage paths that are not part of the request URL.
+type Authorizer func(r *http.Request, method, path string) bool
+
+// PolicyAuthorizer returns an Authorizer evaluating engine with the principal
+// resolved by PolicyMiddleware. In dry-run mode denials are only logged.
+func PolicyAuthorizer(engine *policy.Engine, dryRun bool) Authorizer {
+	logger := zap.L().Named("policy")
+
+	return func(r *http.Request, method, path string) bool {
+		claims := GetClaims(r.Context())
+		req := &policy.Request{
+			Method:   method,
+			Path:     path,
+			ClientIP: utils.ClientIP(r),
+			Claims:   claims,
+			Referer:  r.Referer(),
+			Time:     time.Now(),
+		}
+
+		decision := engine.Evaluate(req)
+		if decision.Allowed {
+			return true
+		}
+
+		fields := []zap.Field{
+			zap.String("method", req.Method),
+			zap.String("path", req.Path),
+			zap.Stringer("client_ip", req.ClientIP),
+			zap.String("sid", decision.Sid),
+			zap.String("subject", claims["sub"]),
+		}
+		if dryRun {
+			logger.Info("Policy would deny request", fields...)
+			return true
+		}
+		logger.Warn("Policy denied request", fields...)
+		return false
+	}
+}
+
+// AllowAll is the Authorizer used when no policy is configured.
+func AllowAll(r *http.Request, method, path string) bool {
+	return true
+}
+
+// resourcePath returns the validated storage path when available so rules
+// are written against the same keys the handlers use.
+func resourcePath(r *http.Request) string {
+	if path, ok := r.Context().Value(ValidatedPathContextKey).(string); ok {
+		return path
+	}
+	return strings.TrimPrefix(r.URL.Path, "/")
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/policy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPolicyMiddleware(t *testing.T) {
+	engine, err := policy.New(&policy.Document{
+		Principals: map[string]map[string]string{
+			"acme-token": {"sub": "uploader", "tenant": "acme"},
+		},
+		Statements: []policy.Statement{
+			{Sid: "public-read", Effect: policy.Allow, Methods: []string{http.MethodGet}, Paths: []string{"vod/**"}},
+			{Sid: "tenant-write", Effect: policy.Allow, Methods: []string{http.MethodPut}, Paths: []string{"acme/**"}, Claims: map[string]string{"tenant": "acme"}},
+		},
+	})
+	require.NoError(t, err)
+
+	observe := func(t *testing.T) *observer.ObservedLogs {
+		core, logs := observer.New(zapcore.DebugLevel)
+		t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+		return logs
+	}
+
+	do := func(method, path, token string) (int, map[string]string) {
+		var claims map[string]string
+		h := PolicyMiddleware(engine, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims = GetClaims(r.Context())
+		}))
+		req := httptest.NewRequest(method, "/"+path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), ValidatedPathContextKey, path))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code, claims
+	}
+
+	t.Run("should allow requests granted by a statement", func(t *testing.T) {
+		logs := observe(t)
+
+		status, claims := do(http.MethodGet, "vod/a.ts", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, claims)
+
+		status, claims = do(http.MethodPut, "acme/a.ts", "acme-token")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "acme", claims["tenant"])
+		assert.Zero(t, logs.Len())
+	})
+
+	t.Run("should deny other requests and log them as warnings", func(t *testing.T) {
+		logs := observe(t)
+
+		status, _ := do(http.MethodPut, "acme/a.ts", "")
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = do(http.MethodDelete, "vod/a.ts", "acme-token")
+		assert.Equal(t, http.StatusForbidden, status)
+
+		entries := logs.FilterMessage("Policy denied request").All()
+		require.Len(t, entries, 2)
+		assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+		assert.Equal(t, "uploader", entries[1].ContextMap()["subject"])
+	})
+
+	t.Run("should only log denials in dry-run mode", func(t *testing.T) {
+		logs := observe(t)
+		h := PolicyMiddleware(engine, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodDelete, "/vod/a.ts", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		entries := logs.FilterMessage("Policy would deny request").All()
+		require.Len(t, entries, 1)
+		assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+		assert.Equal(t, "vod/a.ts", entries[0].ContextMap()["path"])
+	})
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/ratelimit"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+)
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitMiddleware applies per-client request, bandwidth and concurrency
+// limits. It must run after PolicyMiddleware when keying on tenants so the
+// principal's claims are available.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := clientKey(r, limiter.KeyBy())
+
+			release, res, ok := limiter.Acquire(key)
+			writeRateLimitHeaders(w, res)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				utils.WriteError(w, "Too many requests", http.StatusTooManyRequests, errRateLimited)
+				return
+			}
+			defer release()
+
+			if limiter.ThrottlesBytes() {
+				ctx := r.Context()
+				if r.Body != nil {
+					r.Body = &throttledBody{ReadCloser: r.Body, wait: func(n int) error {
+						return limiter.WaitBytes(ctx, key, n)
+					}}
+				}
+				w = &throttledWriter{ResponseWriter: w, wait: func(n int) error {
+					return limiter.WaitBytes(ctx, key, n)
+				}}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientKey(r *http.Request, keyBy ratelimit.KeyBy) string {
+	switch keyBy {
+	case ratelimit.KeyByToken:
+		if token, err := utils.ParseBearerToken(r); err == nil {
+			// Avoid keeping raw credentials in memory longer than needed
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + hex.EncodeToString(sum[:8])
+		}
+	case ratelimit.KeyByTenant:
+		if tenant := GetTenant(r.Context()); tenant != "" {
+			return "tenant:" + tenant
+		}
+	}
+	return "ip:" + utils.ClientIP(r).String()
+}
+
+func writeRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	if res.Limit <= 0 {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	wait func(n int) error
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	wait func(n int) error
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	if err := tw.wait(len(p)); err != nil {
+		return 0, err
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+func (tw *throttledWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/veloxpack/storage/pkg/backend/server/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(h http.Handler, remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/vod/a.ts", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("should answer 429 with Retry-After once the limit is reached", func(t *testing.T) {
+		h := RateLimitMiddleware(ratelimit.New(ratelimit.Config{RequestsPerSecond: 1, Burst: 2}))(ok)
+
+		rec := do(h, "192.0.2.1:1234", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, http.StatusOK, do(h, "192.0.2.1:1234", "").Code)
+
+		rec = do(h, "192.0.2.1:1234", "")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+		assert.Equal(t, http.StatusOK, do(h, "192.0.2.2:1234", "").Code)
+	})
+
+	t.Run("should reject requests over the concurrency limit", func(t *testing.T) {
+		limiter := ratelimit.New(ratelimit.Config{MaxConcurrent: 1})
+		var inner *httptest.ResponseRecorder
+		h := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inner = do(RateLimitMiddleware(limiter)(ok), r.RemoteAddr, "")
+		}))
+
+		assert.Equal(t, http.StatusOK, do(h, "192.0.2.1:1234", "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, inner.Code)
+		assert.Equal(t, "1", inner.Header().Get("Retry-After"))
+		assert.Empty(t, inner.Header().Get("RateLimit-Limit"))
+	})
+
+	t.Run("should key clients by bearer token", func(t *testing.T) {
+		h := RateLimitMiddleware(ratelimit.New(ratelimit.Config{KeyBy: ratelimit.KeyByToken, RequestsPerSecond: 1, Burst: 1}))(ok)
+
+		assert.Equal(t, http.StatusOK, do(h, "192.0.2.1:1234", "alice").Code)
+		assert.Equal(t, http.StatusTooManyRequests, do(h, "192.0.2.2:1234", "alice").Code)
+		assert.Equal(t, http.StatusOK, do(h, "192.0.2.1:1234", "bob").Code)
+	})
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	t.Run("should continue the trace of the caller", func(t *testing.T) {
+		var child trace.SpanContext
+		h := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, span := tracing.Start(r.Context(), "storage.open")
+			child = span.SpanContext()
+			span.End()
+			w.WriteHeader(http.StatusNotFound)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/vod/a.ts", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		var server sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if span.Name() == "HTTP GET" {
+				server = span
+			}
+		}
+		require.NotNil(t, server)
+		assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+		assert.Contains(t, server.Attributes(), attribute.String("url.path", "/vod/a.ts"))
+		assert.Contains(t, server.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+		assert.Equal(t, codes.Unset, server.Status().Code)
+
+		assert.Equal(t, server.SpanContext().TraceID(), child.TraceID())
+	})
+
+	t.Run("should mark server errors", func(t *testing.T) {
+		h := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/vod/a.ts", nil))
+
+		spans := recorder.Ended()
+		server := spans[len(spans)-1]
+		assert.Equal(t, "HTTP PUT", server.Name())
+		assert.False(t, server.Parent().IsValid())
+		assert.Equal(t, codes.Error, server.Status().Code)
+	})
+}
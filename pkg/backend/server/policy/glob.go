@@ -0,0 +1,81 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// glob is a compiled wildcard pattern.
+//
+// When a separator is set, "*" and "?" stay within a single segment and "**"
+// spans segments, so "vod/**" matches everything below vod/. Without a
+// separator "*" matches any run of characters.
+type glob struct {
+	re *regexp.Regexp
+}
+
+func compileGlob(pattern string, sep byte) (*glob, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	any, one := ".*", "."
+	if sep != 0 {
+		class := regexp.QuoteMeta(string(sep))
+		any, one = "[^"+class+"]*", "[^"+class+"]"
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && sep != 0 && i+1 < len(pattern) && pattern[i+1] == '*':
+			i++
+			// "**/" also matches zero segments
+			if i+1 < len(pattern) && pattern[i+1] == sep {
+				i++
+				b.WriteString("(?:.*" + regexp.QuoteMeta(string(sep)) + ")?")
+			} else {
+				b.WriteString(".*")
+			}
+		case c == '*':
+			b.WriteString(any)
+		case c == '?':
+			b.WriteString(one)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return &glob{re: re}, nil
+}
+
+func (g *glob) match(s string) bool {
+	return g.re.MatchString(s)
+}
+
+// PathGlob is a path pattern in the syntax of statement paths, for handlers
+// filtering paths with the same globs.
+type PathGlob struct {
+	g *glob
+}
+
+// CompilePathGlob compiles a path pattern where "*" stays within a segment
+// and "**" spans segments.
+func CompilePathGlob(pattern string) (*PathGlob, error) {
+	g, err := compileGlob(pattern, '/')
+	if err != nil {
+		return nil, err
+	}
+	return &PathGlob{g: g}, nil
+}
+
+// Match reports whether path matches the pattern.
+func (p *PathGlob) Match(path string) bool {
+	return p.g.match(path)
+}
@@ -0,0 +1,324 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Effect is the outcome of a matching statement.
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Document is the on-disk representation of an access policy.
+//
+// Statements are evaluated the same way bucket policies are: an explicit
+// deny always wins, otherwise an explicit allow grants access, otherwise the
+// default effect applies.
+type Document struct {
+	Version       string                       `json:"version"`
+	DefaultEffect Effect                       `json:"default_effect"`
+	Principals    map[string]map[string]string `json:"principals"`
+	Statements    []Statement                  `json:"statements"`
+}
+
+// Statement is a single allow or deny rule. Every non-empty condition must
+// match for the statement to apply.
+type Statement struct {
+	Sid       string            `json:"sid"`
+	Effect    Effect            `json:"effect"`
+	Methods   []string          `json:"methods"`
+	Paths     []string          `json:"paths"`
+	SourceIPs []string          `json:"source_ips"`
+	Claims    map[string]string `json:"claims"`
+	Referers  []string          `json:"referers"`
+	Time      *TimeWindow       `json:"time"`
+}
+
+// TimeWindow restricts a statement to an absolute and/or daily time range.
+// Daily bounds are "HH:MM" in UTC and may wrap around midnight.
+type TimeWindow struct {
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+	DailyStart string    `json:"daily_start"`
+	DailyEnd   string    `json:"daily_end"`
+}
+
+// Request holds the attributes of an incoming request a policy is evaluated against.
+type Request struct {
+	Method   string
+	Path     string
+	ClientIP net.IP
+	Claims   map[string]string
+	Referer  string
+	Time     time.Time
+}
+
+// Decision is the result of evaluating a request.
+type Decision struct {
+	Allowed bool
+	// Sid identifies the statement that decided the request, empty when the
+	// default effect applied.
+	Sid string
+}
+
+// Engine evaluates requests against a compiled policy document.
+type Engine struct {
+	defaultEffect Effect
+	principals    map[string]map[string]string
+	statements    []*compiledStatement
+}
+
+// Load reads and compiles a policy document from a JSON file.
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse compiles a JSON policy document.
+func Parse(data []byte) (*Engine, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	return New(&doc)
+}
+
+// New compiles a policy document.
+func New(doc *Document) (*Engine, error) {
+	e := &Engine{
+		defaultEffect: Deny,
+		principals:    doc.Principals,
+	}
+
+	switch doc.DefaultEffect {
+	case "":
+	case Allow, Deny:
+		e.defaultEffect = doc.DefaultEffect
+	default:
+		return nil, fmt.Errorf("invalid default effect: %q", doc.DefaultEffect)
+	}
+
+	for i, st := range doc.Statements {
+		cs, err := compileStatement(st)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d (%s): %w", i, st.Sid, err)
+		}
+		e.statements = append(e.statements, cs)
+	}
+
+	return e, nil
+}
+
+// Principal returns the claims configured for a bearer token.
+func (e *Engine) Principal(token string) (map[string]string, bool) {
+	if token == "" {
+		return nil, false
+	}
+	claims, ok := e.principals[token]
+	return claims, ok
+}
+
+// Evaluate returns the decision for req.
+func (e *Engine) Evaluate(req *Request) Decision {
+	var allowedBy *compiledStatement
+
+	for _, st := range e.statements {
+		if !st.matches(req) {
+			continue
+		}
+		if st.effect == Deny {
+			return Decision{Allowed: false, Sid: st.sid}
+		}
+		if allowedBy == nil {
+			allowedBy = st
+		}
+	}
+
+	if allowedBy != nil {
+		return Decision{Allowed: true, Sid: allowedBy.sid}
+	}
+
+	return Decision{Allowed: e.defaultEffect == Allow}
+}
+
+type compiledStatement struct {
+	sid        string
+	effect     Effect
+	methods    map[string]struct{}
+	paths      []*glob
+	networks   []*net.IPNet
+	claims     map[string]*glob
+	referers   []*glob
+	notBefore  time.Time
+	notAfter   time.Time
+	dailyStart int
+	dailyEnd   int
+	daily      bool
+}
+
+func compileStatement(st Statement) (*compiledStatement, error) {
+	cs := &compiledStatement{sid: st.Sid, effect: st.Effect}
+
+	if st.Effect != Allow && st.Effect != Deny {
+		return nil, fmt.Errorf("invalid effect: %q", st.Effect)
+	}
+
+	if len(st.Methods) > 0 {
+		cs.methods = make(map[string]struct{}, len(st.Methods))
+		for _, m := range st.Methods {
+			cs.methods[strings.ToUpper(m)] = struct{}{}
+		}
+	}
+
+	for _, p := range st.Paths {
+		g, err := compileGlob(strings.TrimPrefix(p, "/"), '/')
+		if err != nil {
+			return nil, err
+		}
+		cs.paths = append(cs.paths, g)
+	}
+
+	for _, cidr := range st.SourceIPs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source ip: %w", err)
+		}
+		cs.networks = append(cs.networks, network)
+	}
+
+	if len(st.Claims) > 0 {
+		cs.claims = make(map[string]*glob, len(st.Claims))
+		for k, v := range st.Claims {
+			g, err := compileGlob(v, 0)
+			if err != nil {
+				return nil, err
+			}
+			cs.claims[k] = g
+		}
+	}
+
+	for _, ref := range st.Referers {
+		g, err := compileGlob(ref, 0)
+		if err != nil {
+			return nil, err
+		}
+		cs.referers = append(cs.referers, g)
+	}
+
+	if tw := st.Time; tw != nil {
+		cs.notBefore = tw.NotBefore
+		cs.notAfter = tw.NotAfter
+		if tw.DailyStart != "" || tw.DailyEnd != "" {
+			start, err := parseClock(tw.DailyStart)
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseClock(tw.DailyEnd)
+			if err != nil {
+				return nil, err
+			}
+			cs.daily, cs.dailyStart, cs.dailyEnd = true, start, end
+		}
+	}
+
+	return cs, nil
+}
+
+func (cs *compiledStatement) matches(req *Request) bool {
+	if cs.methods != nil {
+		if _, ok := cs.methods[req.Method]; !ok {
+			return false
+		}
+	}
+
+	if len(cs.paths) > 0 && !matchAny(cs.paths, req.Path) {
+		return false
+	}
+
+	if len(cs.networks) > 0 {
+		if req.ClientIP == nil {
+			return false
+		}
+		found := false
+		for _, n := range cs.networks {
+			if n.Contains(req.ClientIP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for k, g := range cs.claims {
+		v, ok := req.Claims[k]
+		if !ok || !g.match(v) {
+			return false
+		}
+	}
+
+	if len(cs.referers) > 0 && !matchAny(cs.referers, req.Referer) {
+		return false
+	}
+
+	return cs.inWindow(req.Time)
+}
+
+func (cs *compiledStatement) inWindow(t time.Time) bool {
+	if !cs.notBefore.IsZero() && t.Before(cs.notBefore) {
+		return false
+	}
+	if !cs.notAfter.IsZero() && t.After(cs.notAfter) {
+		return false
+	}
+	if !cs.daily {
+		return true
+	}
+
+	utc := t.UTC()
+	minute := utc.Hour()*60 + utc.Minute()
+	if cs.dailyStart <= cs.dailyEnd {
+		return minute >= cs.dailyStart && minute < cs.dailyEnd
+	}
+	// Window wraps around midnight, e.g. 22:00-06:00
+	return minute >= cs.dailyStart || minute < cs.dailyEnd
+}
+
+func parseClock(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid daily time %q: %w", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func matchAny(globs []*glob, s string) bool {
+	for _, g := range globs {
+		if g.match(s) {
+			return true
+		}
+	}
+	return false
+}
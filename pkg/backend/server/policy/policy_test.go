@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `{
+	"version": "1",
+	"principals": {
+		"orchestrator-token": {"sub": "orchestrator", "role": "orchestrator"}
+	},
+	"statements": [
+		{"sid": "public-vod", "effect": "allow", "methods": ["GET"], "paths": ["vod/**"]},
+		{"sid": "orchestrator-write", "effect": "allow", "methods": ["PUT", "POST", "DELETE"], "claims": {"role": "orchestrator"}},
+		{"sid": "internal-only", "effect": "deny", "methods": ["DELETE"], "source_ips": ["0.0.0.0/0"], "paths": ["vod/protected/**"]},
+		{"sid": "player", "effect": "allow", "methods": ["GET"], "paths": ["live/*/*.m3u8"], "referers": ["https://player.example.com/*"]},
+		{"sid": "maintenance", "effect": "deny", "time": {"daily_start": "23:00", "daily_end": "01:00"}}
+	]
+}`
+
+func TestPolicy(t *testing.T) {
+	engine, err := Parse([]byte(testPolicy))
+	require.NoError(t, err)
+
+	noon := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	orchestrator, _ := engine.Principal("orchestrator-token")
+
+	t.Run("should allow public vod reads", func(t *testing.T) {
+		d := engine.Evaluate(&Request{Method: "GET", Path: "vod/abc/seg1.ts", Time: noon})
+		assert.True(t, d.Allowed)
+		assert.Equal(t, "public-vod", d.Sid)
+	})
+
+	t.Run("should deny anonymous delete by default", func(t *testing.T) {
+		d := engine.Evaluate(&Request{Method: "DELETE", Path: "vod/abc/seg1.ts", Time: noon})
+		assert.False(t, d.Allowed)
+		assert.Empty(t, d.Sid)
+	})
+
+	t.Run("should allow orchestrator delete", func(t *testing.T) {
+		d := engine.Evaluate(&Request{Method: "DELETE", Path: "vod/abc/seg1.ts", Claims: orchestrator, Time: noon})
+		assert.True(t, d.Allowed)
+	})
+
+	t.Run("should let explicit deny win over allow", func(t *testing.T) {
+		d := engine.Evaluate(&Request{
+			Method:   "DELETE",
+			Path:     "vod/protected/a/b.ts",
+			Claims:   orchestrator,
+			ClientIP: net.ParseIP("10.0.0.1"),
+			Time:     noon,
+		})
+		assert.False(t, d.Allowed)
+		assert.Equal(t, "internal-only", d.Sid)
+	})
+
+	t.Run("should keep single star within a segment", func(t *testing.T) {
+		referer := "https://player.example.com/watch"
+		d := engine.Evaluate(&Request{Method: "GET", Path: "live/abc/index.m3u8", Referer: referer, Time: noon})
+		assert.True(t, d.Allowed)
+
+		d = engine.Evaluate(&Request{Method: "GET", Path: "live/abc/x/index.m3u8", Referer: referer, Time: noon})
+		assert.False(t, d.Allowed)
+	})
+
+	t.Run("should apply daily windows across midnight", func(t *testing.T) {
+		late := time.Date(2025, 1, 1, 23, 30, 0, 0, time.UTC)
+		d := engine.Evaluate(&Request{Method: "GET", Path: "vod/abc/seg1.ts", Time: late})
+		assert.False(t, d.Allowed)
+		assert.Equal(t, "maintenance", d.Sid)
+	})
+
+	t.Run("should reject invalid effects", func(t *testing.T) {
+		_, err := Parse([]byte(`{"statements": [{"effect": "maybe"}]}`))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,229 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	entrySuffix   = ".json"
+	payloadSuffix = ".data"
+	tempSuffix    = ".tmp"
+)
+
+// Entry describes a storage operation that was acknowledged to a client but
+// has not completed yet.
+type Entry struct {
+	ID        string    `json:"id"`
+	Op        string    `json:"op"`
+	Path      string    `json:"path"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Attempts  int       `json:"attempts,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Queue is a write-ahead log of pending operations kept in a directory.
+//
+// Every entry is a metadata file plus an optional payload file. The payload
+// is written and synced first and the metadata is renamed into place last,
+// so an entry is only visible once it is complete. Entries are removed when
+// acknowledged; whatever is left after a crash is returned by Pending and
+// replayed, which gives at-least-once execution.
+type Queue struct {
+	dir string
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+// Open opens or creates the queue in dir and removes partially written entries.
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	q := &Queue{dir: dir, ids: make(map[string]struct{})}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+	for _, de := range names {
+		name := de.Name()
+		switch {
+		case strings.HasSuffix(name, tempSuffix):
+			_ = os.Remove(filepath.Join(dir, name))
+		case strings.HasSuffix(name, entrySuffix):
+			q.ids[strings.TrimSuffix(name, entrySuffix)] = struct{}{}
+		}
+	}
+
+	// Payloads without metadata belong to writes that never completed
+	for _, de := range names {
+		name := de.Name()
+		if id, ok := strings.CutSuffix(name, payloadSuffix); ok {
+			if _, exists := q.ids[id]; !exists {
+				_ = os.Remove(filepath.Join(dir, name))
+			}
+		}
+	}
+
+	return q, nil
+}
+
+// Put durably records e with payload, replacing any entry with the same id.
+func (q *Queue) Put(e *Entry, payload []byte) error {
+	if strings.ContainsAny(e.ID, `/\`) || e.ID == "" {
+		return fmt.Errorf("invalid entry id: %q", e.ID)
+	}
+
+	if payload != nil {
+		if err := writeFileSync(q.payloadPath(e.ID), payload); err != nil {
+			return fmt.Errorf("failed to write payload: %w", err)
+		}
+	}
+
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(q.entryPath(e.ID), meta); err != nil {
+		_ = os.Remove(q.payloadPath(e.ID))
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+
+	q.mu.Lock()
+	q.ids[e.ID] = struct{}{}
+	q.mu.Unlock()
+
+	return nil
+}
+
+// Ack removes a completed entry.
+func (q *Queue) Ack(id string) error {
+	q.mu.Lock()
+	delete(q.ids, id)
+	q.mu.Unlock()
+
+	// Remove the metadata first so a crash in between leaves an orphaned
+	// payload, which Open cleans up, rather than an entry without payload.
+	err := os.Remove(q.entryPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Remove(q.payloadPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Pending returns the entries that have not been acknowledged, oldest first
+// and by id among entries created at the same time.
+func (q *Queue) Pending() ([]*Entry, error) {
+	q.mu.Lock()
+	ids := make([]string, 0, len(q.ids))
+	for id := range q.ids {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(ids))
+	for _, id := range ids {
+		data, err := os.ReadFile(q.entryPath(id))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("corrupt queue entry %s: %w", id, err)
+		}
+		entries = append(entries, &e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+// Payload returns the payload stored with an entry.
+func (q *Queue) Payload(id string) ([]byte, error) {
+	data, err := os.ReadFile(q.payloadPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Len returns the number of entries that have not been acknowledged.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ids)
+}
+
+func (q *Queue) entryPath(id string) string {
+	return filepath.Join(q.dir, id+entrySuffix)
+}
+
+func (q *Queue) payloadPath(id string) string {
+	return filepath.Join(q.dir, id+payloadSuffix)
+}
+
+// writeFileSync atomically replaces path with data and syncs it to disk.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + tempSuffix
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Directory sync is not supported everywhere; the rename is still atomic
+	_ = d.Sync()
+	return nil
+}
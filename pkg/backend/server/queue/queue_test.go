@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	put := func(q *Queue, id, op, path string, payload []byte) error {
+		return q.Put(&Entry{
+			ID:        id,
+			Op:        op,
+			Path:      path,
+			Size:      int64(len(payload)),
+			CreatedAt: time.Now(),
+		}, payload)
+	}
+
+	t.Run("should replay unacknowledged entries after reopening", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := Open(dir)
+		require.NoError(t, err)
+
+		require.NoError(t, put(q, "a", "upload", "vod/a.ts", []byte("hello")))
+		require.NoError(t, put(q, "b", "delete", "vod/b.ts", nil))
+		assert.Equal(t, 2, q.Len())
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+
+		entries, err := q.Pending()
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "a", entries[0].ID)
+		assert.Equal(t, "vod/a.ts", entries[0].Path)
+		assert.Equal(t, "b", entries[1].ID)
+
+		payload, err := q.Payload("a")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(payload))
+	})
+
+	t.Run("should forget acknowledged entries", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := Open(dir)
+		require.NoError(t, err)
+
+		require.NoError(t, put(q, "a", "upload", "vod/a.ts", []byte("hello")))
+		require.NoError(t, q.Ack("a"))
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		assert.Equal(t, 0, q.Len())
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("should discard partially written entries", func(t *testing.T) {
+		dir := t.TempDir()
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "x.json.tmp"), []byte("{"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "y.data"), []byte("orphan"), 0644))
+
+		q, err := Open(dir)
+		require.NoError(t, err)
+		assert.Equal(t, 0, q.Len())
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("should reject ids that escape the queue directory", func(t *testing.T) {
+		q, err := Open(t.TempDir())
+		require.NoError(t, err)
+
+		assert.Error(t, put(q, "../evil", "upload", "vod/a.ts", nil))
+	})
+
+	t.Run("should replay entries created at the same time by id", func(t *testing.T) {
+		dir := t.TempDir()
+
+		q, err := Open(dir)
+		require.NoError(t, err)
+
+		created := time.Now()
+		for _, id := range []string{"c", "a", "d", "b"} {
+			require.NoError(t, q.Put(&Entry{ID: id, Op: "delete", Path: "vod/" + id + ".ts", CreatedAt: created}, nil))
+		}
+
+		for range 5 {
+			q, err = Open(dir)
+			require.NoError(t, err)
+
+			entries, err := q.Pending()
+			require.NoError(t, err)
+			var ids []string
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, []string{"a", "b", "c", "d"}, ids)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// KeyBy selects which attribute of a request identifies a client.
+type KeyBy string
+
+const (
+	KeyByIP     KeyBy = "ip"
+	KeyByToken  KeyBy = "token"
+	KeyByTenant KeyBy = "tenant"
+)
+
+const (
+	idleTimeout   = 10 * time.Minute
+	sweepInterval = time.Minute
+	minByteBurst  = 64 * 1024
+	// holdWeight is the inverse weight of each request in the moving
+	// average of how long requests hold a concurrency slot.
+	holdWeight = 8
+)
+
+// Config describes the limits applied to every client key. A zero value
+// disables the corresponding limit.
+type Config struct {
+	KeyBy             KeyBy
+	RequestsPerSecond float64
+	Burst             int
+	BytesPerSecond    int64
+	MaxConcurrent     int
+}
+
+// Result describes the state of a client's request bucket after a call to Acquire.
+type Result struct {
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type client struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	active   int
+	hold     time.Duration
+	lastSeen time.Time
+}
+
+// Limiter tracks token buckets and in-flight requests per client key.
+type Limiter struct {
+	cfg       Config
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// New creates a Limiter for cfg.
+func New(cfg Config) *Limiter {
+	if cfg.KeyBy == "" {
+		cfg.KeyBy = KeyByIP
+	}
+	if cfg.RequestsPerSecond > 0 && cfg.Burst <= 0 {
+		cfg.Burst = int(math.Ceil(cfg.RequestsPerSecond))
+	}
+
+	return &Limiter{
+		cfg:       cfg,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+}
+
+// KeyBy returns the attribute the limiter keys clients on.
+func (l *Limiter) KeyBy() KeyBy {
+	return l.cfg.KeyBy
+}
+
+// Acquire takes a request token and a concurrency slot for key. The returned
+// release function must be called when the request completes; it is nil when
+// the request was rejected.
+func (l *Limiter) Acquire(key string) (func(), Result, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	c := l.client(key)
+	c.lastSeen = now
+
+	res := Result{Limit: l.cfg.Burst}
+
+	if l.cfg.MaxConcurrent > 0 && c.active >= l.cfg.MaxConcurrent {
+		// A slot frees up about every hold/MaxConcurrent on average
+		res.RetryAfter = max(c.hold/time.Duration(l.cfg.MaxConcurrent), time.Second)
+		l.fillRemaining(c, now, &res)
+		return nil, res, false
+	}
+
+	if c.requests != nil {
+		reservation := c.requests.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			res.RetryAfter = delay
+			l.fillRemaining(c, now, &res)
+			return nil, res, false
+		}
+	}
+
+	l.fillRemaining(c, now, &res)
+	c.active++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			end := time.Now()
+			l.mu.Lock()
+			c.active--
+			c.lastSeen = end
+			if held := end.Sub(now); c.hold == 0 {
+				c.hold = held
+			} else {
+				c.hold += (held - c.hold) / holdWeight
+			}
+			l.mu.Unlock()
+		})
+	}
+
+	return release, res, true
+}
+
+// WaitBytes blocks until key may transfer n more bytes or ctx is done.
+func (l *Limiter) WaitBytes(ctx context.Context, key string, n int) error {
+	if l.cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	limiter := l.client(key).bytes
+	l.mu.Unlock()
+
+	burst := limiter.Burst()
+	for n > 0 {
+		chunk := min(n, burst)
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// ThrottlesBytes reports whether a bytes-per-second limit is configured.
+func (l *Limiter) ThrottlesBytes() bool {
+	return l.cfg.BytesPerSecond > 0
+}
+
+func (l *Limiter) client(key string) *client {
+	c, ok := l.clients[key]
+	if ok {
+		return c
+	}
+
+	c = &client{}
+	if l.cfg.RequestsPerSecond > 0 {
+		c.requests = rate.NewLimiter(rate.Limit(l.cfg.RequestsPerSecond), l.cfg.Burst)
+	}
+	if l.cfg.BytesPerSecond > 0 {
+		burst := int(max(l.cfg.BytesPerSecond, minByteBurst))
+		c.bytes = rate.NewLimiter(rate.Limit(l.cfg.BytesPerSecond), burst)
+	}
+	l.clients[key] = c
+	return c
+}
+
+func (l *Limiter) fillRemaining(c *client, now time.Time, res *Result) {
+	if c.requests == nil {
+		return
+	}
+
+	tokens := c.requests.TokensAt(now)
+	res.Remaining = max(int(math.Floor(tokens)), 0)
+
+	missing := float64(l.cfg.Burst) - tokens
+	if missing > 0 {
+		res.Reset = time.Duration(missing / l.cfg.RequestsPerSecond * float64(time.Second))
+	}
+}
+
+// sweep drops idle clients so the map does not grow with every address
+// that ever connected.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, c := range l.clients {
+		if c.active == 0 && now.Sub(c.lastSeen) > idleTimeout {
+			delete(l.clients, key)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("should reject requests once the bucket is empty", func(t *testing.T) {
+		l := New(Config{RequestsPerSecond: 1, Burst: 2})
+
+		release, res, ok := l.Acquire("ip:1")
+		require.True(t, ok)
+		release()
+		assert.Equal(t, 2, res.Limit)
+		assert.Equal(t, 1, res.Remaining)
+
+		release, res, ok = l.Acquire("ip:1")
+		require.True(t, ok)
+		release()
+		assert.Equal(t, 0, res.Remaining)
+		assert.InDelta(t, 2*time.Second, res.Reset, float64(100*time.Millisecond))
+
+		release, res, ok = l.Acquire("ip:1")
+		assert.False(t, ok)
+		assert.Nil(t, release)
+		assert.InDelta(t, time.Second, res.RetryAfter, float64(100*time.Millisecond))
+	})
+
+	t.Run("should default the burst to the request rate", func(t *testing.T) {
+		l := New(Config{RequestsPerSecond: 2.5})
+		assert.Equal(t, 3, l.cfg.Burst)
+		assert.Equal(t, KeyByIP, l.KeyBy())
+	})
+
+	t.Run("should keep buckets per key", func(t *testing.T) {
+		l := New(Config{RequestsPerSecond: 1, Burst: 1, MaxConcurrent: 1})
+
+		release, _, ok := l.Acquire("tenant:acme")
+		require.True(t, ok)
+		_, _, ok = l.Acquire("tenant:acme")
+		assert.False(t, ok)
+
+		other, _, ok := l.Acquire("tenant:globex")
+		assert.True(t, ok)
+		other()
+		release()
+	})
+
+	t.Run("should limit requests in flight", func(t *testing.T) {
+		l := New(Config{MaxConcurrent: 2})
+
+		first, _, ok := l.Acquire("ip:1")
+		require.True(t, ok)
+		second, _, ok := l.Acquire("ip:1")
+		require.True(t, ok)
+
+		_, res, ok := l.Acquire("ip:1")
+		assert.False(t, ok)
+		assert.Equal(t, time.Second, res.RetryAfter)
+
+		first()
+		first()
+		third, _, ok := l.Acquire("ip:1")
+		assert.True(t, ok)
+		_, _, ok = l.Acquire("ip:1")
+		assert.False(t, ok, "a slot was released twice")
+
+		second()
+		third()
+	})
+
+	t.Run("should derive the retry delay of the concurrency limit from request durations", func(t *testing.T) {
+		l := New(Config{MaxConcurrent: 2})
+		release, _, ok := l.Acquire("ip:1")
+		require.True(t, ok)
+		release()
+		l.clients["ip:1"].hold = 8 * time.Second
+
+		for range 2 {
+			_, _, ok := l.Acquire("ip:1")
+			require.True(t, ok)
+		}
+		_, res, ok := l.Acquire("ip:1")
+		assert.False(t, ok)
+		assert.Equal(t, 4*time.Second, res.RetryAfter)
+	})
+
+	t.Run("should throttle bytes per key", func(t *testing.T) {
+		l := New(Config{BytesPerSecond: 64 * 1024})
+		assert.True(t, l.ThrottlesBytes())
+		ctx := context.Background()
+
+		require.NoError(t, l.WaitBytes(ctx, "ip:1", 64*1024))
+		start := time.Now()
+		require.NoError(t, l.WaitBytes(ctx, "ip:2", 64*1024))
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		assert.Error(t, l.WaitBytes(ctx, "ip:1", 32*1024))
+	})
+
+	t.Run("should forget idle clients", func(t *testing.T) {
+		l := New(Config{RequestsPerSecond: 1})
+		release, _, _ := l.Acquire("ip:1")
+		release()
+		busy, _, _ := l.Acquire("ip:2")
+		defer busy()
+
+		l.sweep(time.Now().Add(idleTimeout + sweepInterval + time.Second))
+		assert.NotContains(t, l.clients, "ip:1")
+		assert.Contains(t, l.clients, "ip:2")
+	})
+}
@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+// Policy controls how often and how fast a failed operation is retried.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomises each backoff by up to this fraction of it.
+	Jitter float64
+}
+
+// DefaultPolicy returns a policy suitable for transient backend errors.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    5,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff returns the delay before the given retry, where attempt 1 is the
+// first retry.
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// Classifier reports whether an error is worth retrying.
+type Classifier func(err error) bool
+
+// ClassifierFor returns the retry classifier of a storage provider. Providers
+// that cannot classify their errors get DefaultClassifier.
+func ClassifierFor(s provider.Storage) Classifier {
+	if rc, ok := provider.As[provider.RetryClassifier](s); ok {
+		return rc.IsRetryable
+	}
+	return DefaultClassifier
+}
+
+// DefaultClassifier retries everything except missing objects and cancellation.
+func DefaultClassifier(err error) bool {
+	return !errors.Is(err, provider.ErrNotExist) && !errors.Is(err, context.Canceled)
+}
+
+// Do calls fn until it succeeds, fails permanently, runs out of attempts or
+// ctx is done. onRetry, if set, is called before every retry. Do returns the
+// number of attempts made and the last error.
+func Do(ctx context.Context, p Policy, retryable Classifier, fn func(ctx context.Context) error, onRetry func(attempt int, err error)) (int, error) {
+	maxAttempts := max(p.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || attempt >= maxAttempts || !retryable(err) {
+			return attempt, err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, errors.Join(err, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+func TestDo(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+	errTransient := errors.New("transient")
+
+	t.Run("should retry transient errors until success", func(t *testing.T) {
+		calls := 0
+		attempts, err := Do(context.Background(), policy, DefaultClassifier, func(ctx context.Context) error {
+			calls++
+			if calls < 2 {
+				return errTransient
+			}
+			return nil
+		}, nil)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("should give up after the maximum number of attempts", func(t *testing.T) {
+		var retried []int
+		attempts, err := Do(context.Background(), policy, DefaultClassifier, func(ctx context.Context) error {
+			return errTransient
+		}, func(attempt int, err error) {
+			retried = append(retried, attempt)
+		})
+
+		assert.ErrorIs(t, err, errTransient)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, []int{1, 2}, retried)
+	})
+
+	t.Run("should not retry permanent errors", func(t *testing.T) {
+		attempts, err := Do(context.Background(), policy, DefaultClassifier, func(ctx context.Context) error {
+			return provider.ErrNotExist
+		}, nil)
+
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	t.Run("should grow exponentially up to the maximum", func(t *testing.T) {
+		p := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+
+		assert.Equal(t, 100*time.Millisecond, p.Backoff(1))
+		assert.Equal(t, 400*time.Millisecond, p.Backoff(3))
+		assert.Equal(t, time.Second, p.Backoff(10))
+	})
+}
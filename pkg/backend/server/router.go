@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// router dispatches service endpoints such as /metrics and passes every
+// other request to the storage handler. Unlike http.ServeMux it never
+// cleans or redirects paths, so object keys reach the storage handler as sent.
+type router struct {
+	routes   map[string]http.Handler
+	prefixes []prefixRoute
+	fallback http.Handler
+}
+
+type prefixRoute struct {
+	prefix  string
+	handler http.Handler
+}
+
+func newRouter(fallback http.Handler) *router {
+	return &router{
+		routes:   make(map[string]http.Handler),
+		fallback: fallback,
+	}
+}
+
+// Handle routes requests for exactly path to h.
+func (rt *router) Handle(path string, h http.Handler) {
+	rt.routes[path] = h
+}
+
+// HandlePrefix routes requests below prefix to h.
+func (rt *router) HandlePrefix(prefix string, h http.Handler) {
+	rt.prefixes = append(rt.prefixes, prefixRoute{prefix: prefix, handler: h})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := rt.routes[r.URL.Path]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	for _, p := range rt.prefixes {
+		if strings.HasPrefix(r.URL.Path, p.prefix) {
+			p.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	rt.fallback.ServeHTTP(w, r)
+}
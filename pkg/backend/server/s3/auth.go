@@ -0,0 +1,297 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	// maxSkew is how far a request's date may be from the server's clock.
+	maxSkew = 15 * time.Minute
+	// maxPresignExpiry is the longest validity of a presigned URL.
+	maxPresignExpiry = 7 * 24 * time.Hour
+
+	amzDateFormat = "20060102T150405Z"
+)
+
+// Payload hash values announcing how the request body is signed.
+const (
+	unsignedPayload        = "UNSIGNED-PAYLOAD"
+	streamingPayload       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrail  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// emptySHA256 is the hex SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Credential is an access key accepted by the S3 API.
+type Credential struct {
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// Claims are the principal's claims evaluated by the access policy, as
+	// if they came from a bearer token. "sub" defaults to the access key.
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// LoadCredentials reads access keys from a JSON document of the form
+// {"credentials": [...]}.
+func LoadCredentials(path string) ([]Credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var doc struct {
+		Credentials []Credential `json:"credentials"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	for i, c := range doc.Credentials {
+		if c.AccessKey == "" || c.SecretKey == "" {
+			return nil, fmt.Errorf("credential %d: missing access_key or secret_key", i)
+		}
+	}
+	return doc.Credentials, nil
+}
+
+// signer holds what is needed to verify the signatures of a request and of
+// the chunks of its body.
+type signer struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string
+}
+
+// authenticate verifies the SigV4 signature of r, sent in the Authorization
+// header or as a presigned URL. Unsigned requests are anonymous and return
+// a nil credential.
+func (h *Handler) authenticate(r *http.Request) (*Credential, *signer, error) {
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), algorithm+" "):
+		return h.verifyHeader(r)
+	case query.Get("X-Amz-Algorithm") == algorithm:
+		return h.verifyPresigned(r, query)
+	case r.Header.Get("Authorization") != "" || query.Has("X-Amz-Algorithm") || query.Has("AWSAccessKeyId"):
+		return nil, nil, errUnsupportedAuth
+	}
+	return nil, nil, nil
+}
+
+func (h *Handler) verifyHeader(r *http.Request) (*Credential, *signer, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), algorithm+" "), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[k] = v
+	}
+	credential, signedHeaders, sig := fields["Credential"], fields["SignedHeaders"], fields["Signature"]
+	if credential == "" || signedHeaders == "" || sig == "" {
+		return nil, nil, errMalformedAuth
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		return nil, nil, errMalformedAuth
+	}
+	t, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return nil, nil, errMalformedAuth
+	}
+	if skew := h.now().Sub(t); skew > maxSkew || skew < -maxSkew {
+		return nil, nil, errTimeSkewed
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return nil, nil, errMissingContentSHA256
+	}
+
+	return h.verify(r, r.URL.Query(), credential, amzDate, strings.Split(signedHeaders, ";"), payloadHash, sig)
+}
+
+func (h *Handler) verifyPresigned(r *http.Request, query url.Values) (*Credential, *signer, error) {
+	amzDate := query.Get("X-Amz-Date")
+	t, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return nil, nil, errMalformedAuth
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return nil, nil, errMalformedAuth
+	}
+	now := h.now()
+	if now.Before(t.Add(-maxSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+		return nil, nil, errExpiredPresign
+	}
+
+	sig := query.Get("X-Amz-Signature")
+	signed := url.Values{}
+	for k, v := range query {
+		if k != "X-Amz-Signature" {
+			signed[k] = v
+		}
+	}
+	signedHeaders := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	return h.verify(r, signed, query.Get("X-Amz-Credential"), amzDate, signedHeaders, unsignedPayload, sig)
+}
+
+// verify checks sig against the canonical form of r.
+func (h *Handler) verify(r *http.Request, query url.Values, credential, amzDate string, signedHeaders []string, payloadHash, sig string) (*Credential, *signer, error) {
+	// <access key>/<date>/<region>/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" || !strings.HasPrefix(amzDate, parts[1]) {
+		return nil, nil, errMalformedAuth
+	}
+	if parts[2] != h.region {
+		return nil, nil, errMalformedAuth
+	}
+	cred, ok := h.credentials[parts[0]]
+	if !ok {
+		return nil, nil, errInvalidAccessKey
+	}
+	if !slices.Contains(signedHeaders, "host") {
+		return nil, nil, errMalformedAuth
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL),
+		canonicalQuery(query),
+		canonicalHeaders(r, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	s := &signer{
+		key:     signingKey(cred.SecretKey, parts[1], parts[2]),
+		amzDate: amzDate,
+		scope:   strings.Join(parts[1:], "/"),
+	}
+	s.signature = s.sign(algorithm, hashHex([]byte(canonical)))
+	if !hmac.Equal([]byte(s.signature), []byte(sig)) {
+		return nil, nil, errSignatureMismatch
+	}
+	return cred, s, nil
+}
+
+// sign returns the signature of a string to sign made of the given
+// algorithm, the request date and scope, and lines.
+func (s *signer) sign(algorithm string, lines ...string) string {
+	stringToSign := strings.Join(append([]string{algorithm, s.amzDate, s.scope}, lines...), "\n")
+	return hex.EncodeToString(hmacSHA256(s.key, stringToSign))
+}
+
+func signingKey(secret, date, region string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalURI encodes every segment of the path as S3 clients do, without
+// normalising it.
+func canonicalURI(u *url.URL) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, seg := range segments {
+		if decoded, err := url.PathUnescape(seg); err == nil {
+			seg = decoded
+		}
+		segments[i] = uriEncode(seg)
+	}
+	if uri := strings.Join(segments, "/"); uri != "" {
+		return uri
+	}
+	return "/"
+}
+
+func canonicalQuery(query url.Values) string {
+	type pair struct{ k, v string }
+	pairs := make([]pair, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, pair{uriEncode(k), uriEncode(v)})
+		}
+	}
+	// Sorted by key, then value; sorting the joined pairs would differ when
+	// one key is a prefix of another
+	slices.SortFunc(pairs, func(a, b pair) int {
+		if c := strings.Compare(a.k, b.k); c != 0 {
+			return c
+		}
+		return strings.Compare(a.v, b.v)
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.k + "=" + p.v
+	}
+	return strings.Join(encoded, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var b strings.Builder
+	for _, name := range signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			// Values shares its slice with the request headers
+			values := slices.Clone(r.Header.Values(name))
+			for i, v := range values {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// uriEncode percent-encodes everything but unreserved characters, as
+// required by SigV4.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
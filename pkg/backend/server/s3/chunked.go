@@ -0,0 +1,163 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxChunkSize bounds the chunks of an aws-chunked body, which are held
+	// in memory until their signature is verified.
+	maxChunkSize = 16 << 20
+	maxLineSize  = 4096
+
+	chunkAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// chunkedReader decodes an aws-chunked request body. With a signer, every
+// chunk is verified before its data is released, chained to the signature of
+// the previous chunk as described for SigV4 streaming uploads. Trailing
+// headers are skipped.
+type chunkedReader struct {
+	r       *bufio.Reader
+	signer  *signer
+	prevSig string
+	// expected is the decoded length announced by the client, or -1
+	expected int64
+	total    int64
+
+	chunk []byte
+	buf   []byte
+	err   error
+}
+
+func newChunkedReader(r io.Reader, s *signer, expected int64) *chunkedReader {
+	c := &chunkedReader{r: bufio.NewReader(r), signer: s, expected: expected}
+	if s != nil {
+		c.prevSig = s.signature
+	}
+	return c
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// next reads and verifies one chunk, returning io.EOF after the last one.
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+
+	if int64(cap(c.chunk)) < size {
+		c.chunk = make([]byte, size)
+	}
+	c.chunk = c.chunk[:size]
+	if _, err := io.ReadFull(c.r, c.chunk); err != nil {
+		return errIncompleteBody
+	}
+
+	if c.signer != nil {
+		sig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok {
+			return errSignatureMismatch
+		}
+		want := c.signer.sign(chunkAlgorithm, c.prevSig, emptySHA256, hashHex(c.chunk))
+		if !hmac.Equal([]byte(sig), []byte(want)) {
+			return errSignatureMismatch
+		}
+		c.prevSig = want
+	}
+
+	if size == 0 {
+		// Skip trailing headers up to the empty line ending the body
+		for {
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				break
+			}
+		}
+		if c.expected >= 0 && c.total != c.expected {
+			return errIncompleteBody
+		}
+		return io.EOF
+	}
+
+	if line, err := c.readLine(); err != nil || line != "" {
+		return errIncompleteBody
+	}
+
+	c.total += size
+	c.buf = c.chunk
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			return "", errIncompleteBody
+		}
+		line = append(line, part...)
+		if len(line) > maxLineSize {
+			return "", errIncompleteBody
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// checkedReader fails with err at the end of r unless the content hashed to
+// want.
+type checkedReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want []byte
+	err  error
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(c.hash.Sum(nil), c.want) {
+		return n, c.err
+	}
+	return n, err
+}
+
+func decodeHex(s string) ([]byte, bool) {
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+func decodeBase64(s string) ([]byte, bool) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return b, err == nil
+}
@@ -0,0 +1,367 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	maxPartNumber = 10000
+	// multipartExpiration is how long an upload may stay incomplete before
+	// its parts are removed.
+	multipartExpiration = 7 * 24 * time.Hour
+
+	uploadInfoFile = "upload.json"
+	partSuffix     = ".part"
+	tempSuffix     = ".tmp"
+)
+
+// multipartUpload describes a multipart upload in progress.
+type multipartUpload struct {
+	ID        string    `json:"id"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// part is an uploaded part of a multipart upload.
+type part struct {
+	number int
+	etag   string
+	file   string
+}
+
+// multipartStore keeps every multipart upload in a directory holding its
+// description and one file per part, named after the part number and the
+// MD5 of its content.
+type multipartStore struct {
+	dir string
+}
+
+func openMultipartStore(dir string) (*multipartStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create multipart directory: %w", err)
+	}
+	return &multipartStore{dir: dir}, nil
+}
+
+func (s *multipartStore) create(u *multipartUpload) error {
+	dir := filepath.Join(s.dir, u.ID)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, uploadInfoFile), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (s *multipartStore) get(id string) (*multipartUpload, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return nil, errNoSuchUpload
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, id, uploadInfoFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoSuchUpload
+	} else if err != nil {
+		return nil, err
+	}
+
+	var u multipartUpload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("corrupt multipart upload %s: %w", id, err)
+	}
+	return &u, nil
+}
+
+// putPart stores part number n, replacing an earlier upload of it, and
+// returns its ETag.
+func (s *multipartStore) putPart(id string, n int, r io.Reader) (string, error) {
+	dir := filepath.Join(s.dir, id)
+	f, err := os.CreateTemp(dir, fmt.Sprintf("%05d-*%s", n, tempSuffix))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	etag := hex.EncodeToString(hash.Sum(nil))
+	old, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%05d.*%s", n, partSuffix)))
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(dir, fmt.Sprintf("%05d.%s%s", n, etag, partSuffix))); err != nil {
+		return "", err
+	}
+	for _, name := range old {
+		if filepath.Base(name) != fmt.Sprintf("%05d.%s%s", n, etag, partSuffix) {
+			os.Remove(name)
+		}
+	}
+	return etag, nil
+}
+
+// parts returns the uploaded parts of an upload by number.
+func (s *multipartStore) parts(id string) (map[int]part, error) {
+	dir := filepath.Join(s.dir, id)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make(map[int]part)
+	for _, de := range entries {
+		name, ok := strings.CutSuffix(de.Name(), partSuffix)
+		if !ok {
+			continue
+		}
+		number, etag, _ := strings.Cut(name, ".")
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		parts[n] = part{number: n, etag: etag, file: filepath.Join(dir, de.Name())}
+	}
+	return parts, nil
+}
+
+func (s *multipartStore) remove(id string) error {
+	return os.RemoveAll(filepath.Join(s.dir, id))
+}
+
+// removeExpired removes uploads started before the expiration.
+func (s *multipartStore) removeExpired(now time.Time) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, de := range entries {
+		u, err := s.get(de.Name())
+		if errors.Is(err, errNoSuchUpload) {
+			continue
+		}
+		if err != nil || now.Sub(u.CreatedAt) > multipartExpiration {
+			s.remove(de.Name())
+		}
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (h *Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, name string) {
+	if !h.authorize(r, http.MethodPut, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	u := &multipartUpload{
+		ID:        newID(),
+		Bucket:    bucket,
+		Key:       key,
+		Path:      name,
+		CreatedAt: h.now(),
+	}
+	if err := h.uploads.create(u); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    xmlns,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: u.ID,
+	})
+}
+
+// upload loads the multipart upload of a request, checking it belongs to the
+// requested object.
+func (h *Handler) upload(r *http.Request, name string) (*multipartUpload, error) {
+	u, err := h.uploads.get(r.URL.Query().Get("uploadId"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Path != name {
+		return nil, errNoSuchUpload
+	}
+	return u, nil
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, s *signer, name string) {
+	if !h.authorize(r, http.MethodPut, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		h.writeError(w, r, errInvalidArgument)
+		return
+	}
+	u, err := h.upload(r, name)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	body, err := h.body(r, s)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	etag, err := h.uploads.putPart(u.ID, n, body)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// completeMultipartUpload assembles the listed parts into the object.
+func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, s *signer, bucket, key, name string) {
+	if !h.authorize(r, http.MethodPut, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+	u, err := h.upload(r, name)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	body, err := h.body(r, s)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	var req completeMultipartUpload
+	if err := readXML(body, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(req.Parts) == 0 {
+		h.writeError(w, r, errMalformedXML)
+		return
+	}
+
+	uploaded, err := h.uploads.parts(u.ID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	// The multipart ETag is the MD5 of the concatenated part digests
+	digests := md5.New()
+	readers := make([]io.Reader, 0, len(req.Parts))
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			h.writeError(w, r, errInvalidPartOrder)
+			return
+		}
+		got, ok := uploaded[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != got.etag {
+			h.writeError(w, r, errInvalidPart)
+			return
+		}
+		digest, _ := hex.DecodeString(got.etag)
+		digests.Write(digest)
+
+		f, err := os.Open(got.file)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := h.save(r.Context(), name, io.MultiReader(readers...)); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if err := h.uploads.remove(u.ID); err != nil {
+		h.logger.Warn("Failed to remove multipart upload", zap.String("id", u.ID), zap.Error(err))
+	}
+
+	h.writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    xmlns,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(digests.Sum(nil)), len(req.Parts)),
+	})
+}
+
+func (h *Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, name string) {
+	if !h.authorize(r, http.MethodPut, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+	u, err := h.upload(r, name)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if err := h.uploads.remove(u.ID); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,540 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+const (
+	maxListKeys   = 1000
+	maxDeleteKeys = 1000
+	// timeFormat is how S3 formats timestamps in XML documents.
+	timeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// listBuckets lists the top-level directories of the storage.
+func (h *Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(r, http.MethodGet, "") {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	stats, err := h.storage.List(r.Context(), "")
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		h.writeError(w, r, err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	result := listBucketsResult{Xmlns: xmlns, Buckets: []bucketEntry{}}
+	for _, st := range stats {
+		name := st.Path
+		if name == "" {
+			name = st.Name
+		}
+		bucket, _, nested := strings.Cut(name, "/")
+		if (!st.IsDir && !nested) || seen[bucket] {
+			continue
+		}
+		seen[bucket] = true
+		result.Buckets = append(result.Buckets, bucketEntry{
+			Name:         bucket,
+			CreationDate: st.ModifiedTime.UTC().Format(timeFormat),
+		})
+	}
+	slices.SortFunc(result.Buckets, func(a, b bucketEntry) int { return strings.Compare(a.Name, b.Name) })
+
+	h.writeXML(w, http.StatusOK, result)
+}
+
+func (h *Handler) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.authorize(r, http.MethodGet, bucket) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+	if _, err := h.storage.List(r.Context(), bucket); err != nil {
+		if errors.Is(err, provider.ErrNotExist) {
+			err = errNoSuchBucket
+		}
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("x-amz-bucket-region", h.region)
+	w.WriteHeader(http.StatusOK)
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+func (h *Handler) bucketLocation(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.authorize(r, http.MethodGet, bucket) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+	h.writeXML(w, http.StatusOK, locationConstraint{Xmlns: xmlns, Region: h.region})
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listObjectsResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// listEntry is an object or, with a delimiter, a common prefix.
+type listEntry struct {
+	key    string
+	stat   *provider.Stat
+	prefix bool
+}
+
+// listObjects serves ListObjectsV2 and the original ListObjects.
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	v2 := query.Get("list-type") == "2"
+
+	if !h.authorize(r, http.MethodGet, path.Join(bucket, prefix)) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	maxKeys := maxListKeys
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.writeError(w, r, errInvalidArgument)
+			return
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+
+	// Listing resumes after the marker, the continuation token or start-after
+	marker := query.Get("marker")
+	if v2 {
+		marker = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := url.QueryUnescape(token)
+			if err != nil {
+				h.writeError(w, r, errInvalidArgument)
+				return
+			}
+			marker = decoded
+		}
+	}
+
+	entries, err := h.collect(r, bucket, prefix, delimiter)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	start, _ := slices.BinarySearchFunc(entries, marker, func(e listEntry, key string) int {
+		return strings.Compare(e.key, key)
+	})
+	if start < len(entries) && entries[start].key == marker {
+		start++
+	}
+	entries = entries[start:]
+
+	encode := func(s string) string { return s }
+	encodingType := query.Get("encoding-type")
+	if encodingType == "url" {
+		encode = url.QueryEscape
+	} else {
+		encodingType = ""
+	}
+
+	result := listObjectsResult{
+		Xmlns:        xmlns,
+		Name:         bucket,
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		MaxKeys:      maxKeys,
+		EncodingType: encodingType,
+		IsTruncated:  len(entries) > maxKeys,
+	}
+	entries = entries[:min(len(entries), maxKeys)]
+
+	for _, e := range entries {
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(e.key)})
+			continue
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          encode(e.key),
+			LastModified: e.stat.ModifiedTime.UTC().Format(timeFormat),
+			ETag:         versionETag(e.stat),
+			Size:         e.stat.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	var last string
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].key
+	}
+	if v2 {
+		keyCount := len(entries)
+		result.KeyCount = &keyCount
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = encode(query.Get("start-after"))
+		if result.IsTruncated {
+			result.NextContinuationToken = url.QueryEscape(last)
+		}
+	} else {
+		m := encode(query.Get("marker"))
+		result.Marker = &m
+		if result.IsTruncated {
+			result.NextMarker = encode(last)
+		}
+	}
+
+	h.writeXML(w, http.StatusOK, result)
+}
+
+// collect returns the objects of bucket whose keys start with prefix, sorted
+// by key. With a delimiter, keys containing it after the prefix are rolled
+// up into common prefixes.
+func (h *Handler) collect(r *http.Request, bucket, prefix, delimiter string) ([]listEntry, error) {
+	ctx := r.Context()
+
+	// Start from the deepest directory containing every matching key
+	dir := bucket
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir = path.Join(bucket, prefix[:i])
+	}
+
+	type found struct {
+		key  string
+		stat *provider.Stat
+	}
+	var objects []found
+	add := func(name string, st *provider.Stat) {
+		key := strings.TrimPrefix(name, bucket+"/")
+		if st.IsDir {
+			key += "/"
+		}
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, found{key, st})
+		}
+	}
+
+	var err error
+	if delimiter == "/" {
+		// One level is enough; subdirectories become common prefixes
+		var stats []*provider.Stat
+		stats, err = h.storage.List(ctx, dir)
+		for _, st := range stats {
+			name := st.Path
+			if name == "" {
+				name = path.Join(dir, st.Name)
+			}
+			add(name, st)
+		}
+	} else {
+		err = provider.Walk(ctx, h.storage, dir, func(name string, st *provider.Stat) error {
+			add(name, st)
+			return nil
+		})
+	}
+	if err != nil && !errors.Is(err, provider.ErrNotExist) {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	entries := make([]listEntry, 0, len(objects))
+	for _, o := range objects {
+		if delimiter != "" {
+			if i := strings.Index(o.key[len(prefix):], delimiter); i >= 0 {
+				cp := o.key[:len(prefix)+i+len(delimiter)]
+				if !seen[cp] {
+					seen[cp] = true
+					entries = append(entries, listEntry{key: cp, prefix: true})
+				}
+				continue
+			}
+		}
+		if !o.stat.IsDir {
+			entries = append(entries, listEntry{key: o.key, stat: o.stat})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b listEntry) int { return strings.Compare(a.key, b.key) })
+	return entries, nil
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, name string) {
+	if !h.authorize(r, http.MethodGet, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	ctx := r.Context()
+	st, err := h.storage.Stat(ctx, name)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if st.IsDir {
+		h.writeError(w, r, errNoSuchKey)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(st))
+	w.Header().Set("Last-Modified", st.ModifiedTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", utils.DetermineContentType(st.ContentType, name))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(st.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	reader, err := h.storage.Open(ctx, name)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	defer reader.Close()
+
+	// Seekable content gets ranges and conditional requests from net/http
+	if rs, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", st.ModifiedTime, rs)
+		return
+	}
+
+	status := http.StatusOK
+	offset, length := int64(0), st.Size
+	if header := r.Header.Get("Range"); header != "" {
+		var ok bool
+		offset, length, ok = parseRange(header, st.Size)
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", st.Size))
+			h.writeError(w, r, errInvalidRange)
+			return
+		}
+		if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, st.Size))
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, reader, length); err != nil {
+		h.logger.Debug("Failed to stream object", zap.String("path", name), zap.Error(err))
+	}
+}
+
+// parseRange parses a single byte range of an object of the given size.
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// A suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, s *signer, key, name string) {
+	if !h.authorize(r, http.MethodPut, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	body, err := h.body(r, s)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	hash := md5.New()
+	content := io.TeeReader(body, hash)
+
+	if strings.HasSuffix(key, "/") {
+		// Directory markers have nothing to store, directories are implicit
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+	} else if err := h.save(r.Context(), name, content); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, name string) {
+	if !h.authorize(r, http.MethodDelete, name) {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+
+	// Deleting a missing object succeeds, as it does on S3
+	if err := h.remove(r.Context(), name); err != nil && !errors.Is(err, provider.ErrNotExist) {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedEntry struct {
+	Key string `xml:"Key"`
+}
+
+type deleteErrorEntry struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name           `xml:"DeleteResult"`
+	Xmlns   string             `xml:"xmlns,attr"`
+	Deleted []deletedEntry     `xml:"Deleted"`
+	Errors  []deleteErrorEntry `xml:"Error"`
+}
+
+// deleteObjects deletes a batch of keys, each checked against the policy.
+func (h *Handler) deleteObjects(w http.ResponseWriter, r *http.Request, s *signer, bucket string) {
+	body, err := h.body(r, s)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	var req deleteRequest
+	if err := readXML(body, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(req.Objects) > maxDeleteKeys {
+		h.writeError(w, r, errMalformedXML)
+		return
+	}
+
+	result := deleteResult{Xmlns: xmlns}
+	for _, obj := range req.Objects {
+		name, err := utils.SanitizePath(bucket + "/" + obj.Key)
+		switch {
+		case err != nil:
+			err = errInvalidKey
+		case !h.authorize(r, http.MethodDelete, name):
+			err = errAccessDenied
+		default:
+			if err = h.remove(r.Context(), name); errors.Is(err, provider.ErrNotExist) {
+				err = nil
+			}
+		}
+
+		if err == nil {
+			if !req.Quiet {
+				result.Deleted = append(result.Deleted, deletedEntry{Key: obj.Key})
+			}
+			continue
+		}
+		var ae *apiError
+		if !errors.As(err, &ae) {
+			h.logger.Error("Failed to delete object", zap.String("path", name), zap.Error(err))
+			ae = errInternal
+		}
+		result.Errors = append(result.Errors, deleteErrorEntry{Key: obj.Key, Code: ae.Code, Message: ae.Message})
+	}
+
+	h.writeXML(w, http.StatusOK, result)
+}
@@ -0,0 +1,433 @@
+// Package s3 serves storage through a subset of the Amazon S3 REST API, so
+// tools that only speak S3 can read and write the same objects as the HTTP
+// API.
+//
+// The first path segment is the bucket and the rest the key, so bucket
+// "acme" and key "vod/a.mp4" is the storage path "acme/vod/a.mp4". Requests
+// are authenticated with SigV4, in the Authorization header or presigned
+// URLs, against locally configured access keys; unsigned requests are
+// anonymous, and refused if there are access keys unless allowed. Either
+// way, the access policy decides what a principal may do.
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.uber.org/zap"
+)
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Config configures the S3 API.
+type Config struct {
+	// Addr is the address the S3 API listens on, separately from the HTTP API.
+	Addr string
+	// Region is reported to clients asking for a bucket's location.
+	Region string
+	// Domain enables virtual-hosted style requests, where the bucket is the
+	// first label of "<bucket>.<Domain>". Path-style requests always work.
+	Domain string
+	// Credentials are the access keys accepted in signed requests. When there
+	// are any, unsigned requests are refused unless WithAnonymous allows them.
+	Credentials []Credential
+	// MultipartDir keeps the parts of multipart uploads until they complete.
+	MultipartDir string
+}
+
+// SaveFunc writes an object to path. It must leave the previous object in
+// place when reading content fails, as it does at the end of a body that does
+// not match its signature or digest.
+type SaveFunc func(ctx context.Context, path string, content io.Reader) error
+
+// RemoveFunc deletes the object at path.
+type RemoveFunc func(ctx context.Context, path string) error
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithAuthorizer checks every operation against the access policy.
+func WithAuthorizer(authorize middleware.Authorizer) Option {
+	return func(h *Handler) {
+		h.authorize = authorize
+	}
+}
+
+// WithAnonymous sets whether unsigned requests are served, leaving them to
+// the authorizer, even though access keys are configured.
+func WithAnonymous(allowed bool) Option {
+	return func(h *Handler) {
+		h.anonymous = allowed
+	}
+}
+
+// Handler serves the S3 API.
+type Handler struct {
+	storage     provider.Storage
+	save        SaveFunc
+	remove      RemoveFunc
+	authorize   middleware.Authorizer
+	anonymous   bool
+	credentials map[string]*Credential
+	region      string
+	domain      string
+	uploads     *multipartStore
+	now         func() time.Time
+
+	done   chan struct{}
+	wg     sync.WaitGroup
+	logger *zap.Logger
+}
+
+// New creates a handler reading objects from storage and writing them with
+// save and remove, so writes are ordered and published like those of the
+// HTTP API. Abandoned multipart uploads are removed in the background until
+// Close is called.
+func New(cfg Config, storage provider.Storage, save SaveFunc, remove RemoveFunc, opts ...Option) (*Handler, error) {
+	uploads, err := openMultipartStore(cfg.MultipartDir)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		storage:     storage,
+		save:        save,
+		remove:      remove,
+		authorize:   middleware.AllowAll,
+		anonymous:   len(cfg.Credentials) == 0,
+		credentials: make(map[string]*Credential),
+		region:      cfg.Region,
+		domain:      strings.ToLower(cfg.Domain),
+		uploads:     uploads,
+		now:         time.Now,
+		done:        make(chan struct{}),
+		logger:      zap.L().Named("s3"),
+	}
+	if h.region == "" {
+		h.region = "us-east-1"
+	}
+	for i := range cfg.Credentials {
+		h.credentials[cfg.Credentials[i].AccessKey] = &cfg.Credentials[i]
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.wg.Add(1)
+	go h.sweep()
+
+	return h, nil
+}
+
+// Close stops removing abandoned multipart uploads.
+func (h *Handler) Close() {
+	close(h.done)
+	h.wg.Wait()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", newID()[:16])
+
+	cred, s, err := h.authenticate(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if cred == nil && !h.anonymous {
+		h.writeError(w, r, errAccessDenied)
+		return
+	}
+	if cred != nil {
+		claims := maps.Clone(cred.Claims)
+		if claims == nil {
+			claims = make(map[string]string)
+		}
+		if claims["sub"] == "" {
+			claims["sub"] = cred.AccessKey
+		}
+		r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsContextKey, claims))
+	}
+
+	bucket, key := h.bucketAndKey(r)
+	query := r.URL.Query()
+
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			h.writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		h.listBuckets(w, r)
+	case key == "":
+		h.serveBucket(w, r, s, bucket, query)
+	default:
+		h.serveObject(w, r, s, bucket, key, query)
+	}
+}
+
+func (h *Handler) serveBucket(w http.ResponseWriter, r *http.Request, s *signer, bucket string, query url.Values) {
+	has := query.Has
+
+	switch {
+	case r.Method == http.MethodGet && has("location"):
+		h.bucketLocation(w, r, bucket)
+	case r.Method == http.MethodGet && (has("uploads") || has("versions") || has("acl") || has("policy")):
+		h.writeError(w, r, errNotImplemented)
+	case r.Method == http.MethodGet:
+		h.listObjects(w, r, bucket)
+	case r.Method == http.MethodHead:
+		h.headBucket(w, r, bucket)
+	case r.Method == http.MethodPut && len(query) == 0:
+		// Buckets are top-level directories, created with their first object
+		if !h.authorize(r, http.MethodPut, bucket) {
+			h.writeError(w, r, errAccessDenied)
+			return
+		}
+		w.Header().Set("Location", "/"+bucket)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && has("delete"):
+		h.deleteObjects(w, r, s, bucket)
+	default:
+		h.writeError(w, r, errNotImplemented)
+	}
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, s *signer, bucket, key string, query url.Values) {
+	path, err := utils.SanitizePath(bucket + "/" + key)
+	if err != nil {
+		h.writeError(w, r, errInvalidKey)
+		return
+	}
+	has := query.Has
+
+	switch {
+	case r.Method == http.MethodPut && has("uploadId"):
+		h.uploadPart(w, r, s, path)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		h.writeError(w, r, errNotImplemented)
+	case r.Method == http.MethodPut:
+		h.putObject(w, r, s, key, path)
+	case r.Method == http.MethodPost && has("uploads"):
+		h.createMultipartUpload(w, r, bucket, key, path)
+	case r.Method == http.MethodPost && has("uploadId"):
+		h.completeMultipartUpload(w, r, s, bucket, key, path)
+	case r.Method == http.MethodDelete && has("uploadId"):
+		h.abortMultipartUpload(w, r, path)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && (has("uploadId") || has("acl") || has("tagging")):
+		h.writeError(w, r, errNotImplemented)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		h.getObject(w, r, path)
+	case r.Method == http.MethodDelete:
+		h.deleteObject(w, r, path)
+	default:
+		h.writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+// bucketAndKey splits a request into bucket and key, from the host for
+// virtual-hosted style requests and from the path otherwise.
+func (h *Handler) bucketAndKey(r *http.Request) (string, string) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+
+	if h.domain != "" {
+		host := strings.ToLower(r.Host)
+		if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+			host = host[:i]
+		}
+		if bucket, ok := strings.CutSuffix(host, "."+h.domain); ok {
+			return bucket, p
+		}
+	}
+
+	bucket, key, _ := strings.Cut(p, "/")
+	return bucket, key
+}
+
+// body returns the request body decoded and verified according to how the
+// client signed it.
+func (h *Handler) body(r *http.Request, s *signer) (io.Reader, error) {
+	var body io.Reader = r.Body
+
+	expected := int64(-1)
+	if v := r.Header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errInvalidArgument
+		}
+		expected = n
+	}
+
+	switch contentSHA256 := r.Header.Get("X-Amz-Content-Sha256"); contentSHA256 {
+	case streamingPayload, streamingPayloadTrail:
+		if s == nil {
+			return nil, errAccessDenied
+		}
+		body = newChunkedReader(body, s, expected)
+	case streamingUnsignedTrail:
+		body = newChunkedReader(body, nil, expected)
+	case "", unsignedPayload:
+	default:
+		want, ok := decodeHex(contentSHA256)
+		if !ok || len(want) != sha256.Size {
+			return nil, errInvalidContentSHA256
+		}
+		body = &checkedReader{r: body, hash: sha256.New(), want: want, err: errContentSHA256Mismatch}
+	}
+
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		want, ok := decodeBase64(v)
+		if !ok || len(want) != md5.Size {
+			return nil, errInvalidDigest
+		}
+		body = &checkedReader{r: body, hash: md5.New(), want: want, err: errBadDigest}
+	}
+
+	return body, nil
+}
+
+// maxXMLBody bounds the XML documents of DeleteObjects and
+// CompleteMultipartUpload requests.
+const maxXMLBody = 2 << 20
+
+// readXML decodes the XML document of a request body. The body is read to
+// the end, so its payload hash and digest are checked before it is used.
+func readXML(body io.Reader, v any) error {
+	data, err := io.ReadAll(io.LimitReader(body, maxXMLBody+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxXMLBody || xml.Unmarshal(data, v) != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+func (h *Handler) sweep() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.uploads.removeExpired(h.now())
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// apiError is an S3 error response.
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied          = &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errInvalidAccessKey      = &apiError{"InvalidAccessKeyId", "The access key does not exist", http.StatusForbidden}
+	errSignatureMismatch     = &apiError{"SignatureDoesNotMatch", "The request signature does not match", http.StatusForbidden}
+	errTimeSkewed            = &apiError{"RequestTimeTooSkewed", "The request time is too far from the server time", http.StatusForbidden}
+	errExpiredPresign        = &apiError{"AccessDenied", "Request has expired", http.StatusForbidden}
+	errMalformedAuth         = &apiError{"AuthorizationHeaderMalformed", "The authorization is malformed", http.StatusBadRequest}
+	errUnsupportedAuth       = &apiError{"InvalidRequest", "Only AWS4-HMAC-SHA256 signatures are supported", http.StatusBadRequest}
+	errMissingContentSHA256  = &apiError{"InvalidRequest", "Missing x-amz-content-sha256", http.StatusBadRequest}
+	errInvalidContentSHA256  = &apiError{"InvalidArgument", "Invalid x-amz-content-sha256", http.StatusBadRequest}
+	errContentSHA256Mismatch = &apiError{"XAmzContentSHA256Mismatch", "The payload does not match x-amz-content-sha256", http.StatusBadRequest}
+	errInvalidDigest         = &apiError{"InvalidDigest", "Invalid Content-MD5", http.StatusBadRequest}
+	errBadDigest             = &apiError{"BadDigest", "The payload does not match Content-MD5", http.StatusBadRequest}
+	errIncompleteBody        = &apiError{"IncompleteBody", "The request body is malformed or incomplete", http.StatusBadRequest}
+	errInvalidArgument       = &apiError{"InvalidArgument", "Invalid argument", http.StatusBadRequest}
+	errInvalidKey            = &apiError{"InvalidArgument", "Invalid object key", http.StatusBadRequest}
+	errMalformedXML          = &apiError{"MalformedXML", "The XML is malformed", http.StatusBadRequest}
+	errNoSuchKey             = &apiError{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	errNoSuchBucket          = &apiError{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchUpload          = &apiError{"NoSuchUpload", "The specified upload does not exist", http.StatusNotFound}
+	errInvalidPart           = &apiError{"InvalidPart", "A part was not uploaded or its ETag does not match", http.StatusBadRequest}
+	errInvalidPartOrder      = &apiError{"InvalidPartOrder", "Parts must be listed in ascending order", http.StatusBadRequest}
+	errInvalidRange          = &apiError{"InvalidRange", "The requested range is not satisfiable", http.StatusRequestedRangeNotSatisfiable}
+	errMethodNotAllowed      = &apiError{"MethodNotAllowed", "The method is not allowed", http.StatusMethodNotAllowed}
+	errNotImplemented        = &apiError{"NotImplemented", "The operation is not supported", http.StatusNotImplemented}
+	errInternal              = &apiError{"InternalError", "Internal error", http.StatusInternalServerError}
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var ae *apiError
+	switch {
+	case errors.As(err, &ae):
+	case errors.Is(err, provider.ErrNotExist):
+		ae = errNoSuchKey
+	default:
+		h.logger.Error("Request failed",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Error(err),
+		)
+		ae = errInternal
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(ae.Status)
+		return
+	}
+	h.writeXML(w, ae.Status, errorResponse{
+		Code:      ae.Code,
+		Message:   ae.Message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("x-amz-request-id"),
+	})
+}
+
+func (h *Handler) writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// versionETag identifies a version of an object without reading it. It is
+// formatted like a multipart ETag so clients do not take it for an MD5
+// digest of the content.
+func versionETag(st *provider.Stat) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d:%d", st.Size, st.ModifiedTime.UnixNano())))
+	return `"` + hex.EncodeToString(sum[:]) + `-1"`
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
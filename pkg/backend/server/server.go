@@ -1,13 +1,35 @@
 package server
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/rs/cors"
+	"github.com/veloxpack/storage/pkg/backend/server/cache"
+	"github.com/veloxpack/storage/pkg/backend/server/dav"
+	"github.com/veloxpack/storage/pkg/backend/server/deadletter"
+	"github.com/veloxpack/storage/pkg/backend/server/events"
 	"github.com/veloxpack/storage/pkg/backend/server/handlers"
+	"github.com/veloxpack/storage/pkg/backend/server/health"
+	"github.com/veloxpack/storage/pkg/backend/server/jobs"
+	"github.com/veloxpack/storage/pkg/backend/server/metrics"
 	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/policy"
+	"github.com/veloxpack/storage/pkg/backend/server/queue"
+	"github.com/veloxpack/storage/pkg/backend/server/ratelimit"
+	"github.com/veloxpack/storage/pkg/backend/server/retry"
+	"github.com/veloxpack/storage/pkg/backend/server/s3"
+	"github.com/veloxpack/storage/pkg/backend/server/tracing"
+	"github.com/veloxpack/storage/pkg/backend/server/tus"
 	"github.com/veloxpack/storage/pkg/backend/server/worker"
 	"github.com/veloxpack/storage/pkg/storage"
+	"github.com/veloxpack/storage/pkg/storage/fs"
 	"github.com/veloxpack/storage/pkg/storage/provider"
 	"go.uber.org/zap"
 )
@@ -21,6 +43,38 @@ type ServerConfig struct {
 	Logger         *zap.Logger
 	UploadPoolSize int
 	DeletePoolSize int
+	PoolQueueSize  int
+	PoolMaxWait    time.Duration
+	LivePrefixes   []string
+	Policy         *policy.Engine
+	PolicyDryRun   bool
+	RateLimit      *ratelimit.Config
+	MetricsPath    string
+	Tracing        bool
+	MinFreeDisk    uint64
+	SyncWrites     bool
+	SyncTimeout    time.Duration
+	LiveTimeout    time.Duration
+	LiveWindow     int64
+	LiveMemory     int64
+	LiveMaxSize    int64
+	HLSReloadWait  time.Duration
+	Cache          *cache.Policy
+	JobRetention   time.Duration
+	QueueDir       string
+	Retry          retry.Policy
+	DeadLetterDir  string
+	AdminToken     string
+	Webhooks       []events.WebhookConfig
+	TusDir         string
+	TusMaxSize     int64
+	TusExpiration  time.Duration
+	S3             *s3.Config
+	DavPrefix      string
+	MaxPartSize    int64
+	ExtractEntries int
+	ExtractSize    int64
+	BatchWorkers   int
 	backend        provider.Storage
 }
 
@@ -54,6 +108,277 @@ func WithDeletePoolSize(size int) ServerOption {
 	}
 }
 
+// WithPoolQueueSize sets how many uploads and how many deletes may wait for a
+// worker before requests are rejected with 429. Zero rejects as soon as every
+// worker is busy.
+func WithPoolQueueSize(size int) ServerOption {
+	return func(cfg *ServerConfig) {
+		if size >= 0 {
+			cfg.PoolQueueSize = size
+		}
+	}
+}
+
+// WithPoolMaxWait sets how long a request waits for a worker before it is
+// rejected with 429.
+func WithPoolMaxWait(wait time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		if wait > 0 {
+			cfg.PoolMaxWait = wait
+		}
+	}
+}
+
+// WithLivePrefixes sets the path prefixes treated as live content, whose
+// writes are admitted to the worker pools before VOD writes.
+func WithLivePrefixes(prefixes []string) ServerOption {
+	return func(cfg *ServerConfig) {
+		if len(prefixes) > 0 {
+			cfg.LivePrefixes = prefixes
+		}
+	}
+}
+
+// WithPolicy sets the access policy enforced on every request.
+func WithPolicy(engine *policy.Engine) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Policy = engine
+	}
+}
+
+// WithPolicyDryRun logs policy denials instead of enforcing them.
+func WithPolicyDryRun(dryRun bool) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.PolicyDryRun = dryRun
+	}
+}
+
+// WithRateLimit enables per-client rate and concurrency limits.
+func WithRateLimit(limits ratelimit.Config) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.RateLimit = &limits
+	}
+}
+
+// WithMetricsPath sets the path Prometheus metrics are served on. An empty
+// path disables metrics.
+func WithMetricsPath(path string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.MetricsPath = path
+	}
+}
+
+// WithTracing traces requests, background tasks and provider calls using the
+// global OpenTelemetry tracer provider.
+func WithTracing(enabled bool) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Tracing = enabled
+	}
+}
+
+// WithMinFreeDisk sets the free space a filesystem backend needs to be
+// reported ready.
+func WithMinFreeDisk(bytes uint64) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.MinFreeDisk = bytes
+	}
+}
+
+// WithSyncWrites makes uploads and deletes respond only after the backend
+// operation finished. Clients can opt in per request with "Prefer: wait".
+func WithSyncWrites(enabled bool) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.SyncWrites = enabled
+	}
+}
+
+// WithSyncTimeout sets how long a synchronous write waits before answering
+// 202 Accepted with the job location.
+func WithSyncTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		if timeout > 0 {
+			cfg.SyncTimeout = timeout
+		}
+	}
+}
+
+// WithLiveWriteTimeout sets how long a reader following a chunked upload
+// may take to accept each write before it is disconnected.
+func WithLiveWriteTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		if timeout > 0 {
+			cfg.LiveTimeout = timeout
+		}
+	}
+}
+
+// WithLiveBuffer sets how much of each chunked upload is kept in memory for
+// its readers, and how much memory all of them may use together. Zero keeps
+// the defaults.
+func WithLiveBuffer(window, memory int64) ServerOption {
+	return func(cfg *ServerConfig) {
+		if window > 0 {
+			cfg.LiveWindow = window
+		}
+		if memory > 0 {
+			cfg.LiveMemory = memory
+		}
+	}
+}
+
+// WithMaxChunkedUploadSize limits the size of each chunked upload. Zero keeps
+// them unlimited.
+func WithMaxChunkedUploadSize(size int64) ServerOption {
+	return func(cfg *ServerConfig) {
+		if size > 0 {
+			cfg.LiveMaxSize = size
+		}
+	}
+}
+
+// WithBlockingReloadTimeout sets how long a Low-Latency HLS blocking
+// playlist reload may wait before failing with 503.
+func WithBlockingReloadTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		if timeout > 0 {
+			cfg.HLSReloadWait = timeout
+		}
+	}
+}
+
+// WithCachePolicy sets the caching headers of downloads. Without it, the
+// default rules of the cache package apply.
+func WithCachePolicy(p *cache.Policy) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Cache = p
+	}
+}
+
+// WithJobRetention sets how long finished jobs can be queried.
+func WithJobRetention(retention time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		if retention > 0 {
+			cfg.JobRetention = retention
+		}
+	}
+}
+
+// WithQueueDir persists acknowledged uploads and deletes in dir until they
+// complete, so they are replayed after a crash or restart.
+func WithQueueDir(dir string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.QueueDir = dir
+	}
+}
+
+// WithRetryPolicy sets how failed background uploads and deletes are retried.
+func WithRetryPolicy(policy retry.Policy) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Retry = policy
+	}
+}
+
+// WithDeadLetterDir persists operations that exhausted their retries in dir.
+// It defaults to a "deadletter" directory inside the queue directory; without
+// either, dead-lettered operations are kept in memory only.
+func WithDeadLetterDir(dir string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.DeadLetterDir = dir
+	}
+}
+
+// WithAdminToken requires token in the X-Admin-Token header of requests to
+// the admin API. Without it, the admin API is only served under an enforced
+// policy.
+func WithAdminToken(token string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.AdminToken = token
+	}
+}
+
+// WithWebhooks delivers object events to the given webhooks.
+func WithWebhooks(webhooks []events.WebhookConfig) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Webhooks = webhooks
+	}
+}
+
+// WithTusDir keeps the state of resumable uploads in dir. It defaults to a
+// "tus" directory inside the queue directory, else in the state directory of
+// a filesystem backend, else in the system temp directory.
+func WithTusDir(dir string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.TusDir = dir
+	}
+}
+
+// WithTusMaxSize limits the size of resumable uploads. Zero means no limit.
+func WithTusMaxSize(size int64) ServerOption {
+	return func(cfg *ServerConfig) {
+		if size >= 0 {
+			cfg.TusMaxSize = size
+		}
+	}
+}
+
+// WithTusExpiration sets how long a resumable upload may take before its
+// partial state is discarded.
+func WithTusExpiration(expiration time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		if expiration > 0 {
+			cfg.TusExpiration = expiration
+		}
+	}
+}
+
+// WithS3 serves the S3-compatible API on its own listener.
+func WithS3(s3Cfg s3.Config) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.S3 = &s3Cfg
+	}
+}
+
+// WithDavPrefix sets the path prefix the WebDAV interface is served below.
+func WithDavPrefix(prefix string) ServerOption {
+	return func(cfg *ServerConfig) {
+		if prefix != "" {
+			cfg.DavPrefix = prefix
+		}
+	}
+}
+
+// WithMaxPartSize limits the size of each file of a multipart/form-data
+// upload. Zero keeps the default of the upload size limit.
+func WithMaxPartSize(size int64) ServerOption {
+	return func(cfg *ServerConfig) {
+		if size > 0 {
+			cfg.MaxPartSize = size
+		}
+	}
+}
+
+// WithExtractLimits bounds the number of files and the total size extracted
+// from one uploaded archive. Zero keeps the defaults.
+func WithExtractLimits(entries int, size int64) ServerOption {
+	return func(cfg *ServerConfig) {
+		if entries > 0 {
+			cfg.ExtractEntries = entries
+		}
+		if size > 0 {
+			cfg.ExtractSize = size
+		}
+	}
+}
+
+// WithBatchConcurrency sets how many operations of a batch run at once.
+func WithBatchConcurrency(n int) ServerOption {
+	return func(cfg *ServerConfig) {
+		if n > 0 {
+			cfg.BatchWorkers = n
+		}
+	}
+}
+
 // WithBackend sets the storage backend for the server.
 func WithBackend(backend provider.Storage) ServerOption {
 	return func(cfg *ServerConfig) {
@@ -66,7 +391,16 @@ func defaultServerConfig() *ServerConfig {
 	return &ServerConfig{
 		UploadPoolSize: 1,
 		DeletePoolSize: 1,
+		PoolQueueSize:  64,
+		PoolMaxWait:    10 * time.Second,
+		LivePrefixes:   handlers.DefaultLivePrefixes,
 		HTTPAddr:       ":9500",
+		MetricsPath:    "/metrics",
+		SyncTimeout:    30 * time.Second,
+		JobRetention:   time.Hour,
+		Retry:          retry.DefaultPolicy(),
+		TusExpiration:  24 * time.Hour,
+		DavPrefix:      dav.DefaultPrefix,
 		Logger:         zap.NewNop(),
 		backend:        storage.NewStorage(),
 	}
@@ -82,39 +416,313 @@ func NewServer(options ...ServerOption) (*http.Server, error) {
 	cfg.Logger = cfg.Logger.Named("HTTP")
 	zap.ReplaceGlobals(cfg.Logger)
 
+	var m *metrics.Metrics
+	if cfg.MetricsPath != "" {
+		m = metrics.New(metrics.BackendName(cfg.backend))
+		cfg.backend = m.InstrumentStorage(cfg.backend)
+	}
+	if cfg.Tracing {
+		cfg.backend = tracing.InstrumentStorage(cfg.backend)
+	}
+	backendHealth := health.NewBackend(metrics.BackendName(cfg.backend), cfg.backend)
+	cfg.backend = backendHealth.Storage()
+
 	// Initialize worker pools
-	uploadPool, err := worker.NewPool(cfg.UploadPoolSize)
+	poolOpts := []worker.PoolOption{
+		worker.WithMaxQueued(cfg.PoolQueueSize),
+		worker.WithMaxWait(cfg.PoolMaxWait),
+	}
+	uploadPool, err := worker.NewPool(cfg.UploadPoolSize, poolOpts...)
 	if err != nil {
 		cfg.Logger.Fatal("Failed to create upload pool", zap.Error(err))
 		return nil, err
 	}
 
-	defer uploadPool.Release()
-
-	deletePool, err := worker.NewPool(cfg.DeletePoolSize)
+	deletePool, err := worker.NewPool(cfg.DeletePoolSize, poolOpts...)
 	if err != nil {
+		uploadPool.Release()
 		cfg.Logger.Fatal("Failed to create delete pool", zap.Error(err))
 		return nil, err
 	}
-	defer deletePool.Release()
 
 	// Create storage handler
-	baseHandler := handlers.NewStorageHandler(cfg.backend, uploadPool, deletePool)
-	handler := middleware.ChainMiddleware(baseHandler,
+	jobRegistry := jobs.NewRegistry(cfg.JobRetention)
+	bus := events.NewBus()
+	authorize := middleware.AllowAll
+	if cfg.Policy != nil {
+		authorize = middleware.PolicyAuthorizer(cfg.Policy, cfg.PolicyDryRun)
+	}
+	handlerOpts := []handlers.Option{
+		handlers.WithJobs(jobRegistry),
+		handlers.WithEvents(bus),
+		handlers.WithSyncWrites(cfg.SyncWrites, cfg.SyncTimeout),
+		handlers.WithLiveWriteTimeout(cfg.LiveTimeout),
+		handlers.WithLiveBuffer(cfg.LiveWindow, cfg.LiveMemory),
+		handlers.WithMaxChunkedUploadSize(cfg.LiveMaxSize),
+		handlers.WithBlockingReloadTimeout(cfg.HLSReloadWait),
+		handlers.WithCachePolicy(cfg.Cache),
+		handlers.WithRetryPolicy(cfg.Retry),
+		handlers.WithLivePrefixes(cfg.LivePrefixes),
+		handlers.WithMaxPartSize(cfg.MaxPartSize),
+		handlers.WithExtractLimits(cfg.ExtractEntries, cfg.ExtractSize),
+		handlers.WithBatchConcurrency(cfg.BatchWorkers),
+		handlers.WithAuthorizer(authorize),
+	}
+
+	var durableQueue *queue.Queue
+	if cfg.QueueDir != "" {
+		durableQueue, err = queue.Open(cfg.QueueDir)
+		if err != nil {
+			uploadPool.Release()
+			deletePool.Release()
+			cfg.Logger.Fatal("Failed to open durable queue", zap.Error(err))
+			return nil, err
+		}
+		handlerOpts = append(handlerOpts, handlers.WithQueue(durableQueue))
+	}
+
+	deadLetterDir := cfg.DeadLetterDir
+	if deadLetterDir == "" && cfg.QueueDir != "" {
+		deadLetterDir = filepath.Join(cfg.QueueDir, "deadletter")
+	}
+	deadLetters := deadletter.New()
+	if deadLetterDir != "" {
+		deadLetters, err = deadletter.Open(deadLetterDir)
+		if err != nil {
+			uploadPool.Release()
+			deletePool.Release()
+			cfg.Logger.Fatal("Failed to open dead-letter store", zap.Error(err))
+			return nil, err
+		}
+	}
+	handlerOpts = append(handlerOpts, handlers.WithDeadLetters(deadLetters))
+
+	baseHandler := handlers.NewStorageHandler(cfg.backend, uploadPool, deletePool, handlerOpts...)
+
+	tusDir := cfg.TusDir
+	local, isLocal := provider.As[*fs.Storage](cfg.backend)
+	switch {
+	case tusDir != "":
+	case cfg.QueueDir != "":
+		tusDir = filepath.Join(cfg.QueueDir, "tus")
+	case isLocal:
+		tusDir = local.StatePath("tus")
+	default:
+		tusDir = filepath.Join(os.TempDir(), "storage-tus")
+		cfg.Logger.Warn("Resumable uploads are kept in the temp directory; set a tus or queue directory to keep them across restarts",
+			zap.String("dir", tusDir))
+	}
+	tusHandler, err := tus.New(tusDir, baseHandler.SaveStream,
+		tus.WithMaxSize(cfg.TusMaxSize),
+		tus.WithExpiration(cfg.TusExpiration),
+		tus.WithAuthorizer(authorize),
+	)
+	if err != nil {
+		baseHandler.Shutdown()
+		uploadPool.Release()
+		deletePool.Release()
+		cfg.Logger.Fatal("Failed to open resumable upload directory", zap.Error(err))
+		return nil, err
+	}
+
+	var middlewares []func(http.Handler) http.Handler
+	if cfg.Tracing {
+		middlewares = append(middlewares, middleware.TracingMiddleware)
+	}
+	if m != nil {
+		middlewares = append(middlewares, middleware.MetricsMiddleware(m))
+	}
+	// The S3 API authenticates and authorizes requests itself
+	s3Middlewares := append(slices.Clone(middlewares), middleware.LoggingMiddleware)
+	middlewares = append(middlewares,
 		middleware.PathValidationMiddleware,
 		middleware.LoggingMiddleware,
 	)
+	// WebDAV methods and destinations are mapped to storage operations by
+	// the handler, which only needs the principal
+	davMiddlewares := slices.Clone(middlewares)
+	if cfg.Policy != nil {
+		middlewares = append(middlewares, middleware.PolicyMiddleware(cfg.Policy, cfg.PolicyDryRun))
+		davMiddlewares = append(davMiddlewares, middleware.PrincipalMiddleware(cfg.Policy))
+	}
+	if cfg.RateLimit != nil {
+		limiter := middleware.RateLimitMiddleware(ratelimit.New(*cfg.RateLimit))
+		middlewares = append(middlewares, limiter)
+		s3Middlewares = append(s3Middlewares, limiter)
+		davMiddlewares = append(davMiddlewares, limiter)
+	}
+	chain := func(h http.Handler) http.Handler {
+		return middleware.ChainMiddleware(h, middlewares...)
+	}
+	adminChain := func(h http.Handler) http.Handler {
+		admin := middleware.AdminMiddleware(cfg.AdminToken, cfg.Policy != nil && !cfg.PolicyDryRun)
+		return chain(admin(h))
+	}
 
-	// Enable CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-	})
+	var s3Server *http.Server
+	var s3Handler *s3.Handler
+	if cfg.S3 != nil {
+		s3Cfg := *cfg.S3
+		if s3Cfg.MultipartDir == "" && cfg.QueueDir != "" {
+			s3Cfg.MultipartDir = filepath.Join(cfg.QueueDir, "s3-multipart")
+		} else if s3Cfg.MultipartDir == "" {
+			s3Cfg.MultipartDir = filepath.Join(os.TempDir(), "storage-s3-multipart")
+		}
+
+		s3Handler, err = s3.New(s3Cfg, baseHandler.Storage(), baseHandler.SaveStream, baseHandler.Remove, s3.WithAuthorizer(authorize),
+			// Without a policy, anonymous requests would be allowed everything
+			s3.WithAnonymous(cfg.Policy != nil),
+		)
+		if err == nil {
+			s3Server = &http.Server{
+				Addr:    s3Cfg.Addr,
+				Handler: middleware.ChainMiddleware(s3Handler, s3Middlewares...),
+			}
+			err = listenAndServe(s3Server, cfg.Logger.Named("s3"))
+		}
+		if err != nil {
+			baseHandler.Shutdown()
+			tusHandler.Close()
+			uploadPool.Release()
+			deletePool.Release()
+			cfg.Logger.Fatal("Failed to start S3 API", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	checker := health.NewChecker([]*health.Backend{backendHealth}, map[string]*worker.Pool{
+		"upload": uploadPool,
+		"delete": deletePool,
+	}, cfg.MinFreeDisk)
+
+	// Service endpoints bypass path validation, policy and rate limits
+	rt := newRouter(chain(baseHandler))
+	rt.Handle("/healthz", http.HandlerFunc(checker.Healthz))
+	rt.Handle("/readyz", http.HandlerFunc(checker.Readyz))
+	rt.Handle("/debug/backends", http.HandlerFunc(checker.Backends))
+	rt.HandlePrefix(handlers.JobsPathPrefix, chain(jobRegistry))
+	rt.Handle(events.StreamPath, streamRoute(chain(events.NewStreamHandler(bus))))
+	var dispatcher *events.Dispatcher
+	if len(cfg.Webhooks) > 0 {
+		dispatcher = events.NewDispatcher(bus, cfg.Webhooks, cfg.Retry)
+		rt.Handle(handlers.WebhooksPath, adminChain(dispatcher))
+	}
+	rt.Handle(strings.TrimSuffix(tus.BasePath, "/"), chain(tusHandler))
+	rt.HandlePrefix(tus.BasePath, chain(tusHandler))
+	davHandler := dav.New(cfg.DavPrefix, baseHandler.Storage(), baseHandler.SaveStream, baseHandler.Remove, dav.WithAuthorizer(authorize))
+	davRoute := middleware.ChainMiddleware(davHandler, davMiddlewares...)
+	rt.Handle(davHandler.Prefix(), davRoute)
+	rt.HandlePrefix(davHandler.Prefix()+"/", davRoute)
+	rt.Handle(handlers.BatchPath, chain(baseHandler.Batch()))
+	rt.Handle(handlers.DeadLetterPath, adminChain(baseHandler.DeadLetters()))
+	rt.HandlePrefix(handlers.DeadLetterPath+"/", adminChain(baseHandler.DeadLetters()))
+	if m != nil {
+		m.RegisterPool("upload", uploadPool)
+		m.RegisterPool("delete", deletePool)
+		m.RegisterGauge("active_uploads", "Chunked uploads currently in progress.", func() float64 {
+			return float64(baseHandler.ActiveUploads())
+		})
+		m.RegisterGauge("live_readers", "Readers following an in-progress chunked upload.", func() float64 {
+			return float64(baseHandler.LiveReaders())
+		})
+		m.RegisterGauge("live_buffer_bytes", "Memory holding chunked uploads for their readers.", func() float64 {
+			return float64(baseHandler.LiveMemory())
+		})
+		m.RegisterGauge("waiting_writes", "Uploads and deletes waiting for an earlier operation on the same path.", func() float64 {
+			return float64(baseHandler.WaitingWrites())
+		})
+		m.RegisterGauge("deadletter_entries", "Operations that exhausted their retries.", func() float64 {
+			return float64(deadLetters.Len())
+		})
+		if durableQueue != nil {
+			m.RegisterGauge("queue_depth", "Acknowledged operations persisted but not yet completed.", func() float64 {
+				return float64(durableQueue.Len())
+			})
+		}
+		rt.Handle(cfg.MetricsPath, m.Handler())
+	}
 
 	// Setup server with middleware
 	server := &http.Server{
 		Addr:    cfg.HTTPAddr,
-		Handler: c.Handler(handler),
+		Handler: corsHandler(rt),
 	}
 
+	// Pools must outlive NewServer; release them once the server shuts down
+	server.RegisterOnShutdown(func() {
+		baseHandler.Shutdown()
+		tusHandler.Close()
+		if s3Server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			s3Server.Shutdown(ctx)
+			s3Handler.Close()
+		}
+		if dispatcher != nil {
+			dispatcher.Close()
+		}
+		uploadPool.Release()
+		deletePool.Release()
+	})
+
 	return server, nil
 }
+
+// streamRoute evaluates policies and rate limits of event stream requests
+// against "_events/<prefix>/", so access to a prefix's events can be granted
+// like access to the prefix itself, e.g. with "_events/acme/**".
+func streamRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = events.StreamPath
+		if prefix := events.StreamPrefix(r.URL.Query().Get("prefix")); prefix != "" {
+			r2.URL.Path += "/" + prefix + "/"
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// listenAndServe starts serving srv in the background once its address is
+// bound, so a taken address fails server creation.
+func listenAndServe(srv *http.Server, logger *zap.Logger) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("Server failed", zap.Error(err))
+		}
+	}()
+	logger.Info("Server started", zap.String("address", ln.Addr().String()))
+	return nil
+}
+
+// corsHandler enables CORS for h. Browsers resuming uploads need the tus
+// methods and headers, which are only allowed on the tus endpoints.
+func corsHandler(h http.Handler) http.Handler {
+	c := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+	})
+	tusCors := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodHead, http.MethodPost, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{
+			"Content-Type", "X-HTTP-Method-Override", "Tus-Resumable",
+			"Upload-Length", "Upload-Offset", "Upload-Metadata",
+		},
+		ExposedHeaders: []string{
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires",
+		},
+	})
+
+	handler, tusHandler := c.Handler(h), tusCors.Handler(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == strings.TrimSuffix(tus.BasePath, "/") || strings.HasPrefix(r.URL.Path, tus.BasePath) {
+			tusHandler.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
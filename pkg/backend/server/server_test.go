@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/policy"
+)
+
+func TestStreamRoute(t *testing.T) {
+	engine, err := policy.New(&policy.Document{
+		Statements: []policy.Statement{{
+			Effect:  policy.Allow,
+			Methods: []string{http.MethodGet},
+			Paths:   []string{"_events/acme/**"},
+		}},
+	})
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := streamRoute(middleware.PolicyMiddleware(engine, false)(ok))
+
+	for prefix, status := range map[string]int{
+		"acme/":      http.StatusOK,
+		"acme":       http.StatusOK,
+		"/acme/live": http.StatusOK,
+		"acmeevil/":  http.StatusForbidden,
+		"acme/../x":  http.StatusForbidden,
+		"":           http.StatusForbidden,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_events?prefix="+prefix, nil))
+		assert.Equal(t, status, rec.Code, "prefix %q", prefix)
+	}
+}
+
+func TestCorsHandler(t *testing.T) {
+	h := corsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	preflight := func(path, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://player.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("should allow the tus protocol on tus endpoints", func(t *testing.T) {
+		rec := preflight("/_tus/abc", http.MethodPatch, "content-type,tus-resumable,upload-offset")
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, http.MethodPatch, rec.Header().Get("Access-Control-Allow-Methods"))
+
+		rec = preflight("/_tus", http.MethodPost, "tus-resumable,upload-length,upload-metadata")
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("should keep the default policy elsewhere", func(t *testing.T) {
+		rec := preflight("/vod/a.ts", http.MethodGet, "")
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+
+		for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			rec = preflight("/vod/a.ts", method, "")
+			assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"), method)
+		}
+		rec = preflight("/_tusfoo", http.MethodPatch, "")
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+		rec = preflight("/vod/a.ts", http.MethodPost, "upload-offset")
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
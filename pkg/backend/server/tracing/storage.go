@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/veloxpack/storage/pkg/storage/provider"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// tracedStorage wraps every provider call in a span.
+type tracedStorage struct {
+	provider.Storage
+}
+
+// InstrumentStorage wraps s so each operation is traced.
+func InstrumentStorage(s provider.Storage) provider.Storage {
+	return &tracedStorage{Storage: s}
+}
+
+func (s *tracedStorage) start(ctx context.Context, op, path string) (context.Context, func(error)) {
+	ctx, span := Start(ctx, "storage."+op,
+		attribute.String("storage.operation", op),
+		attribute.String("storage.path", path),
+	)
+	return ctx, func(err error) {
+		// A missing object is an expected outcome, not a failed operation
+		if errors.Is(err, provider.ErrNotExist) {
+			span.SetAttributes(attribute.Bool("storage.not_found", true))
+			err = nil
+		}
+		End(span, err)
+	}
+}
+
+func (s *tracedStorage) Save(ctx context.Context, content io.Reader, path string) error {
+	ctx, end := s.start(ctx, "save", path)
+	err := s.Storage.Save(ctx, content, path)
+	end(err)
+	return err
+}
+
+func (s *tracedStorage) Stat(ctx context.Context, path string) (*provider.Stat, error) {
+	ctx, end := s.start(ctx, "stat", path)
+	stat, err := s.Storage.Stat(ctx, path)
+	end(err)
+	return stat, err
+}
+
+func (s *tracedStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	ctx, end := s.start(ctx, "open", path)
+	rc, err := s.Storage.Open(ctx, path)
+	end(err)
+	return rc, err
+}
+
+func (s *tracedStorage) Delete(ctx context.Context, path string) error {
+	ctx, end := s.start(ctx, "delete", path)
+	err := s.Storage.Delete(ctx, path)
+	end(err)
+	return err
+}
+
+func (s *tracedStorage) List(ctx context.Context, path string) ([]*provider.Stat, error) {
+	ctx, end := s.start(ctx, "list", path)
+	stats, err := s.Storage.List(ctx, path)
+	end(err)
+	return stats, err
+}
+
+func (s *tracedStorage) String() string {
+	if named, ok := s.Storage.(fmt.Stringer); ok {
+		return named.String()
+	}
+	return "unknown"
+}
+
+// Unwrap returns the wrapped storage.
+func (s *tracedStorage) Unwrap() provider.Storage {
+	return s.Storage
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider recording finished spans for the
+// duration of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	t.Run("should trace provider calls as children of the request span", func(t *testing.T) {
+		recorder := recordSpans(t)
+		s := InstrumentStorage(fs.NewStorage(fs.Config{Root: t.TempDir()}))
+
+		ctx, parent := Start(context.Background(), "request")
+		require.NoError(t, s.Save(ctx, strings.NewReader("segment"), "vod/a.ts"))
+		_, err := s.Stat(ctx, "vod/missing.ts")
+		require.Error(t, err)
+		parent.End()
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 3)
+
+		save, stat := spans[0], spans[1]
+		assert.Equal(t, "storage.save", save.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), save.Parent().SpanID())
+		assert.Equal(t, parent.SpanContext().TraceID(), save.SpanContext().TraceID())
+		assert.Contains(t, save.Attributes(), attribute.String("storage.path", "vod/a.ts"))
+		assert.Equal(t, codes.Unset, save.Status().Code)
+
+		// Missing objects are expected and do not fail the span
+		assert.Equal(t, "storage.stat", stat.Name())
+		assert.Contains(t, stat.Attributes(), attribute.Bool("storage.not_found", true))
+		assert.Equal(t, codes.Unset, stat.Status().Code)
+	})
+
+	t.Run("should mark failed operations", func(t *testing.T) {
+		recorder := recordSpans(t)
+		_, span := Start(context.Background(), "storage.save")
+		End(span, assert.AnError)
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 1)
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		require.Len(t, spans[0].Events(), 1)
+		assert.Equal(t, "exception", spans[0].Events()[0].Name)
+	})
+}
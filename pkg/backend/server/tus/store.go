@@ -0,0 +1,222 @@
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	infoSuffix = ".info"
+	dataSuffix = ".bin"
+	tempSuffix = ".tmp"
+)
+
+var errNotFound = errors.New("upload not found")
+
+// Info describes a resumable upload.
+type Info struct {
+	ID        string            `json:"id"`
+	Path      string            `json:"path"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// Completed is set once the object was assembled into the backend.
+	Completed bool `json:"completed,omitempty"`
+}
+
+// store keeps the state of uploads in a directory: a metadata file and a
+// data file holding the bytes received so far. Appends are synced before the
+// offset is reported, so an acknowledged offset survives a restart.
+type store struct {
+	dir string
+}
+
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload directory: %w", err)
+	}
+	for _, de := range names {
+		if strings.HasSuffix(de.Name(), tempSuffix) {
+			_ = os.Remove(filepath.Join(dir, de.Name()))
+		}
+	}
+
+	return &store{dir: dir}, nil
+}
+
+func (s *store) create(info *Info) error {
+	f, err := os.OpenFile(s.dataPath(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	f.Close()
+
+	if err := s.put(info); err != nil {
+		_ = os.Remove(s.dataPath(info.ID))
+		return err
+	}
+	return nil
+}
+
+// put atomically replaces the metadata of an upload.
+func (s *store) put(info *Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	path := s.infoPath(info.ID)
+	tmp := path + tempSuffix
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	return nil
+}
+
+func (s *store) get(id string) (*Info, error) {
+	if !validID(id) {
+		return nil, errNotFound
+	}
+
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("corrupt upload info %s: %w", id, err)
+	}
+	return &info, nil
+}
+
+// offset returns how many bytes of an upload were received.
+func (s *store) offset(info *Info) (int64, error) {
+	if info.Completed {
+		return info.Length, nil
+	}
+	fi, err := os.Stat(s.dataPath(info.ID))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// append writes at most limit bytes of r to the end of an upload and syncs
+// them. The bytes copied are kept even if r fails midway, as the protocol
+// allows clients to resume from whatever was stored.
+func (s *store) append(id string, r io.Reader, limit int64) (int64, error) {
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, copyErr := io.Copy(f, io.LimitReader(r, limit))
+	if err := f.Sync(); err != nil {
+		return n, err
+	}
+	return n, copyErr
+}
+
+func (s *store) open(id string) (*os.File, error) {
+	return os.Open(s.dataPath(id))
+}
+
+// discardData drops the bytes of an upload that was assembled.
+func (s *store) discardData(id string) error {
+	err := os.Remove(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// remove deletes all state of an upload. The metadata goes first so a crash
+// in between never leaves an upload that looks resumable without its data.
+func (s *store) remove(id string) error {
+	err := os.Remove(s.infoPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.discardData(id)
+}
+
+// expired returns the ids of uploads that expired before now.
+func (s *store) expired(now time.Time) ([]string, error) {
+	names, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, de := range names {
+		id, ok := strings.CutSuffix(de.Name(), infoSuffix)
+		if !ok {
+			continue
+		}
+		info, err := s.get(id)
+		if err != nil || now.After(info.ExpiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+infoSuffix)
+}
+
+func (s *store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+dataSuffix)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
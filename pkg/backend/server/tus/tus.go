@@ -0,0 +1,462 @@
+// Package tus implements resumable uploads following the tus protocol 1.0.0
+// with the creation, termination and expiration extensions.
+//
+// Received bytes are appended to a file in a local directory and synced
+// before the new offset is acknowledged, so uploads resume across restarts.
+// Once every byte arrived, the object is assembled into the backend in one
+// save.
+package tus
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/backend/server/utils"
+	"go.uber.org/zap"
+)
+
+// BasePath is where uploads are created; each upload is served below it.
+const BasePath = "/_tus/"
+
+const (
+	version    = "1.0.0"
+	extensions = "creation,termination,expiration"
+
+	offsetContentType = "application/offset+octet-stream"
+	sweepInterval     = time.Minute
+)
+
+// SaveFunc writes a completed upload to path.
+type SaveFunc func(ctx context.Context, path string, content io.Reader) error
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithMaxSize rejects uploads larger than n bytes. Zero means no limit.
+func WithMaxSize(n int64) Option {
+	return func(h *Handler) {
+		h.maxSize = max(n, 0)
+	}
+}
+
+// WithExpiration sets how long an upload may take before it is discarded.
+func WithExpiration(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.expiration = d
+		}
+	}
+}
+
+// WithAuthorizer checks that the creator of an upload may write its
+// destination path, which is only known from the upload metadata.
+func WithAuthorizer(authorize middleware.Authorizer) Option {
+	return func(h *Handler) {
+		h.authorize = authorize
+	}
+}
+
+// Handler serves resumable uploads.
+//
+//	POST   /_tus/      create an upload; metadata "path" names the destination
+//	HEAD   /_tus/{id}  report the offset to resume from
+//	PATCH  /_tus/{id}  append bytes at the current offset
+//	DELETE /_tus/{id}  abandon an upload
+type Handler struct {
+	store      *store
+	save       SaveFunc
+	maxSize    int64
+	expiration time.Duration
+	authorize  middleware.Authorizer
+
+	mu   sync.Mutex
+	busy map[string]struct{}
+
+	done   chan struct{}
+	wg     sync.WaitGroup
+	logger *zap.Logger
+}
+
+// New creates a handler keeping upload state in dir and assembling completed
+// uploads with save. Expired uploads are removed in the background until
+// Close is called.
+func New(dir string, save SaveFunc, opts ...Option) (*Handler, error) {
+	s, err := openStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		store:      s,
+		save:       save,
+		expiration: 24 * time.Hour,
+		authorize:  middleware.AllowAll,
+		busy:       make(map[string]struct{}),
+		done:       make(chan struct{}),
+		logger:     zap.L().Named("tus"),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.wg.Add(1)
+	go h.sweep()
+
+	return h, nil
+}
+
+// Close stops removing expired uploads.
+func (h *Handler) Close() {
+	close(h.done)
+	h.wg.Wait()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", version)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == http.MethodPost {
+		method = strings.ToUpper(override)
+	}
+
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", version)
+		w.Header().Set("Tus-Extension", extensions)
+		if h.maxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != version {
+		w.Header().Set("Tus-Version", version)
+		utils.WriteError(w, "Unsupported tus version", http.StatusPreconditionFailed, nil)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(BasePath, "/")), "/")
+
+	switch {
+	case id == "" && method == http.MethodPost:
+		h.create(w, r)
+	case id == "":
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	case method == http.MethodHead:
+		h.head(w, r, id)
+	case method == http.MethodPatch:
+		h.patch(w, r, id)
+	case method == http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		utils.WriteError(w, "Method not allowed", http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		utils.WriteError(w, "Invalid Upload-Length", http.StatusBadRequest, err)
+		return
+	}
+	if h.maxSize > 0 && length > h.maxSize {
+		utils.WriteError(w, "Upload too large", http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		utils.WriteError(w, "Invalid Upload-Metadata", http.StatusBadRequest, err)
+		return
+	}
+	path, err := utils.SanitizePath(metadata["path"])
+	if err != nil {
+		utils.WriteError(w, "Invalid destination path", http.StatusBadRequest, err)
+		return
+	}
+	if !h.authorize(r, http.MethodPut, path) {
+		utils.WriteError(w, "Access denied", http.StatusForbidden, nil)
+		return
+	}
+
+	now := time.Now()
+	info := &Info{
+		ID:        newID(),
+		Path:      path,
+		Length:    length,
+		Metadata:  metadata,
+		Tenant:    middleware.TenantOf(r.Context(), path),
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.expiration),
+	}
+	if err := h.store.create(info); err != nil {
+		utils.WriteError(w, "Failed to create upload", http.StatusInternalServerError, err)
+		return
+	}
+
+	if length == 0 {
+		if err := h.complete(r.Context(), info); err != nil {
+			utils.WriteError(w, "Failed to assemble upload", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	h.logger.Info("Upload created",
+		zap.String("id", info.ID),
+		zap.String("path", path),
+		zap.Int64("length", length),
+	)
+
+	w.Header().Set("Location", BasePath+info.ID)
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	info, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	offset, err := h.store.offset(info)
+	if err != nil {
+		utils.WriteError(w, "Failed to read upload", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	if len(info.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatMetadata(info.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != offsetContentType {
+		utils.WriteError(w, "Unsupported content type", http.StatusUnsupportedMediaType, nil)
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || clientOffset < 0 {
+		utils.WriteError(w, "Invalid Upload-Offset", http.StatusBadRequest, err)
+		return
+	}
+
+	if !h.tryLock(id) {
+		utils.WriteError(w, "Upload is in use", http.StatusLocked, nil)
+		return
+	}
+	defer h.unlock(id)
+
+	info, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	offset, err := h.store.offset(info)
+	if err != nil {
+		utils.WriteError(w, "Failed to read upload", http.StatusInternalServerError, err)
+		return
+	}
+	if clientOffset != offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		utils.WriteError(w, "Offset mismatch", http.StatusConflict, nil)
+		return
+	}
+
+	remaining := info.Length - offset
+	if r.ContentLength > remaining {
+		utils.WriteError(w, "Upload exceeds its length", http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+
+	if !info.Completed {
+		n, err := h.store.append(id, r.Body, remaining)
+		offset += n
+		if err != nil {
+			utils.WriteError(w, "Failed to store upload", http.StatusInternalServerError, err)
+			return
+		}
+
+		// A failed assembly is retried by the next PATCH at the full offset
+		if offset == info.Length {
+			if err := h.complete(r.Context(), info); err != nil {
+				utils.WriteError(w, "Failed to assemble upload", http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.tryLock(id) {
+		utils.WriteError(w, "Upload is in use", http.StatusLocked, nil)
+		return
+	}
+	defer h.unlock(id)
+
+	if _, ok := h.lookup(w, r, id); !ok {
+		return
+	}
+	if err := h.store.remove(id); err != nil {
+		utils.WriteError(w, "Failed to remove upload", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete assembles a fully received upload into the backend and drops its
+// data, keeping the metadata until the upload expires. The object is saved
+// on behalf of the tenant that created the upload.
+func (h *Handler) complete(ctx context.Context, info *Info) error {
+	f, err := h.store.open(info.ID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := h.save(middleware.WithTenant(ctx, info.Tenant), info.Path, f); err != nil {
+		h.logger.Error("Failed to assemble upload",
+			zap.String("id", info.ID),
+			zap.String("path", info.Path),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	info.Completed = true
+	if err := h.store.put(info); err != nil {
+		return err
+	}
+	if err := h.store.discardData(info.ID); err != nil {
+		h.logger.Warn("Failed to remove upload data", zap.String("id", info.ID), zap.Error(err))
+	}
+
+	h.logger.Info("Upload completed", zap.String("id", info.ID), zap.String("path", info.Path))
+	return nil
+}
+
+// lookup loads an upload, responding with an error if it does not exist, has
+// expired or belongs to someone else.
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request, id string) (*Info, bool) {
+	info, err := h.store.get(id)
+	if errors.Is(err, errNotFound) {
+		utils.WriteError(w, "Upload not found", http.StatusNotFound, nil)
+		return nil, false
+	} else if err != nil {
+		utils.WriteError(w, "Failed to read upload", http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if time.Now().After(info.ExpiresAt) {
+		utils.WriteError(w, "Upload expired", http.StatusGone, nil)
+		return nil, false
+	}
+	// Only the tenant that created an upload may continue it
+	if !h.authorize(r, http.MethodPut, info.Path) || middleware.TenantOf(r.Context(), info.Path) != info.Tenant {
+		utils.WriteError(w, "Access denied", http.StatusForbidden, nil)
+		return nil, false
+	}
+	return info, true
+}
+
+// tryLock claims an upload for one request. Concurrent requests for the same
+// upload are refused rather than queued, as their offsets would conflict.
+func (h *Handler) tryLock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, busy := h.busy[id]; busy {
+		return false
+	}
+	h.busy[id] = struct{}{}
+	return true
+}
+
+func (h *Handler) unlock(id string) {
+	h.mu.Lock()
+	delete(h.busy, id)
+	h.mu.Unlock()
+}
+
+func (h *Handler) sweep() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.removeExpired(time.Now())
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *Handler) removeExpired(now time.Time) {
+	ids, err := h.store.expired(now)
+	if err != nil {
+		h.logger.Error("Failed to list expired uploads", zap.Error(err))
+		return
+	}
+
+	for _, id := range ids {
+		if !h.tryLock(id) {
+			continue
+		}
+		if err := h.store.remove(id); err != nil {
+			h.logger.Error("Failed to remove expired upload", zap.String("id", id), zap.Error(err))
+		}
+		h.unlock(id)
+	}
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma separated pairs of a
+// key and an optional base64 encoded value.
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
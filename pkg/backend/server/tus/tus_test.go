@@ -0,0 +1,214 @@
+package tus
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/backend/server/middleware"
+	"github.com/veloxpack/storage/pkg/storage/fs"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T, save SaveFunc, opts ...Option) (*Handler, string) {
+		dir := t.TempDir()
+		h, err := New(dir, save, opts...)
+		require.NoError(t, err)
+		t.Cleanup(h.Close)
+		return h, dir
+	}
+
+	do := func(h http.Handler, method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", version)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	create := func(t *testing.T, h http.Handler, path string, length string) string {
+		rec := do(h, http.MethodPost, BasePath, nil, map[string]string{
+			"Upload-Length":   length,
+			"Upload-Metadata": "path " + base64.StdEncoding.EncodeToString([]byte(path)),
+		})
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		location := rec.Header().Get("Location")
+		require.True(t, strings.HasPrefix(location, BasePath))
+		return location
+	}
+
+	patch := func(h http.Handler, location string, offset string, body string) *httptest.ResponseRecorder {
+		return do(h, http.MethodPatch, location, []byte(body), map[string]string{
+			"Upload-Offset": offset,
+			"Content-Type":  offsetContentType,
+		})
+	}
+
+	t.Run("should assemble an upload sent in parts", func(t *testing.T) {
+		backend := fs.NewStorage(fs.Config{Root: t.TempDir()})
+		h, _ := newHandler(t, func(ctx context.Context, path string, content io.Reader) error {
+			return backend.Save(ctx, content, path)
+		})
+
+		location := create(t, h, "vod/movie.mp4", "11")
+
+		rec := patch(h, location, "0", "hello ")
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+		assert.Equal(t, "6", rec.Header().Get("Upload-Offset"))
+
+		rec = do(h, http.MethodHead, location, nil, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "6", rec.Header().Get("Upload-Offset"))
+		assert.Equal(t, "11", rec.Header().Get("Upload-Length"))
+
+		rec = patch(h, location, "6", "world")
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+		assert.Equal(t, "11", rec.Header().Get("Upload-Offset"))
+
+		r, err := backend.Open(ctx, "vod/movie.mp4")
+		require.NoError(t, err)
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		assert.Equal(t, "hello world", string(data))
+	})
+
+	t.Run("should reject a patch at the wrong offset", func(t *testing.T) {
+		h, _ := newHandler(t, func(context.Context, string, io.Reader) error { return nil })
+		location := create(t, h, "vod/a.mp4", "10")
+
+		require.Equal(t, http.StatusNoContent, patch(h, location, "0", "abc").Code)
+
+		rec := patch(h, location, "0", "abc")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, "3", rec.Header().Get("Upload-Offset"))
+	})
+
+	t.Run("should resume after a restart", func(t *testing.T) {
+		var saved []byte
+		save := func(_ context.Context, _ string, content io.Reader) error {
+			saved, _ = io.ReadAll(content)
+			return nil
+		}
+		h, dir := newHandler(t, save)
+		location := create(t, h, "vod/a.mp4", "6")
+		require.Equal(t, http.StatusNoContent, patch(h, location, "0", "abc").Code)
+
+		restarted, err := New(dir, save)
+		require.NoError(t, err)
+		defer restarted.Close()
+
+		rec := do(restarted, http.MethodHead, location, nil, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "3", rec.Header().Get("Upload-Offset"))
+
+		require.Equal(t, http.StatusNoContent, patch(restarted, location, "3", "def").Code)
+		assert.Equal(t, "abcdef", string(saved))
+	})
+
+	t.Run("should retry a failed assembly", func(t *testing.T) {
+		fail := true
+		h, _ := newHandler(t, func(_ context.Context, _ string, content io.Reader) error {
+			if fail {
+				return errors.New("backend unavailable")
+			}
+			_, err := io.ReadAll(content)
+			return err
+		})
+		location := create(t, h, "vod/a.mp4", "3")
+
+		assert.Equal(t, http.StatusInternalServerError, patch(h, location, "0", "abc").Code)
+
+		fail = false
+		rec := patch(h, location, "3", "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "3", rec.Header().Get("Upload-Offset"))
+	})
+
+	t.Run("should enforce the protocol version, size limit and destination access", func(t *testing.T) {
+		deny := func(r *http.Request, method, path string) bool {
+			return !strings.HasPrefix(path, "private/")
+		}
+		h, _ := newHandler(t, func(context.Context, string, io.Reader) error { return nil },
+			WithMaxSize(100), WithAuthorizer(deny))
+
+		req := httptest.NewRequest(http.MethodPost, BasePath, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+		rec = do(h, http.MethodPost, BasePath, nil, map[string]string{
+			"Upload-Length":   "101",
+			"Upload-Metadata": "path " + base64.StdEncoding.EncodeToString([]byte("vod/a.mp4")),
+		})
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+		rec = do(h, http.MethodPost, BasePath, nil, map[string]string{
+			"Upload-Length":   "10",
+			"Upload-Metadata": "path " + base64.StdEncoding.EncodeToString([]byte("private/a.mp4")),
+		})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("should remove terminated and expired uploads", func(t *testing.T) {
+		h, _ := newHandler(t, func(context.Context, string, io.Reader) error { return nil },
+			WithExpiration(time.Minute))
+
+		location := create(t, h, "vod/a.mp4", "10")
+		assert.Equal(t, http.StatusNoContent, do(h, http.MethodDelete, location, nil, nil).Code)
+		assert.Equal(t, http.StatusNotFound, do(h, http.MethodHead, location, nil, nil).Code)
+
+		location = create(t, h, "vod/b.mp4", "10")
+		h.removeExpired(time.Now().Add(2 * time.Minute))
+		assert.Equal(t, http.StatusNotFound, do(h, http.MethodHead, location, nil, nil).Code)
+	})
+	t.Run("should keep uploads to the tenant that created them", func(t *testing.T) {
+		var tenant string
+		h, _ := newHandler(t, func(ctx context.Context, path string, content io.Reader) error {
+			tenant = middleware.TenantOf(ctx, path)
+			return nil
+		})
+
+		as := func(claimTenant string, method, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.Header.Set("Tus-Resumable", version)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			claims := map[string]string{"tenant": claimTenant}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.ClaimsContextKey, claims))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := as("acme", http.MethodPost, BasePath, "", map[string]string{
+			"Upload-Length":   "4",
+			"Upload-Metadata": "path " + base64.StdEncoding.EncodeToString([]byte("shared/a.mp4")),
+		})
+		require.Equal(t, http.StatusCreated, rec.Code)
+		location := rec.Header().Get("Location")
+
+		headers := map[string]string{"Upload-Offset": "0", "Content-Type": offsetContentType}
+		assert.Equal(t, http.StatusForbidden, as("globex", http.MethodHead, location, "", nil).Code)
+		assert.Equal(t, http.StatusForbidden, as("globex", http.MethodPatch, location, "data", headers).Code)
+		assert.Equal(t, http.StatusForbidden, as("globex", http.MethodDelete, location, "", nil).Code)
+
+		rec = as("acme", http.MethodPatch, location, "data", headers)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+		assert.Equal(t, "acme", tenant)
+	})
+}
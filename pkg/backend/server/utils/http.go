@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"mime"
+	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -35,6 +36,15 @@ func ParseBearerToken(r *http.Request) (string, error) {
 	return parts[1], nil
 }
 
+// ClientIP returns the IP address of the remote peer.
+func ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
 func DetermineContentType(headerType, path string) string {
 	if headerType != "" {
 		return headerType
@@ -0,0 +1,23 @@
+package utils
+
+import "mime"
+
+// mediaTypes are the streaming media types that system MIME tables lack or
+// get wrong, such as .ts being taken for a Qt translation file.
+var mediaTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+	".cmfv": "video/mp4",
+	".cmfa": "audio/mp4",
+	".ts":   "video/mp2t",
+	".vtt":  "text/vtt",
+}
+
+func init() {
+	for ext, typ := range mediaTypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// resubmitInterval is how long a queued task waits before it is offered
+// again to a saturated pool.
+const resubmitInterval = 50 * time.Millisecond
+
+// KeyedExecutor runs tasks sharing a key one at a time, in submission order,
+// while tasks for different keys run in parallel.
+//
+// Only the first task for an idle key is submitted to a pool and subject to
+// its capacity and waiting queue. Tasks submitted while the key is busy are
+// queued and run on the same worker once the earlier ones finish; a task for
+// another pool is submitted to its own pool when its turn comes.
+type KeyedExecutor struct {
+	mu      sync.Mutex
+	pending map[string][]keyedTask
+	waiting int
+	logger  *zap.Logger
+}
+
+// keyedTask is a task queued behind a busy key. Tasks without a pool hand
+// the key over to a caller of Run.
+type keyedTask struct {
+	run      func()
+	pool     *Pool
+	priority Priority
+}
+
+// NewKeyedExecutor creates an executor with no busy keys.
+func NewKeyedExecutor() *KeyedExecutor {
+	return &KeyedExecutor{
+		pending: make(map[string][]keyedTask),
+		logger:  zap.L().Named("worker"),
+	}
+}
+
+// Submit runs task on pool after every task previously submitted for key.
+// If key is idle the task is submitted to pool with priority and Submit
+// returns the pool's error when it is rejected.
+func (e *KeyedExecutor) Submit(ctx context.Context, key string, pool *Pool, priority Priority, task func()) error {
+	t := keyedTask{run: task, pool: pool, priority: priority}
+
+	e.mu.Lock()
+	if queued, busy := e.pending[key]; busy {
+		e.pending[key] = append(queued, t)
+		e.waiting++
+		e.mu.Unlock()
+		return nil
+	}
+	e.pending[key] = nil
+	e.mu.Unlock()
+
+	if err := pool.SubmitPriority(ctx, priority, func() { e.drain(key, t) }); err != nil {
+		// Tasks queued behind the rejected one while it waited for a worker
+		// were already accepted and must still run
+		e.release(key)
+		return err
+	}
+	return nil
+}
+
+// Run calls fn on the calling goroutine after every task previously
+// submitted for key, and holds back later tasks until fn returns.
+func (e *KeyedExecutor) Run(key string, fn func()) {
+	e.mu.Lock()
+	if queued, busy := e.pending[key]; busy {
+		turn := make(chan struct{})
+		e.pending[key] = append(queued, keyedTask{run: func() { close(turn) }})
+		e.waiting++
+		e.mu.Unlock()
+		<-turn
+	} else {
+		e.pending[key] = nil
+		e.mu.Unlock()
+	}
+
+	defer e.release(key)
+	fn()
+}
+
+// Waiting returns the number of tasks queued behind a busy key.
+func (e *KeyedExecutor) Waiting() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.waiting
+}
+
+// drain runs t on a worker of its pool, followed by the tasks queued for key
+// behind it for the same pool.
+func (e *KeyedExecutor) drain(key string, t keyedTask) {
+	for {
+		e.run(key, t.run)
+
+		next, ok := e.next(key)
+		if !ok {
+			return
+		}
+		if next.pool != t.pool {
+			e.start(key, next)
+			return
+		}
+		t = next
+	}
+}
+
+// release hands key to the next task queued for it, or marks it idle.
+func (e *KeyedExecutor) release(key string) {
+	if next, ok := e.next(key); ok {
+		e.start(key, next)
+	}
+}
+
+// start gives key to t: a caller of Run continues on its own goroutine, and
+// other tasks are submitted to their pool.
+func (e *KeyedExecutor) start(key string, t keyedTask) {
+	if t.pool == nil {
+		t.run()
+		return
+	}
+	go e.resubmit(key, t)
+}
+
+// resubmit submits a queued task to its pool, retrying while the pool is
+// saturated since the task was already accepted. Tasks for a released pool
+// are dropped.
+func (e *KeyedExecutor) resubmit(key string, t keyedTask) {
+	for {
+		err := t.pool.SubmitPriority(context.Background(), t.priority, func() { e.drain(key, t) })
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrPoolClosed) {
+			e.logger.Warn("Dropping task of a released pool", zap.String("key", key))
+			e.release(key)
+			return
+		}
+		time.Sleep(resubmitInterval)
+	}
+}
+
+// next pops the next queued task for key, or marks key idle if there is none.
+func (e *KeyedExecutor) next(key string) (keyedTask, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	queued := e.pending[key]
+	if len(queued) == 0 {
+		delete(e.pending, key)
+		return keyedTask{}, false
+	}
+
+	t := queued[0]
+	queued[0] = keyedTask{}
+	e.pending[key] = queued[1:]
+	e.waiting--
+	return t, true
+}
+
+// run calls task, recovering from a panic so key does not stay busy forever.
+func (e *KeyedExecutor) run(key string, task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.logger.Error("Task panicked", zap.String("key", key), zap.Any("panic", r))
+		}
+	}()
+	task()
+}
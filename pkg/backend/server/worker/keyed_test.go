@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedExecutor(t *testing.T) {
+	t.Run("should run tasks for the same key in submission order", func(t *testing.T) {
+		uploads, err := NewPool(4)
+		require.NoError(t, err)
+		defer uploads.Release()
+		deletes, err := NewPool(4)
+		require.NoError(t, err)
+		defer deletes.Release()
+
+		e := NewKeyedExecutor()
+
+		var (
+			mu    sync.Mutex
+			order []int
+			wg    sync.WaitGroup
+		)
+		for i := range 20 {
+			pool := uploads
+			if i%2 == 1 {
+				pool = deletes
+			}
+			wg.Add(1)
+			require.NoError(t, e.Submit(context.Background(), "live/index.m3u8", pool, 0, func() {
+				defer wg.Done()
+				// Later tasks finish faster, so only ordering keeps them in line
+				time.Sleep(time.Duration(20-i) * 100 * time.Microsecond)
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+			}))
+		}
+		wg.Wait()
+
+		for i := range order {
+			assert.Equal(t, i, order[i])
+		}
+		assert.Equal(t, 0, e.Waiting())
+	})
+
+	t.Run("should run tasks for different keys in parallel", func(t *testing.T) {
+		pool, err := NewPool(2)
+		require.NoError(t, err)
+		defer pool.Release()
+
+		e := NewKeyedExecutor()
+		release := make(chan struct{})
+		started := make(chan struct{}, 2)
+
+		for _, key := range []string{"a", "b"} {
+			require.NoError(t, e.Submit(context.Background(), key, pool, 0, func() {
+				started <- struct{}{}
+				<-release
+			}))
+		}
+
+		for range 2 {
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+				t.Fatal("tasks for different keys did not run concurrently")
+			}
+		}
+		close(release)
+	})
+
+	t.Run("should hold back later tasks while Run is in progress", func(t *testing.T) {
+		pool, err := NewPool(1)
+		require.NoError(t, err)
+		defer pool.Release()
+
+		e := NewKeyedExecutor()
+		var order []string
+		done := make(chan struct{})
+
+		e.Run("vod/a.ts", func() {
+			require.NoError(t, e.Submit(context.Background(), "vod/a.ts", pool, 0, func() {
+				order = append(order, "delete")
+				close(done)
+			}))
+			time.Sleep(10 * time.Millisecond)
+			order = append(order, "save")
+		})
+		<-done
+
+		assert.Equal(t, []string{"save", "delete"}, order)
+	})
+
+	t.Run("should run tasks queued behind a rejected one on the pool", func(t *testing.T) {
+		pool, err := NewPool(1, WithMaxQueued(1), WithMaxWait(20*time.Millisecond))
+		require.NoError(t, err)
+		defer pool.Release()
+
+		e := NewKeyedExecutor()
+		block := make(chan struct{})
+		require.NoError(t, pool.Submit(func() { <-block }))
+
+		rejected := make(chan error)
+		go func() {
+			rejected <- e.Submit(context.Background(), "vod/a.ts", pool, 0, func() {})
+		}()
+		require.Eventually(t, func() bool { return pool.Queued() == 1 }, time.Second, time.Millisecond)
+
+		ran := make(chan int, 1)
+		require.NoError(t, e.Submit(context.Background(), "vod/a.ts", pool, 0, func() {
+			ran <- pool.Running()
+		}))
+		assert.ErrorIs(t, <-rejected, ErrWaitTimeout)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, ran, "queued task ran while the pool was saturated")
+		close(block)
+
+		select {
+		case running := <-ran:
+			assert.Equal(t, 1, running)
+		case <-time.After(time.Second):
+			t.Fatal("queued task did not run")
+		}
+		assert.Eventually(t, func() bool { return e.Waiting() == 0 }, time.Second, time.Millisecond)
+	})
+}
@@ -1,27 +1,292 @@
 package worker
 
 import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/panjf2000/ants/v2"
 )
 
+// ErrPoolClosed is returned by Submit once the pool has been released.
+var ErrPoolClosed = ants.ErrPoolClosed
+
+var (
+	// ErrQueueFull is returned when every worker is busy and the waiting
+	// queue is full.
+	ErrQueueFull = errors.New("worker pool queue is full")
+	// ErrWaitTimeout is returned when a task waited for a worker longer than
+	// the pool's maximum wait time.
+	ErrWaitTimeout = errors.New("timed out waiting for a worker")
+)
+
+const (
+	// taskDurationWeight is the weight of the latest task in the moving
+	// average used to estimate waiting times.
+	taskDurationWeight = 0.2
+
+	minRetryAfter = time.Second
+	maxRetryAfter = time.Minute
+)
+
+// Priority orders tasks waiting for a worker. Lower values are admitted first;
+// tasks with the same priority are admitted in submission order.
+type Priority int
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// WithMaxQueued lets up to n tasks wait for a worker instead of being
+// rejected when the pool is saturated.
+func WithMaxQueued(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxQueued = max(n, 0)
+	}
+}
+
+// WithMaxWait bounds how long a task waits for a worker. Zero waits until
+// the submitter's context is done.
+func WithMaxWait(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxWait = d
+	}
+}
+
 type Pool struct {
-	pool *ants.Pool
+	pool      *ants.Pool
+	size      int
+	maxQueued int
+	maxWait   time.Duration
+
+	mu      sync.Mutex
+	busy    int
+	waiters waitQueue
+	seq     uint64
+	closed  chan struct{}
+
+	running  atomic.Int64
+	rejected atomic.Int64
+	// avgTask is a moving average of task durations in nanoseconds
+	avgTask atomic.Int64
 }
 
-func NewPool(size int) (*Pool, error) {
-	opts := ants.Options{Nonblocking: true}
-	pool, err := ants.NewPool(size, ants.WithOptions(opts))
-	return &Pool{pool}, err
+// NewPool creates a pool running at most size tasks at once. By default a
+// task submitted while every worker is busy is rejected immediately.
+func NewPool(size int, opts ...PoolOption) (*Pool, error) {
+	pool, err := ants.NewPool(size)
+	p := &Pool{
+		pool:   pool,
+		size:   size,
+		closed: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, err
 }
 
+// Submit runs task with the lowest priority value, waiting for a worker as
+// configured for the pool.
 func (p *Pool) Submit(task func()) error {
-	return p.pool.Submit(task)
+	return p.SubmitPriority(context.Background(), 0, task)
+}
+
+// SubmitPriority runs task once a worker is free. While the pool is
+// saturated, the task waits in a bounded queue ordered by priority; it is
+// rejected if the queue is full, the maximum wait elapses or ctx is done.
+func (p *Pool) SubmitPriority(ctx context.Context, priority Priority, task func()) error {
+	if err := p.acquire(ctx, priority); err != nil {
+		if !errors.Is(err, ErrPoolClosed) {
+			p.rejected.Add(1)
+		}
+		return err
+	}
+
+	p.running.Add(1)
+	err := p.pool.Submit(func() {
+		defer p.release()
+		defer p.running.Add(-1)
+
+		start := time.Now()
+		task()
+		p.observeTask(time.Since(start))
+	})
+	if err != nil {
+		p.running.Add(-1)
+		p.release()
+	}
+	return err
 }
 
 func (p *Pool) Release() {
+	p.mu.Lock()
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+	p.mu.Unlock()
+
 	p.pool.Release()
 }
 
+// Running returns the number of tasks currently executing. Unlike the
+// worker count of the underlying pool it excludes idle workers.
 func (p *Pool) Running() int {
-	return p.pool.Running()
+	return int(p.running.Load())
+}
+
+// Cap returns the maximum number of tasks that can run concurrently.
+func (p *Pool) Cap() int {
+	return p.size
+}
+
+// Queued returns the number of tasks waiting for a worker.
+func (p *Pool) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waiters.Len()
+}
+
+// Rejected returns how many tasks were refused since the pool was created.
+func (p *Pool) Rejected() int64 {
+	return p.rejected.Load()
+}
+
+// RetryAfter estimates how long a rejected submitter should wait before
+// trying again, from the number of queued tasks and recent task durations.
+func (p *Pool) RetryAfter() time.Duration {
+	queued := p.Queued()
+	estimate := time.Duration(p.avgTask.Load()) * time.Duration(queued+1) / time.Duration(max(p.size, 1))
+	return min(max(estimate, minRetryAfter), maxRetryAfter)
+}
+
+// acquire takes a worker slot, waiting in priority order if none is free.
+func (p *Pool) acquire(ctx context.Context, priority Priority) error {
+	p.mu.Lock()
+	select {
+	case <-p.closed:
+		p.mu.Unlock()
+		return ErrPoolClosed
+	default:
+	}
+
+	if p.busy < p.size && p.waiters.Len() == 0 {
+		p.busy++
+		p.mu.Unlock()
+		return nil
+	}
+	if p.waiters.Len() >= p.maxQueued {
+		p.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	w := &waiter{priority: priority, seq: p.seq, ready: make(chan struct{})}
+	p.seq++
+	heap.Push(&p.waiters, w)
+	p.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if p.maxWait > 0 {
+		timer := time.NewTimer(p.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-timeout:
+		err = ErrWaitTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-p.closed:
+		err = ErrPoolClosed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if w.index < 0 {
+		// The slot was handed over while giving up; pass it on
+		p.handOff()
+		return err
+	}
+	heap.Remove(&p.waiters, w.index)
+	return err
+}
+
+// release frees a worker slot, handing it to the most urgent waiter.
+func (p *Pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handOff()
+}
+
+// handOff passes a held slot to the next waiter, or frees it. Callers must
+// hold p.mu.
+func (p *Pool) handOff() {
+	if p.waiters.Len() == 0 {
+		p.busy--
+		return
+	}
+	w := heap.Pop(&p.waiters).(*waiter)
+	close(w.ready)
+}
+
+func (p *Pool) observeTask(d time.Duration) {
+	for {
+		old := p.avgTask.Load()
+		next := int64(d)
+		if old != 0 {
+			next = int64(float64(old)*(1-taskDurationWeight) + float64(d)*taskDurationWeight)
+		}
+		if p.avgTask.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	// index is the waiter's position in the queue, or -1 once it was admitted
+	index int
+}
+
+// waitQueue is a heap of waiters ordered by priority, then submission order.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
 }
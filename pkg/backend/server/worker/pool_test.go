@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	t.Run("should admit waiting tasks by priority", func(t *testing.T) {
+		pool, err := NewPool(1, WithMaxQueued(10))
+		require.NoError(t, err)
+		defer pool.Release()
+
+		release := make(chan struct{})
+		require.NoError(t, pool.Submit(func() { <-release }))
+
+		var (
+			mu    sync.Mutex
+			order []Priority
+			wg    sync.WaitGroup
+		)
+		for _, prio := range []Priority{3, 1, 2, 0} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, pool.SubmitPriority(context.Background(), prio, func() {
+					mu.Lock()
+					order = append(order, prio)
+					mu.Unlock()
+				}))
+			}()
+		}
+		require.Eventually(t, func() bool { return pool.Queued() == 4 }, time.Second, time.Millisecond)
+
+		close(release)
+		wg.Wait()
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(order) == 4
+		}, time.Second, time.Millisecond)
+
+		assert.Equal(t, []Priority{0, 1, 2, 3}, order)
+	})
+
+	t.Run("should reject tasks when the queue is full", func(t *testing.T) {
+		pool, err := NewPool(1)
+		require.NoError(t, err)
+		defer pool.Release()
+
+		release := make(chan struct{})
+		defer close(release)
+		require.NoError(t, pool.Submit(func() { <-release }))
+
+		assert.ErrorIs(t, pool.Submit(func() {}), ErrQueueFull)
+		assert.Equal(t, int64(1), pool.Rejected())
+		assert.GreaterOrEqual(t, pool.RetryAfter(), time.Second)
+	})
+
+	t.Run("should reject tasks that wait too long", func(t *testing.T) {
+		pool, err := NewPool(1, WithMaxQueued(1), WithMaxWait(10*time.Millisecond))
+		require.NoError(t, err)
+		defer pool.Release()
+
+		release := make(chan struct{})
+		defer close(release)
+		require.NoError(t, pool.Submit(func() { <-release }))
+
+		assert.ErrorIs(t, pool.Submit(func() {}), ErrWaitTimeout)
+		assert.Equal(t, 0, pool.Queued())
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package fs
+
+import "errors"
+
+// DiskUsage is not supported on this platform.
+func (fs *Storage) DiskUsage() (free, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd
+
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// DiskUsage reports the free and total bytes of the filesystem holding the
+// storage root. The root itself may not exist yet, in which case its nearest
+// existing parent is inspected.
+func (fs *Storage) DiskUsage() (free, total uint64, err error) {
+	dir := fs.root
+	for {
+		var st unix.Statfs_t
+		err := unix.Statfs(dir, &st)
+		if err == nil {
+			bsize := uint64(st.Bsize)
+			return uint64(st.Bavail) * bsize, uint64(st.Blocks) * bsize, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, 0, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, 0, err
+		}
+		dir = parent
+	}
+}
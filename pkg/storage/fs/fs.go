@@ -2,10 +2,13 @@ package fs
 
 import (
 	"context"
+	"errors"
 	"io"
 	"mime"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/veloxpack/storage/pkg/storage/provider"
 )
@@ -25,31 +28,91 @@ func NewStorage(cfg Config) *Storage {
 	return &Storage{root: cfg.Root}
 }
 
+// String returns the driver name of the storage.
+func (fs *Storage) String() string {
+	return string(provider.Filesystem)
+}
+
+// IsRetryable reports whether err is a transient filesystem error.
+func (fs *Storage) IsRetryable(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EAGAIN,
+		syscall.EBUSY,
+		syscall.EINTR,
+		syscall.EMFILE,
+		syscall.ENFILE,
+		syscall.ETIMEDOUT,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
 func (fs *Storage) abs(path string) string {
 	return filepath.Join(fs.root, path)
 }
 
-// Save saves content to path.
+// tempPrefix starts the names of files being written, which are left out of
+// listings.
+const tempPrefix = ".storage-tmp-"
+
+// StateDir is kept at the root for server state, such as unfinished
+// resumable uploads. It is left out of listings and cannot be addressed as
+// an object.
+const StateDir = ".storage-state"
+
+var errReserved = errors.New("path is reserved for server state")
+
+// StatePath returns the directory for the server state named name.
+func (fs *Storage) StatePath(name string) string {
+	return filepath.Join(fs.root, StateDir, name)
+}
+
+// reserved reports whether path lies in the state directory.
+func reserved(path string) bool {
+	first, _, _ := strings.Cut(strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/"), "/")
+	return first == StateDir
+}
+
+// Save saves content to path. The content is written to a temporary file
+// next to path and renamed over it once complete, so a failed or interrupted
+// write leaves the previous content in place.
 func (fs *Storage) Save(ctx context.Context, content io.Reader, path string) error {
+	if reserved(path) {
+		return errReserved
+	}
 	abs := fs.abs(path)
 	if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
 		return err
 	}
 
-	w, err := os.Create(abs)
+	f, err := os.CreateTemp(filepath.Dir(abs), tempPrefix+filepath.Base(abs)+"-*")
 	if err != nil {
 		return err
 	}
-	defer w.Close()
+	defer os.Remove(f.Name())
 
-	if _, err := io.Copy(w, content); err != nil {
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
 		return err
 	}
-	return nil
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), abs)
 }
 
 // Stat returns path metadata.
 func (fs *Storage) Stat(ctx context.Context, path string) (*provider.Stat, error) {
+	if reserved(path) {
+		return nil, provider.ErrNotExist
+	}
 	fi, err := os.Stat(fs.abs(path))
 	if os.IsNotExist(err) {
 		return nil, provider.ErrNotExist
@@ -62,11 +125,15 @@ func (fs *Storage) Stat(ctx context.Context, path string) (*provider.Stat, error
 		Size:         fi.Size(),
 		ContentType:  mime.TypeByExtension(filepath.Ext(fi.Name())),
 		Name:         fi.Name(),
+		IsDir:        fi.IsDir(),
 	}, nil
 }
 
 // Open opens path for reading.
 func (fs *Storage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if reserved(path) {
+		return nil, provider.ErrNotExist
+	}
 	f, err := os.Open(fs.abs(path))
 	if os.IsNotExist(err) {
 		return nil, provider.ErrNotExist
@@ -76,11 +143,21 @@ func (fs *Storage) Open(ctx context.Context, path string) (io.ReadCloser, error)
 
 // Delete deletes path.
 func (fs *Storage) Delete(ctx context.Context, path string) error {
-	return os.Remove(fs.abs(path))
+	if reserved(path) {
+		return provider.ErrNotExist
+	}
+	err := os.Remove(fs.abs(path))
+	if os.IsNotExist(err) {
+		return provider.ErrNotExist
+	}
+	return err
 }
 
 // List lists path contents.
 func (fs *Storage) List(ctx context.Context, path string) ([]*provider.Stat, error) {
+	if reserved(path) {
+		return nil, provider.ErrNotExist
+	}
 	abs := fs.abs(path)
 	f, err := os.Open(abs)
 	if os.IsNotExist(err) {
@@ -97,11 +174,15 @@ func (fs *Storage) List(ctx context.Context, path string) ([]*provider.Stat, err
 
 	stats := make([]*provider.Stat, 0, len(fis))
 	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), tempPrefix) || reserved(filepath.Join(path, fi.Name())) {
+			continue
+		}
 		stats = append(stats, &provider.Stat{
 			Name:         fi.Name(),
 			Size:         fi.Size(),
 			ModifiedTime: fi.ModTime(),
 			ContentType:  mime.TypeByExtension(filepath.Ext(fi.Name())),
+			IsDir:        fi.IsDir(),
 		})
 	}
 	return stats, nil
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/veloxpack/storage/pkg/storage/provider"
@@ -105,4 +106,47 @@ func TestFS(t *testing.T) {
 		_, err := s.Open(ctx, "world")
 		assert.EqualError(t, err, provider.ErrNotExist.Error())
 	})
+
+	t.Run("should keep the previous content when a write fails", func(t *testing.T) {
+		s := NewStorage(cfg)
+		defer removeDir(cfg.Root)
+
+		ctx := context.Background()
+
+		assert.NoError(t, s.Save(ctx, bytes.NewBufferString("hello"), "world"))
+		err := s.Save(ctx, io.MultiReader(bytes.NewBufferString("bye"), iotest.ErrReader(io.ErrUnexpectedEOF)), "world")
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+		f, err := s.Open(ctx, "world")
+		assert.NoError(t, err)
+		defer f.Close()
+		b, _ := io.ReadAll(f)
+		assert.Equal(t, "hello", string(b))
+
+		stats, err := s.List(ctx, "")
+		assert.NoError(t, err)
+		assert.Len(t, stats, 1)
+	})
+
+	t.Run("should keep the state directory out of reach", func(t *testing.T) {
+		s := NewStorage(cfg)
+		defer removeDir(cfg.Root)
+
+		ctx := context.Background()
+
+		assert.NoError(t, os.MkdirAll(s.StatePath("tus"), 0755))
+		assert.NoError(t, os.WriteFile(s.StatePath("tus")+"/upload.info", []byte("{}"), 0644))
+		assert.NoError(t, s.Save(ctx, bytes.NewBufferString("hello"), "world"))
+
+		stats, err := s.List(ctx, "")
+		assert.NoError(t, err)
+		assert.Len(t, stats, 1)
+
+		_, err = s.Open(ctx, StateDir+"/tus/upload.info")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+		_, err = s.Stat(ctx, "/"+StateDir)
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+		assert.Error(t, s.Save(ctx, bytes.NewBufferString("x"), StateDir+"/tus/upload.info"))
+		assert.ErrorIs(t, s.Delete(ctx, StateDir+"/tus/upload.info"), provider.ErrNotExist)
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"path"
 	"time"
 )
 
@@ -17,12 +18,27 @@ const (
 // Storage is the storage interface.
 type Storage interface {
 	Save(ctx context.Context, content io.Reader, path string) error
+	// Stat returns the metadata of the object at path, or a Stat with IsDir
+	// set if path is a directory; storages without directories report
+	// prefixes that have objects below them as such.
 	Stat(ctx context.Context, path string) (*Stat, error)
 	Open(ctx context.Context, path string) (io.ReadCloser, error)
 	Delete(ctx context.Context, path string) error
 	List(ctx context.Context, path string) ([]*Stat, error)
 }
 
+// RetryClassifier is implemented by storages that can tell transient errors,
+// worth retrying, from permanent ones.
+type RetryClassifier interface {
+	IsRetryable(err error) bool
+}
+
+// RecursiveLister is implemented by storages whose List returns every object
+// below a path, rather than its direct children and directories.
+type RecursiveLister interface {
+	ListsRecursively() bool
+}
+
 // Stat contains metadata about content stored in storage.
 type Stat struct {
 	ModifiedTime time.Time `json:"modified_time"`
@@ -30,6 +46,7 @@ type Stat struct {
 	Name         string    `json:"name"`
 	Path         string    `json:"path"`
 	ContentType  string    `json:"content_type"`
+	IsDir        bool      `json:"is_dir,omitempty"`
 }
 
 type StorageConfig struct {
@@ -37,5 +54,51 @@ type StorageConfig struct {
 	OutputLocation string `yaml:"output_location" json:"output_location" default:"/data" env:"STORAGE_OUTPUT_LOCATION"`
 }
 
+// Walk calls fn with the path and metadata of every object below dir,
+// descending into directories. Storages whose List is already recursive are
+// walked with a single call.
+func Walk(ctx context.Context, s Storage, dir string, fn func(name string, st *Stat) error) error {
+	stats, err := s.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, st := range stats {
+		name := st.Path
+		if name == "" {
+			name = path.Join(dir, st.Name)
+		}
+		if st.IsDir {
+			if err := Walk(ctx, s, name, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(name, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ErrNotExist is a sentinel error returned by the Open and the Stat methods.
 var ErrNotExist = errors.New("file does not exist")
+
+// As finds the first storage in the chain of wrappers around s that
+// implements T. Wrappers expose the storage they decorate through an
+// Unwrap() Storage method.
+func As[T any](s Storage) (T, bool) {
+	for s != nil {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+		u, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
@@ -5,33 +5,60 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path"
 	"time"
 
 	"github.com/veloxpack/storage/pkg/storage/provider"
 	// _ "github.com/rclone/rclone/backend/all" // import all backends
 	_ "github.com/rclone/rclone/backend/s3"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fs/walk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer creates spans for rclone operations. They become children of the
+// caller's span, so provider calls can be followed down to the remote.
+var tracer = otel.Tracer("github.com/veloxpack/storage/pkg/storage/rclone")
+
 type Storage struct {
+	driver string
 	remote string
 }
 
 func NewStorage(driver, outputLocation string) *Storage {
 	return &Storage{
+		driver: driver,
 		remote: fmt.Sprintf(":%s:%s", driver, outputLocation),
 	}
 }
 
+// String returns the rclone backend name of the storage.
+func (r *Storage) String() string {
+	return r.driver
+}
+
+// IsRetryable reports whether err is a transient rclone error, such as a
+// network failure or a throttling response.
+func (r *Storage) IsRetryable(err error) bool {
+	if errors.Is(err, provider.ErrNotExist) || fserrors.IsFatalError(err) || fserrors.IsNoRetryError(err) {
+		return false
+	}
+	return fserrors.IsRetryError(err) || fserrors.ShouldRetry(err)
+}
+
 func (r *Storage) Save(ctx context.Context, content io.Reader, path string) error {
 	dstFs, err := r.newFs(ctx)
 	if err != nil {
 		return err
 	}
 
-	_, err = operations.Rcat(ctx, dstFs, path, io.NopCloser(content), time.Now(), nil)
+	rcatCtx, span := tracer.Start(ctx, "rclone.Rcat")
+	_, err = operations.Rcat(rcatCtx, dstFs, path, io.NopCloser(content), time.Now(), nil)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf("rcat failed: %w", err)
 	}
@@ -45,11 +72,11 @@ func (r *Storage) Stat(ctx context.Context, path string) (*provider.Stat, error)
 		return nil, err
 	}
 
-	obj, err := dstFs.NewObject(ctx, path)
+	obj, err := r.newObject(ctx, dstFs, path)
+	if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) {
+		return r.statDir(ctx, dstFs, path)
+	}
 	if err != nil {
-		if errors.Is(err, fs.ErrorObjectNotFound) {
-			return nil, provider.ErrNotExist
-		}
 		return nil, fmt.Errorf("stat failed: %w", err)
 	}
 
@@ -61,13 +88,39 @@ func (r *Storage) Stat(ctx context.Context, path string) (*provider.Stat, error)
 	}, nil
 }
 
+// statDir reports dir as a directory if the remote lists it. Remotes that
+// can have empty directories fail to list missing ones, so an empty listing
+// is an empty directory; object storages know directories only from the
+// objects they contain and list any prefix.
+func (r *Storage) statDir(ctx context.Context, dstFs fs.Fs, dir string) (*provider.Stat, error) {
+	listCtx, span := tracer.Start(ctx, "rclone.List")
+	entries, err := dstFs.List(listCtx, dir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		endSpan(span, nil)
+		return nil, provider.ErrNotExist
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("stat failed: %w", err)
+	}
+	if len(entries) == 0 && !dstFs.Features().CanHaveEmptyDirectories {
+		return nil, provider.ErrNotExist
+	}
+
+	return &provider.Stat{
+		Name:  path.Base(dir),
+		Path:  dir,
+		IsDir: true,
+	}, nil
+}
+
 func (r *Storage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
 	dstFs, err := r.newFs(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	obj, err := dstFs.NewObject(ctx, path)
+	obj, err := r.newObject(ctx, dstFs, path)
 	if err != nil {
 		if errors.Is(err, fs.ErrorObjectNotFound) {
 			return nil, provider.ErrNotExist
@@ -75,7 +128,10 @@ func (r *Storage) Open(ctx context.Context, path string) (io.ReadCloser, error)
 		return nil, fmt.Errorf("open failed: %w", err)
 	}
 
-	return obj.Open(ctx)
+	ctx, span := tracer.Start(ctx, "rclone.Open")
+	rc, err := obj.Open(ctx)
+	endSpan(span, err)
+	return rc, err
 }
 
 func (r *Storage) Delete(ctx context.Context, path string) error {
@@ -84,7 +140,7 @@ func (r *Storage) Delete(ctx context.Context, path string) error {
 		return err
 	}
 
-	obj, err := dstFs.NewObject(ctx, path)
+	obj, err := r.newObject(ctx, dstFs, path)
 	if err != nil {
 		if errors.Is(err, fs.ErrorObjectNotFound) {
 			return provider.ErrNotExist
@@ -92,7 +148,10 @@ func (r *Storage) Delete(ctx context.Context, path string) error {
 		return fmt.Errorf("delete failed: %w", err)
 	}
 
-	return operations.DeleteFile(ctx, obj)
+	ctx, span := tracer.Start(ctx, "rclone.DeleteFile")
+	err = operations.DeleteFile(ctx, obj)
+	endSpan(span, err)
+	return err
 }
 
 // List lists path contents.
@@ -103,10 +162,12 @@ func (r *Storage) List(ctx context.Context, path string) ([]*provider.Stat, erro
 	}
 
 	var entries fs.DirEntries
-	err = walk.ListR(ctx, dstFs, path, true, -1, walk.ListAll, func(e fs.DirEntries) error {
-		entries = e
+	listCtx, span := tracer.Start(ctx, "rclone.ListR")
+	err = walk.ListR(listCtx, dstFs, path, true, -1, walk.ListAll, func(e fs.DirEntries) error {
+		entries = append(entries, e...)
 		return nil
 	})
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("list failed: %w", err)
 	}
@@ -126,11 +187,38 @@ func (r *Storage) List(ctx context.Context, path string) ([]*provider.Stat, erro
 	return stats, nil
 }
 
+// ListsRecursively reports that List returns every object below a path.
+func (r *Storage) ListsRecursively() bool {
+	return true
+}
+
 func (r *Storage) newFs(ctx context.Context) (fs.Fs, error) {
+	ctx, span := tracer.Start(ctx, "rclone.NewFs")
 	dstFs, err := fs.NewFs(ctx, r.remote)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create fs: %w", err)
 	}
 
 	return dstFs, nil
 }
+
+func (r *Storage) newObject(ctx context.Context, dstFs fs.Fs, path string) (fs.Object, error) {
+	ctx, span := tracer.Start(ctx, "rclone.NewObject")
+	obj, err := dstFs.NewObject(ctx, path)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		endSpan(span, nil)
+	} else {
+		endSpan(span, err)
+	}
+	return obj, err
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,56 @@
+package rclone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veloxpack/storage/pkg/storage/provider"
+)
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should report directories by their base name", func(t *testing.T) {
+		root := t.TempDir()
+		s := NewStorage("local", root)
+		require.NoError(t, s.Save(ctx, strings.NewReader("segment"), "vod/abc/seg1.ts"))
+
+		st, err := s.Stat(ctx, "vod/abc")
+		require.NoError(t, err)
+		assert.True(t, st.IsDir)
+		assert.Equal(t, "abc", st.Name)
+		assert.Equal(t, "vod/abc", st.Path)
+	})
+
+	t.Run("should find empty directories on directory-aware remotes", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "vod", "empty"), 0755))
+		s := NewStorage("local", root)
+
+		st, err := s.Stat(ctx, "vod/empty")
+		require.NoError(t, err)
+		assert.True(t, st.IsDir)
+
+		_, err = s.Stat(ctx, "vod/missing")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+	})
+
+	t.Run("should not report empty prefixes of object storages", func(t *testing.T) {
+		s := NewStorage("memory", "bucket")
+		require.NoError(t, s.Save(ctx, strings.NewReader("segment"), "vod/abc/seg1.ts"))
+
+		st, err := s.Stat(ctx, "vod")
+		require.NoError(t, err)
+		assert.True(t, st.IsDir)
+
+		_, err = s.Stat(ctx, "live")
+		assert.ErrorIs(t, err, provider.ErrNotExist)
+	})
+}